@@ -90,6 +90,9 @@ func (t *Stat) SetClientId(clientId uint64) {
 }
 
 func (t *Stat) ClientId() (uint64, bool) {
+	t.lck.RLock()
+	defer t.lck.RUnlock()
+
 	return t.clientId, t.clientId > 0
 }
 
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"net"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/smallfz/libnfs-go/auth"
 	"github.com/smallfz/libnfs-go/backend"
@@ -15,7 +20,9 @@ import (
 
 func main() {
 	listen := ":2049"
+	portmap := ""
 	flag.StringVar(&listen, "l", listen, "Server listen address")
+	flag.StringVar(&portmap, "portmap", portmap, "Portmapper listen address, e.g. :111 (disabled if empty)")
 	flag.Parse()
 
 	log.UpdateLevel(log.DEBUG)
@@ -41,8 +48,41 @@ func main() {
 		log.Errorf("server.NewServerTCP: %v", err)
 		return
 	}
+	// memfs ignores the credentials: the calls of a connection can be
+	// handled concurrently.
+	svr.MaxInFlight = 16
 
-	if err := svr.Serve(); err != nil {
+	if portmap != "" {
+		ln, err := net.Listen("tcp", portmap)
+		if err != nil {
+			log.Errorf("net.Listen: %v", err)
+			return
+		}
+		go func() {
+			if err := svr.ServePortmap(ln); err != server.ErrServerClosed {
+				log.Errorf("svr.ServePortmap: %v", err)
+			}
+		}()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+		defer cancel()
+		if err := svr.Shutdown(ctx); err != nil {
+			log.Errorf("svr.Shutdown: %v", err)
+		}
+	}()
+
+	if err := svr.Serve(); err != server.ErrServerClosed {
 		log.Errorf("svr.Serve: %v", err)
+		return
 	}
+	<-drained
 }
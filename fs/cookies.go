@@ -0,0 +1,58 @@
+package fs
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// maxSameHash is how many names of the same hash get cookies of their
+// own: the index of a name among them takes the lower 8 bits of its
+// cookie.
+const maxSameHash = 1 << 8
+
+// hashCookie returns the base cookie of a name: the hash of the name in
+// the upper bits, from 256 up to 2^62, so that the cookies stay clear of
+// the reserved cookies 0 to 2 and fit in 63 bits for the clients keeping
+// them as signed offsets.
+func hashCookie(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return (h.Sum64()>>10 + 1) * maxSameHash
+}
+
+// HashDirEntries returns the entries of a directory listed without a
+// DirCursor, in the order of their cookies hashed from their names: the
+// cookie of an entry doesn't depend on the other entries, so that a
+// change of the directory doesn't move the entries following a cookie.
+// The names of the same hash are told apart by their index among them,
+// sorted by name.
+func HashDirEntries(children []FileInfo) []DirEntry {
+	entries := make([]DirEntry, 0, len(children))
+	for _, child := range children {
+		entries = append(entries, DirEntry{Cookie: hashCookie(child.Name()), Info: child})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Cookie != entries[j].Cookie {
+			return entries[i].Cookie < entries[j].Cookie
+		}
+		return entries[i].Info.Name() < entries[j].Info.Name()
+	})
+	for i := 1; i < len(entries); i++ {
+		base := entries[i].Cookie &^ (maxSameHash - 1)
+		prev := entries[i-1].Cookie
+		if prev&^(maxSameHash-1) == base && prev < base+maxSameHash-1 {
+			entries[i].Cookie = prev + 1
+		}
+	}
+	return entries
+}
+
+// EntriesAfter returns the entries following a cookie, in entries sorted
+// by cookie: the listing resumes after the position of the cookie,
+// whether its entry still exists or not.
+func EntriesAfter(entries []DirEntry, cookie uint64) []DirEntry {
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].Cookie > cookie
+	})
+	return entries[i:]
+}
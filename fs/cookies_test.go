@@ -0,0 +1,51 @@
+package fs
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+type testFileInfo string
+
+func (fi testFileInfo) Name() string       { return string(fi) }
+func (fi testFileInfo) Size() int64        { return 0 }
+func (fi testFileInfo) Mode() os.FileMode  { return 0 }
+func (fi testFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi testFileInfo) IsDir() bool        { return false }
+func (fi testFileInfo) Sys() interface{}   { return nil }
+func (fi testFileInfo) ATime() time.Time   { return time.Time{} }
+func (fi testFileInfo) CTime() time.Time   { return time.Time{} }
+func (fi testFileInfo) NumLinks() int      { return 1 }
+
+func TestHashDirEntries(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e", "f", "g"}
+	children := []FileInfo{}
+	for _, name := range names {
+		children = append(children, testFileInfo(name))
+	}
+
+	entries := HashDirEntries(children)
+	cookies := map[string]uint64{}
+	for i, e := range entries {
+		if e.Cookie < 3 || e.Cookie >= 1<<63 || (i > 0 && e.Cookie <= entries[i-1].Cookie) {
+			t.Fatalf("cookie %d of %s", e.Cookie, e.Info.Name())
+		}
+		cookies[e.Info.Name()] = e.Cookie
+	}
+
+	// The cookies don't depend on the other entries.
+	for _, e := range HashDirEntries(children[3:]) {
+		if e.Cookie != cookies[e.Info.Name()] {
+			t.Fatalf("cookie of %s moved: %d, was %d", e.Info.Name(), e.Cookie, cookies[e.Info.Name()])
+		}
+	}
+
+	// A listing resumes after the position of a cookie.
+	if rest := EntriesAfter(entries, entries[2].Cookie); len(rest) != len(entries)-3 || rest[0] != entries[3] {
+		t.Fatalf("EntriesAfter: %v", rest)
+	}
+	if rest := EntriesAfter(HashDirEntries(children[:0]), entries[2].Cookie); len(rest) != 0 {
+		t.Fatalf("EntriesAfter of an empty directory: %v", rest)
+	}
+}
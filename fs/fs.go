@@ -2,6 +2,7 @@
 package fs
 
 import (
+	"errors"
 	"io"
 	"os"
 	"time"
@@ -31,10 +32,44 @@ type File interface {
 	Readdir(int) ([]FileInfo, error)
 }
 
+// DirEntry is an entry of a directory listed by a DirCursor.
+type DirEntry struct {
+	Cookie uint64
+	Info   FileInfo
+}
+
+// DirCursor is implemented by the File of a directory which lists its
+// entries from any of them, e.g. a huge directory of an object store:
+// the servers then list a page of entries for each call, instead of the
+// whole directory.
+type DirCursor interface {
+	// ReaddirFrom returns at most n entries following the entry of a
+	// cookie, or the first ones if cookie is 0, and whether they end the
+	// directory. All the remaining entries are returned if n <= 0.
+	// The cookie of an entry is greater than 2 (NFSv4 reserves 1 and 2),
+	// and keeps its position in the listing: given the cookie of an
+	// entry removed since, ReaddirFrom resumes after where the entry
+	// was, so that a client removing the entries as it lists them
+	// (rm -rf) goes on. Only a cookie never issued is reported with
+	// ErrBadCookie.
+	ReaddirFrom(cookie uint64, n int) ([]DirEntry, bool, error)
+}
+
+// ErrBadCookie is returned by a DirCursor given a cookie it never issued.
+var ErrBadCookie = errors.New("bad cookie")
+
 type WithId interface {
 	Id() uint64
 }
 
+// WithChange is implemented by the FileInfo of a FS counting the changes
+// of its files. Change returns the counter of the file, increasing with
+// each change of its data, its attributes or, for a directory, its
+// entries. Otherwise the change of a file is told by its mtime and ctime.
+type WithChange interface {
+	Change() uint64
+}
+
 // FS is the most essential interface that need to be implemeted in a derived nfs server.
 type FS interface {
 	// SetCreds is called before all other methods to indicate the credentials of the client.
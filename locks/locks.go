@@ -0,0 +1,226 @@
+// Package locks provides the table of the byte-range locks granted by
+// the server: the Network Lock Manager of NFSv3 and the NFSv4 locks
+// share it, so that they exclude each other. It also provides the
+// mutexes by path the handlers of NFSv3 and NFSv4 serialize their calls
+// with.
+package locks
+
+import (
+	"sort"
+	"sync"
+)
+
+// Owner is the owner of a lock.
+type Owner struct {
+	Host     string // the client, e.g. the caller_name of NLM.
+	Id       string // the owner within the client, opaque.
+	ClientId uint64 // the client id of a NFSv4 client, 0 for NLM.
+}
+
+// Lock is a byte-range lock of a file.
+type Lock struct {
+	File      string // the handle of the file.
+	Owner     Owner
+	Exclusive bool
+	Offset    uint64
+	Length    uint64 // 0 locks up to the end of the file, whatever its size.
+}
+
+// end returns the offset following the range locked.
+func (l *Lock) end() uint64 {
+	if l.Length == 0 || l.Offset+l.Length < l.Offset {
+		return ^uint64(0)
+	}
+	return l.Offset + l.Length
+}
+
+func (l *Lock) overlaps(o *Lock) bool {
+	return l.File == o.File && l.Offset < o.end() && o.Offset < l.end()
+}
+
+func (l *Lock) conflicts(o *Lock) bool {
+	return l.overlaps(o) && l.Owner != o.Owner && (l.Exclusive || o.Exclusive)
+}
+
+// withRange returns a copy of l covering [offset, end).
+func (l *Lock) withRange(offset, end uint64) *Lock {
+	r := *l
+	r.Offset = offset
+	r.Length = end - offset
+	if end == ^uint64(0) {
+		r.Length = 0
+	}
+	return &r
+}
+
+// Table is a table of locks, safe for concurrent use.
+type Table struct {
+	mu       sync.Mutex
+	locks    []*Lock
+	watchers []func(file string)
+	recall   func(file string, write bool) bool
+}
+
+func NewTable() *Table {
+	return &Table{}
+}
+
+// Watch registers f to be called once locks of a file have been
+// released or downgraded, e.g. to grant the locks waiting for them.
+func (t *Table) Watch(f func(file string)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.watchers = append(t.watchers, f)
+}
+
+// SetRecall sets f to recall the delegations of a file held by the
+// NFSv4 clients, conflicting with an access by NFSv3 or NLM: f reports
+// whether none of them is left.
+func (t *Table) SetRecall(f func(file string, write bool) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recall = f
+}
+
+// Recall recalls the delegations of a file conflicting with an access
+// by NFSv3 or NLM, writing it if write is set. It reports whether the
+// access can go on: until the delegations are returned, the access is
+// to be retried later.
+func (t *Table) Recall(file string, write bool) bool {
+	t.mu.Lock()
+	recall := t.recall
+	t.mu.Unlock()
+
+	if recall == nil {
+		return true
+	}
+	return recall(file, write)
+}
+
+// Test returns the lock conflicting with l, if any.
+func (t *Table) Test(l Lock) (Lock, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if c := t.conflict(&l); c != nil {
+		return *c, true
+	}
+	return Lock{}, false
+}
+
+func (t *Table) conflict(l *Lock) *Lock {
+	for _, o := range t.locks {
+		if l.conflicts(o) {
+			return o
+		}
+	}
+	return nil
+}
+
+// Lock acquires l, unless a lock of another owner conflicts with it:
+// the conflicting lock is then returned along with false. The range of
+// l replaces the one of the locks of the same owner it overlaps, which
+// upgrades or downgrades them as fcntl(2) does.
+func (t *Table) Lock(l Lock) (Lock, bool) {
+	t.mu.Lock()
+	if c := t.conflict(&l); c != nil {
+		t.mu.Unlock()
+		return *c, false
+	}
+
+	released := t.unlock(&l)
+	t.locks = append(t.locks, &l)
+	t.mu.Unlock()
+
+	if released {
+		t.notify(l.File)
+	}
+	return Lock{}, true
+}
+
+// Unlock releases the range of l locked by its owner.
+func (t *Table) Unlock(l Lock) {
+	t.mu.Lock()
+	released := t.unlock(&l)
+	t.mu.Unlock()
+
+	if released {
+		t.notify(l.File)
+	}
+}
+
+// unlock removes the range of l from the locks of its owner. It reports
+// whether any of them has been released.
+func (t *Table) unlock(l *Lock) bool {
+	released := false
+	locks := []*Lock{}
+	for _, o := range t.locks {
+		if o.Owner != l.Owner || !o.overlaps(l) {
+			locks = append(locks, o)
+			continue
+		}
+		released = true
+		if o.Offset < l.Offset {
+			locks = append(locks, o.withRange(o.Offset, l.Offset))
+		}
+		if l.end() < o.end() {
+			locks = append(locks, o.withRange(l.end(), o.end()))
+		}
+	}
+	t.locks = locks
+	return released
+}
+
+// Release releases all the locks matching match, e.g. the ones of
+// a client which rebooted. It returns how many have been released.
+func (t *Table) Release(match func(Lock) bool) int {
+	t.mu.Lock()
+	files := map[string]bool{}
+	locks := []*Lock{}
+	for _, o := range t.locks {
+		if match(*o) {
+			files[o.File] = true
+			continue
+		}
+		locks = append(locks, o)
+	}
+	n := len(t.locks) - len(locks)
+	t.locks = locks
+	t.mu.Unlock()
+
+	for file := range files {
+		t.notify(file)
+	}
+	return n
+}
+
+// List returns the locks held, ordered by file and offset.
+func (t *Table) List() []Lock {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := make([]Lock, len(t.locks))
+	for i, l := range t.locks {
+		rs[i] = *l
+	}
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].File != rs[j].File {
+			return rs[i].File < rs[j].File
+		}
+		return rs[i].Offset < rs[j].Offset
+	})
+	return rs
+}
+
+func (t *Table) notify(file string) {
+	t.mu.Lock()
+	watchers := make([]func(string), len(t.watchers))
+	copy(watchers, t.watchers)
+	t.mu.Unlock()
+
+	for _, f := range watchers {
+		f(file)
+	}
+}
@@ -0,0 +1,90 @@
+package locks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTable(t *testing.T) {
+	tbl := NewTable()
+
+	alice := Owner{Host: "a", Id: "1"}
+	bob := Owner{Host: "b", Id: "1"}
+
+	released := []string{}
+	tbl.Watch(func(file string) {
+		released = append(released, file)
+	})
+
+	if _, ok := tbl.Lock(Lock{File: "f", Owner: alice, Exclusive: true, Offset: 0, Length: 100}); !ok {
+		t.Fatalf("expects the lock to be granted.")
+	}
+
+	// a shared lock overlapping it.
+	if c, ok := tbl.Lock(Lock{File: "f", Owner: bob, Offset: 50, Length: 10}); ok || c.Owner != alice {
+		t.Fatalf("expects a conflict with alice but get %v, %v", c, ok)
+	}
+
+	// out of the range, or on another file.
+	if _, ok := tbl.Lock(Lock{File: "f", Owner: bob, Offset: 100}); !ok {
+		t.Fatalf("expects the lock to be granted.")
+	}
+	if _, ok := tbl.Test(Lock{File: "g", Owner: bob, Exclusive: true}); ok {
+		t.Fatalf("expects no conflict.")
+	}
+
+	// alice unlocks the middle of her range.
+	tbl.Unlock(Lock{File: "f", Owner: alice, Offset: 40, Length: 20})
+	if len(released) != 1 {
+		t.Fatalf("expects a release to be notified: %v", released)
+	}
+	if _, ok := tbl.Test(Lock{File: "f", Owner: bob, Offset: 45, Length: 10}); ok {
+		t.Fatalf("expects no conflict.")
+	}
+	if _, ok := tbl.Test(Lock{File: "f", Owner: bob, Offset: 59, Length: 2}); !ok {
+		t.Fatalf("expects a conflict.")
+	}
+
+	locks := tbl.List()
+	if len(locks) != 3 || locks[0].Length != 40 || locks[1].Offset != 60 || locks[1].Length != 40 {
+		t.Fatalf("unexpected locks: %+v", locks)
+	}
+
+	// bob's shared lock up to the end of the file.
+	if c, ok := tbl.Test(Lock{File: "f", Owner: alice, Exclusive: true, Offset: 1 << 40}); !ok || c.Owner != bob {
+		t.Fatalf("expects a conflict with bob but get %v, %v", c, ok)
+	}
+
+	if n := tbl.Release(func(l Lock) bool { return l.Owner.Host == "a" }); n != 2 {
+		t.Fatalf("expects 2 locks released but get %d", n)
+	}
+	if locks := tbl.List(); len(locks) != 1 || locks[0].Owner != bob {
+		t.Fatalf("unexpected locks: %+v", locks)
+	}
+}
+
+func TestPathLocks(t *testing.T) {
+	pl := NewPathLocks()
+
+	unlock := pl.Lock("/a")
+	locked := make(chan struct{})
+	go func() {
+		pl.Lock("/a")()
+		close(locked)
+	}()
+
+	// Another path is not locked.
+	pl.Lock("/b")()
+
+	select {
+	case <-locked:
+		t.Fatalf("path locked twice")
+	case <-time.After(time.Millisecond * 50):
+	}
+	unlock()
+	<-locked
+
+	if len(pl.paths) != 0 {
+		t.Fatalf("mutexes kept: %v", pl.paths)
+	}
+}
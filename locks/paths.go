@@ -0,0 +1,47 @@
+package locks
+
+import (
+	"sync"
+)
+
+// PathLocks is a set of mutexes by path, e.g. to serialize the calls
+// working on the same file. The mutex of a path is dropped once nobody
+// holds or waits for it.
+type PathLocks struct {
+	mu    sync.Mutex
+	paths map[string]*pathLock
+}
+
+type pathLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func NewPathLocks() *PathLocks {
+	return &PathLocks{paths: map[string]*pathLock{}}
+}
+
+// Lock locks a path and returns the function unlocking it.
+func (t *PathLocks) Lock(pathName string) func() {
+	t.mu.Lock()
+	l, found := t.paths[pathName]
+	if !found {
+		l = &pathLock{}
+		t.paths[pathName] = l
+	}
+	l.refs++
+	t.mu.Unlock()
+
+	l.mu.Lock()
+
+	return func() {
+		l.mu.Unlock()
+
+		t.mu.Lock()
+		l.refs--
+		if l.refs <= 0 {
+			delete(t.paths, pathName)
+		}
+		t.mu.Unlock()
+	}
+}
@@ -3,6 +3,7 @@ package memfs
 import (
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/smallfz/libnfs-go/fs"
 	"github.com/smallfz/libnfs-go/log"
@@ -105,6 +106,7 @@ func (f *memFile) Truncate() error {
 	log.Printf("memFile.Truncate()")
 	f.buff.Truncate()
 	f.n.size = int64(f.buff.size())
+	f.n.changed()
 	log.Printf("  -- size after: %d", f.buff.size())
 	return nil
 }
@@ -132,3 +134,30 @@ func (f *memFile) Readdir(n int) ([]fs.FileInfo, error) {
 	}
 	return nil, io.EOF
 }
+
+// ReaddirFrom lists the entries of a directory following a cookie, which
+// is the id of an entry. The children of a directory are in the order of
+// their ids, so that the listing resumes after the cookie even if its
+// entry is gone.
+func (f *memFile) ReaddirFrom(cookie uint64, n int) ([]fs.DirEntry, bool, error) {
+	if !f.fi.IsDir() {
+		return nil, false, io.EOF
+	}
+	if cookie != 0 && !f.s.issued(cookie) {
+		return nil, false, fs.ErrBadCookie
+	}
+
+	children := f.n.children
+	start := sort.Search(len(children), func(i int) bool {
+		return children[i].id > cookie
+	})
+
+	rs := []fs.DirEntry{}
+	for i := start; i < len(children) && (n <= 0 || len(rs) < n); i++ {
+		rs = append(rs, fs.DirEntry{
+			Cookie: children[i].id,
+			Info:   f.s.getFileInfo(children[i]),
+		})
+	}
+	return rs, start+len(rs) == len(children), nil
+}
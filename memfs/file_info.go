@@ -13,6 +13,7 @@ type fileInfo struct {
 	modTime  time.Time
 	aTime    time.Time
 	cTime    time.Time
+	change   uint64
 	isDir    bool
 	numLinks int
 }
@@ -41,6 +42,10 @@ func (fi fileInfo) CTime() time.Time {
 	return fi.cTime
 }
 
+func (fi fileInfo) Change() uint64 {
+	return fi.change
+}
+
 func (fi fileInfo) IsDir() bool {
 	return fi.isDir
 }
@@ -28,9 +28,15 @@ type memFsNode struct {
 	cTime    time.Time
 	mTime    time.Time
 	size     int64
+	change   uint64 // counter of changes.
 	children []*memFsNode
 }
 
+// changed counts a change of the node.
+func (n *memFsNode) changed() {
+	n.change++
+}
+
 func (n *memFsNode) findChild(parts []string) (*memFsNode, bool) {
 	if n.children == nil || !n.isDir {
 		return nil, false
@@ -77,6 +83,7 @@ func (n *memFsNode) addChild(child *memFsNode) error {
 
 	n.children = append(n.children, child)
 	n.mTime = time.Now()
+	n.changed()
 
 	return nil
 }
@@ -107,6 +114,7 @@ func (n *memFsNode) removeChild(name string) (*memFsNode, bool) {
 
 	n.children = cList
 	n.mTime = time.Now()
+	n.changed()
 
 	return target, true
 }
@@ -159,6 +167,14 @@ func (s *MemFS) nextId() uint64 {
 	return s.fileId
 }
 
+// issued tells whether an id was given to a file, other than the root.
+func (s *MemFS) issued(id uint64) bool {
+	s.lck.RLock()
+	defer s.lck.RUnlock()
+
+	return id > 1000 && id <= s.fileId
+}
+
 func (s *MemFS) getFileInfo(n *memFsNode) *fileInfo {
 	nlinks := 1
 	if n.isDir {
@@ -175,6 +191,7 @@ func (s *MemFS) getFileInfo(n *memFsNode) *fileInfo {
 		modTime:  n.mTime,
 		aTime:    n.aTime,
 		cTime:    n.cTime,
+		change:   n.change,
 		isDir:    n.isDir,
 		numLinks: nlinks,
 	}
@@ -217,6 +234,7 @@ func (s *MemFS) writeNode(n *memFsNode, dat []byte) {
 	n.mTime = time.Now()
 	n.cTime = time.Now()
 	n.size = int64(s.store.Size(n.nodeId))
+	n.changed()
 }
 
 func (s *MemFS) SetCreds(creds fs.Creds) {}
@@ -328,6 +346,7 @@ func (s *MemFS) Chmod(name string, perm os.FileMode) error {
 
 	n.mTime = time.Now()
 	n.cTime = time.Now()
+	n.changed()
 	return nil
 }
 
@@ -355,6 +374,13 @@ func (s *MemFS) Rename(oldName, newName string) error {
 		return os.ErrNotExist
 	} else {
 		n.name = fs.Base(newName)
+		n.cTime = time.Now()
+		n.changed()
+	}
+
+	if p, found := s.getNode(folder); found {
+		p.mTime = time.Now()
+		p.changed()
 	}
 
 	return nil
@@ -456,12 +482,10 @@ func (s *MemFS) GetRootHandle() []byte {
 
 // ResolveHandle resolves a file-handle(eg. nfs_fh4) to a full path name.
 func (s *MemFS) ResolveHandle(fh []byte) (string, error) {
-	var id uint64
-	if len(fh) <= 8 {
-		id = binary.BigEndian.Uint64(fh)
-	} else {
-		id = binary.BigEndian.Uint64(fh[:8])
+	if len(fh) < 8 {
+		return "", os.ErrNotExist
 	}
+	id := binary.BigEndian.Uint64(fh[:8])
 
 	rs, ok := s.root.findPath(id)
 	if !ok {
@@ -367,3 +367,109 @@ func TestMemfsMkdir(t *testing.T) {
 		}
 	}
 }
+
+func TestMemfsChange(t *testing.T) {
+	vfs := NewMemFS()
+
+	change := func(name string) uint64 {
+		fi, err := vfs.Stat(name)
+		if err != nil {
+			t.Fatalf("Stat(%s): %v", name, err)
+		}
+		return fi.(fs.WithChange).Change()
+	}
+
+	root := change("/")
+	if err := vfs.MkdirAll("/a", os.FileMode(0o755)); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	if c := change("/"); c <= root {
+		t.Fatalf("change of a directory after a mkdir: %d, before %d", c, root)
+	} else {
+		root = c
+	}
+
+	f, err := vfs.OpenFile("/a/b", os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	io.WriteString(f, "b")
+	f.Close()
+	file := change("/a/b")
+
+	if err := vfs.Chmod("/a/b", os.FileMode(0o600)); err != nil {
+		t.Fatalf("Chmod: %v", err)
+	}
+	if c := change("/a/b"); c <= file {
+		t.Fatalf("change of a file after a chmod: %d, before %d", c, file)
+	}
+
+	dir := change("/a")
+	if err := vfs.Rename("/a/b", "/a/c"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if c := change("/a"); c <= dir {
+		t.Fatalf("change of a directory after a rename: %d, before %d", c, dir)
+	}
+	if c := change("/"); c != root {
+		t.Fatalf("change of an unchanged directory: %d, before %d", c, root)
+	}
+}
+
+func TestMemfsReaddirFrom(t *testing.T) {
+	vfs := NewMemFS()
+	for _, name := range []string{"/a", "/b", "/c"} {
+		f, err := vfs.OpenFile(name, os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		f.Close()
+	}
+
+	dir, err := vfs.Open("/")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer dir.Close()
+	c := dir.(fs.DirCursor)
+
+	entries, eof, err := c.ReaddirFrom(0, 2)
+	if err != nil || eof || len(entries) != 2 || entries[0].Info.Name() != "a" {
+		t.Fatalf("ReaddirFrom(0, 2): %v, %v, %v", entries, eof, err)
+	}
+	cookie := entries[1].Cookie
+
+	entries, eof, err = c.ReaddirFrom(cookie, 2)
+	if err != nil || !eof || len(entries) != 1 || entries[0].Info.Name() != "c" {
+		t.Fatalf("ReaddirFrom(%d, 2): %v, %v, %v", cookie, entries, eof, err)
+	}
+
+	// The listing resumes after a cookie while the entries are removed,
+	// as by rm -rf.
+	if err := vfs.Remove("/a"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if entries, _, err := c.ReaddirFrom(cookie, 0); err != nil || len(entries) != 1 {
+		t.Fatalf("ReaddirFrom(%d, 0) after a remove: %v, %v", cookie, entries, err)
+	}
+	if err := vfs.Remove("/b"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	entries, eof, err = c.ReaddirFrom(cookie, 0)
+	if err != nil || !eof || len(entries) != 1 || entries[0].Info.Name() != "c" {
+		t.Fatalf("ReaddirFrom of an entry removed: %v, %v, %v", entries, eof, err)
+	}
+	if err := vfs.Remove("/c"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if entries, eof, err := c.ReaddirFrom(cookie, 0); err != nil || !eof || len(entries) != 0 {
+		t.Fatalf("ReaddirFrom of an empty directory: %v, %v, %v", entries, eof, err)
+	}
+
+	// Only the cookies never issued are invalid.
+	for _, cookie := range []uint64{1, 1000, cookie + 100} {
+		if _, _, err := c.ReaddirFrom(cookie, 0); err != fs.ErrBadCookie {
+			t.Fatalf("ReaddirFrom(%d): %v", cookie, err)
+		}
+	}
+}
@@ -2,7 +2,6 @@ package implv3
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
@@ -28,7 +27,7 @@ func Access(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 	}
 
 	log.Info(fmt.Sprintf(
-		"access: root = %s, access = %x", string(fh3), access,
+		"access: fh3 = %x, access = %x", fh3, access,
 	))
 
 	resp, err := ctx.Authenticate(h.Cred, h.Verf)
@@ -75,25 +74,18 @@ func Access(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 
 	// ---- proc result ---
 
+	objAttrs, stat := handleAttr(ctx.GetFS(), fh3)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, objAttrs)
+	}
+
 	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
 		return sizeConsumed, err
 	}
 
-	now := time.Now()
 	rs := nfs.ACCESS3resok{
-		ObjAttrs: &nfs.PostOpAttr{
-			AttributesFollow: true,
-			Attributes: &nfs.FileAttrs{
-				Type:  nfs.FTYPE_NF3DIR,
-				Mode:  uint32(0o777),
-				Size:  0,
-				Used:  0,
-				ATime: nfs.MakeNfsTime(now),
-				MTime: nfs.MakeNfsTime(now),
-				CTime: nfs.MakeNfsTime(now),
-			},
-		},
-		Access: nfs.ACCESS3_READ | nfs.ACCESS3_LOOKUP | nfs.ACCESS3_MODIFY | nfs.ACCESS3_EXTEND | nfs.ACCESS3_DELETE | nfs.ACCESS3_EXECUTE,
+		ObjAttrs: objAttrs,
+		Access:   nfs.ACCESS3_READ | nfs.ACCESS3_LOOKUP | nfs.ACCESS3_MODIFY | nfs.ACCESS3_EXTEND | nfs.ACCESS3_DELETE | nfs.ACCESS3_EXECUTE,
 	}
 
 	if _, err := w.WriteAny(&rs); err != nil {
@@ -0,0 +1,97 @@
+package implv3
+
+import (
+	"os"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// Commit:
+//
+// SYNOPSIS
+//
+//	COMMIT3res NFSPROC3_COMMIT(COMMIT3args) = 21;
+//
+//	struct COMMIT3args {
+//	     nfs_fh3    file;
+//	     offset3    offset;
+//	     count3     count;
+//	};
+//
+//	struct COMMIT3resok {
+//	     wcc_data   file_wcc;
+//	     writeverf3 verf;
+//	};
+//
+//	struct COMMIT3resfail {
+//	     wcc_data   file_wcc;
+//	};
+func Commit(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling commit.")
+
+	args := &nfs.COMMIT3args{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return 0, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	pathName, err := vfs.ResolveHandle(args.File)
+	if err != nil {
+		log.Warnf("commit: vfs.ResolveHandle(%x): %v", args.File, err)
+		if _, err := w.WriteUint32(nfs.NFS3ERR_STALE); err != nil {
+			return sizeConsumed, err
+		}
+		_, err := w.WriteAny(beginWcc(vfs, "").data())
+		return sizeConsumed, err
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	log.Debugf("commit(%s, offset=%d, count=%d)", pathName, args.Offset, args.Count)
+
+	fileWcc := beginWcc(vfs, pathName)
+
+	// The whole file is flushed, whatever the range asked. The data
+	// written may be lost if it fails.
+	if err := syncFile(ctx, pathName); err != nil {
+		log.Warnf("commit(%s): %v", pathName, err)
+		nfs.ResetWriteVerifier()
+		if _, err := w.WriteUint32(nfs.NFS3err(err)); err != nil {
+			return sizeConsumed, err
+		}
+		_, err := w.WriteAny(fileWcc.data())
+		return sizeConsumed, err
+	}
+
+	res := &nfs.COMMIT3resok{
+		FileWcc: fileWcc.data(),
+		Verf:    nfs.WriteVerifier(),
+	}
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(res)
+	return sizeConsumed, err
+}
+
+func syncFile(ctx nfs.RPCContext, pathName string) error {
+	f, err := ctx.GetFS().OpenFile(pathName, os.O_WRONLY, os.FileMode(0o644))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
@@ -0,0 +1,238 @@
+package implv3
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// createVerifiers remembers the verifiers of the EXCLUSIVE creations,
+// so that a retransmitted CREATE succeeds instead of failing with
+// NFS3ERR_EXIST. fs.FS can't store them along with the file as
+// suggested by rfc1813: the file id is kept instead, so that a verifier
+// doesn't match another file created at the same path since.
+var createVerifiers = &verifierTable{entries: map[string]createVerifier{}}
+
+// createVerifierTTL is how long a verifier is kept: long enough
+// to cover the retransmissions of a call.
+const createVerifierTTL = time.Minute * 10
+
+type createVerifier struct {
+	verf    uint64
+	fileId  uint64
+	created time.Time
+}
+
+type verifierTable struct {
+	mu      sync.Mutex
+	entries map[string]createVerifier
+}
+
+func (t *verifierTable) add(pathName string, verf, fileId uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	for k, v := range t.entries {
+		if now.Sub(v.created) > createVerifierTTL {
+			delete(t.entries, k)
+		}
+	}
+	t.entries[pathName] = createVerifier{verf: verf, fileId: fileId, created: now}
+}
+
+func (t *verifierTable) match(pathName string, verf, fileId uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, found := t.entries[pathName]
+	return found && v.verf == verf && v.fileId == fileId && time.Since(v.created) <= createVerifierTTL
+}
+
+// remove forgets the verifier of a file removed or renamed.
+func (t *verifierTable) remove(pathName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, pathName)
+}
+
+func readCreateArgs(r *xdr.Reader) (*nfs.CREATE3args, int, error) {
+	args := &nfs.CREATE3args{}
+
+	sizeConsumed, err := r.ReadAs(&args.Where)
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+
+	mode, err := r.ReadUint32()
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	sizeConsumed += 4
+	args.Mode = mode
+
+	switch mode {
+	case nfs.UNCHECKED, nfs.GUARDED:
+		attrs, size, err := readSattr3(r)
+		sizeConsumed += size
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		args.Attrs = attrs
+
+	case nfs.EXCLUSIVE:
+		size, err := r.ReadAs(&args.Verf)
+		sizeConsumed += size
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+
+	default:
+		return nil, sizeConsumed, fmt.Errorf("%w: invalid createmode3: %d", nfs.ErrGarbageArgs, mode)
+	}
+
+	return args, sizeConsumed, nil
+}
+
+// Create:
+//
+// SYNOPSIS
+//
+//	CREATE3res NFSPROC3_CREATE(CREATE3args) = 8;
+//
+//	enum createmode3 {
+//	     UNCHECKED = 0,
+//	     GUARDED   = 1,
+//	     EXCLUSIVE = 2
+//	};
+//
+//	union createhow3 switch (createmode3 mode) {
+//	case UNCHECKED:
+//	case GUARDED:
+//	     sattr3       obj_attributes;
+//	case EXCLUSIVE:
+//	     createverf3  verf;
+//	};
+//
+//	struct CREATE3args {
+//	     diropargs3   where;
+//	     createhow3   how;
+//	};
+//
+//	struct CREATE3resok {
+//	     post_op_fh3   obj;
+//	     post_op_attr  obj_attributes;
+//	     wcc_data      dir_wcc;
+//	};
+//
+//	struct CREATE3resfail {
+//	     wcc_data      dir_wcc;
+//	};
+func Create(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling create.")
+
+	args, sizeConsumed, err := readCreateArgs(r)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, pathName, stat := resolveDirOp(vfs, &args.Where)
+	dirWcc := beginWcc(vfs, dirPath)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	if stat := createFile(ctx, pathName, args); stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+	}
+
+	log.Debugf("create(%s, mode=%d): done.", pathName, args.Mode)
+
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(newObject(vfs, dirWcc, pathName))
+	return sizeConsumed, err
+}
+
+// createFile creates a regular file following the mode of args.
+func createFile(ctx nfs.RPCContext, pathName string, args *nfs.CREATE3args) uint32 {
+	vfs := ctx.GetFS()
+
+	fi, err := vfs.Stat(pathName)
+	exists := err == nil
+
+	if exists {
+		switch {
+		case fi.IsDir():
+			return nfs.NFS3ERR_EXIST
+		case args.Mode == nfs.GUARDED:
+			return nfs.NFS3ERR_EXIST
+		case args.Mode == nfs.EXCLUSIVE:
+			// A retransmission of the call succeeds.
+			if createVerifiers.match(pathName, args.Verf, vfs.GetFileId(fi)) {
+				return nfs.NFS3_OK
+			}
+			return nfs.NFS3ERR_EXIST
+		}
+
+		// UNCHECKED: the file is left as it is, but for its size.
+		if args.Attrs != nil && args.Attrs.Size != nil {
+			if stat := recall(ctx, pathName, true); stat != nfs.NFS3_OK {
+				return stat
+			}
+			if err := truncateFile(vfs, pathName, *args.Attrs.Size); err != nil {
+				log.Warnf("create: truncateFile(%s): %v", pathName, err)
+				return nfs.NFS3err(err)
+			}
+		}
+		return nfs.NFS3_OK
+	}
+
+	perm := os.FileMode(0o644)
+	if args.Attrs != nil && args.Attrs.Mode != nil {
+		perm = os.FileMode(*args.Attrs.Mode & 0o7777)
+	}
+
+	f, err := vfs.OpenFile(pathName, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		log.Warnf("create: vfs.OpenFile(%s): %v", pathName, err)
+		return nfs.NFS3err(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Warnf("create: f.Close(%s): %v", pathName, err)
+		return nfs.NFS3err(err)
+	}
+
+	if args.Mode == nfs.EXCLUSIVE {
+		if fi, err := vfs.Stat(pathName); err == nil {
+			createVerifiers.add(pathName, args.Verf, vfs.GetFileId(fi))
+		}
+		return nfs.NFS3_OK
+	}
+
+	if err := setAttrs(vfs, pathName, args.Attrs); err != nil {
+		log.Warnf("create: setAttrs(%s): %v", pathName, err)
+		return nfs.NFS3err(err)
+	}
+	return nfs.NFS3_OK
+}
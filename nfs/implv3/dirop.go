@@ -0,0 +1,65 @@
+package implv3
+
+import (
+	"strings"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// maxName is the longest filename accepted.
+const maxName = 255
+
+// checkName validates a filename of a diropargs3.
+func checkName(name string) uint32 {
+	switch {
+	case name == "", name == ".", name == "..", strings.Contains(name, "/"):
+		return nfs.NFS3ERR_INVAL
+	case len(name) > maxName:
+		return nfs.NFS3ERR_NAMETOOLONG
+	}
+	return nfs.NFS3_OK
+}
+
+// resolveDirOp returns the path of the directory and the path of the
+// file a diropargs3 refers to.
+func resolveDirOp(vfs fs.FS, args *nfs.DirOpArgs3) (string, string, uint32) {
+	dirPath, err := vfs.ResolveHandle(args.Dir)
+	if err != nil {
+		log.Warnf("vfs.ResolveHandle(%x): %v", args.Dir, err)
+		return "", "", nfs.NFS3ERR_STALE
+	}
+
+	di, err := vfs.Stat(dirPath)
+	if err != nil {
+		return dirPath, "", nfs.NFS3err(err)
+	}
+	if !di.IsDir() {
+		return dirPath, "", nfs.NFS3ERR_NOTDIR
+	}
+
+	if stat := checkName(args.Filename); stat != nfs.NFS3_OK {
+		return dirPath, "", stat
+	}
+
+	return dirPath, fs.Join(dirPath, args.Filename), nfs.NFS3_OK
+}
+
+// postOpFh returns the post_op_fh3 of a file.
+func postOpFh(vfs fs.FS, pathName string) *nfs.PostOpFh3yes {
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		return &nfs.PostOpFh3yes{HandleFollow: false}
+	}
+	return postOpFhOf(vfs, fi)
+}
+
+// newObject returns the result of a procedure creating a file.
+func newObject(vfs fs.FS, dirWcc *wcc, pathName string) *nfs.CREATE3resok {
+	return &nfs.CREATE3resok{
+		Obj:      postOpFh(vfs, pathName),
+		ObjAttrs: postOpAttr(vfs, pathName),
+		DirWcc:   dirWcc.data(),
+	}
+}
@@ -2,7 +2,6 @@ package implv3
 
 import (
 	// "fmt"
-	"time"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
@@ -22,7 +21,7 @@ func FsInfo(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 		sizeConsumed += size
 	}
 
-	log.Infof("fsinfo: root = %s", string(fh3))
+	log.Infof("fsinfo: fh3 = %x", fh3)
 
 	resp, err := ctx.Authenticate(h.Cred, h.Verf)
 	if authErr, ok := err.(*nfs.AuthError); ok {
@@ -68,25 +67,18 @@ func FsInfo(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 
 	// ---- proc result ---
 
+	objAttrs, stat := handleAttr(ctx.GetFS(), fh3)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, objAttrs)
+	}
+
 	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
 		return sizeConsumed, err
 	}
 
-	now := time.Now()
 	rs := &nfs.FSINFO3resok{
-		ObjAttrs: &nfs.PostOpAttr{
-			AttributesFollow: true,
-			Attributes: &nfs.FileAttrs{
-				Type:  nfs.FTYPE_NF3DIR,
-				Mode:  uint32(0o755),
-				Size:  1 << 63,
-				Used:  0,
-				ATime: nfs.MakeNfsTime(now),
-				MTime: nfs.MakeNfsTime(now),
-				CTime: nfs.MakeNfsTime(now),
-			},
-		},
-		Rtmax:       1024 * 1024 * 4,
+		ObjAttrs:    objAttrs,
+		Rtmax:       maxRead,
 		Rtpref:      1024 * 1024 * 4,
 		Rtmult:      1,
 		Wtmax:       1024 * 1024 * 64,
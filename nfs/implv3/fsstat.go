@@ -2,7 +2,6 @@ package implv3
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
@@ -21,7 +20,7 @@ func FsStat(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 		sizeConsumed += size
 	}
 
-	log.Info(fmt.Sprintf("fsstat: root = %v", fh3))
+	log.Info(fmt.Sprintf("fsstat: fh3 = %x", fh3))
 
 	resp, err := ctx.Authenticate(h.Cred, h.Verf)
 	if authErr, ok := err.(*nfs.AuthError); ok {
@@ -67,26 +66,19 @@ func FsStat(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 
 	// ---- proc result ---
 
+	objAttrs, stat := handleAttr(ctx.GetFS(), fh3)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, objAttrs)
+	}
+
 	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
 		return sizeConsumed, err
 	}
 
-	now := time.Now()
 	capacity := uint64(1024 * 1024 * 1024 * 1024 * 2)
 
 	rs := &nfs.FSSTAT3resok{
-		ObjAttrs: &nfs.PostOpAttr{
-			AttributesFollow: true,
-			Attributes: &nfs.FileAttrs{
-				Type:  nfs.FTYPE_NF3DIR,
-				Mode:  uint32(0o755),
-				Size:  capacity,
-				Used:  0,
-				ATime: nfs.MakeNfsTime(now),
-				MTime: nfs.MakeNfsTime(now),
-				CTime: nfs.MakeNfsTime(now),
-			},
-		},
+		ObjAttrs: objAttrs,
 		Tbytes:   capacity,
 		Fbytes:   capacity,
 		Abytes:   capacity,
@@ -2,7 +2,6 @@ package implv3
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
@@ -42,7 +41,7 @@ func GetAttr(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 		sizeConsumed += size
 	}
 
-	log.Infof("getattr: fh3 = %s", string(fh3))
+	log.Infof("getattr: fh3 = %x", fh3)
 
 	resp, err := ctx.Authenticate(h.Cred, h.Verf)
 	if authErr, ok := err.(*nfs.AuthError); ok {
@@ -88,53 +87,25 @@ func GetAttr(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 
 	// --- proc result ---
 
-	filename := string(fh3)
-	fs := ctx.GetFS()
-	rsCode := nfs.NFS3_OK
-
-	if fs != nil {
-		if fi, err := fs.Stat(filename); err == nil {
-
-			if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
-				return sizeConsumed, err
-			}
-
-			now := time.Now()
-
-			ftype := nfs.FTYPE_NF3DIR
-			if !fi.IsDir() {
-				ftype = nfs.FTYPE_NF3REG
-			}
-
-			attr := nfs.FileAttrs{
-				Type:   ftype,
-				Mode:   uint32(0o755),
-				Size:   uint64(fi.Size()),
-				Used:   uint64(fi.Size()),
-				Rdev:   nfs.SpecData{},
-				Fsid:   0,
-				FileId: 0,
-				ATime:  nfs.MakeNfsTime(now),
-				MTime:  nfs.MakeNfsTime(fi.ModTime()),
-				CTime:  nfs.MakeNfsTime(fi.ModTime()),
-			}
-			if _, err := w.WriteAny(&attr); err != nil {
-				return sizeConsumed, err
-			}
-			return sizeConsumed, nil
-
-		} else {
-			log.Warn(fmt.Sprintf("fs.Stat(%s): %v", filename, err))
-			rsCode = nfs.NFS3ERR_BADHANDLE
-		}
-	} else {
-		log.Warnf("no filesystem specified.")
-		rsCode = nfs.NFS3ERR_IO
+	vfs := ctx.GetFS()
+
+	pathName, err := vfs.ResolveHandle(fh3)
+	if err != nil {
+		log.Warnf("getattr: vfs.ResolveHandle(%x): %v", fh3, err)
+		_, err := w.WriteUint32(nfs.NFS3ERR_STALE)
+		return sizeConsumed, err
 	}
 
-	if _, err := w.WriteUint32(rsCode); err != nil {
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		log.Warn(fmt.Sprintf("fs.Stat(%s): %v", pathName, err))
+		_, err := w.WriteUint32(nfs.NFS3err(err))
 		return sizeConsumed, err
 	}
 
-	return sizeConsumed, nil
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(fileAttrs(vfs, fi))
+	return sizeConsumed, err
 }
@@ -0,0 +1,100 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// Link:
+//
+// SYNOPSIS
+//
+//	LINK3res NFSPROC3_LINK(LINK3args) = 15;
+//
+//	struct LINK3args {
+//	     nfs_fh3     file;
+//	     diropargs3  link;
+//	};
+//
+//	struct LINK3resok {
+//	     post_op_attr   file_attributes;
+//	     wcc_data       linkdir_wcc;
+//	};
+//
+//	struct LINK3resfail {
+//	     post_op_attr   file_attributes;
+//	     wcc_data       linkdir_wcc;
+//	};
+func Link(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling link.")
+
+	args := &nfs.LINK3args{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, pathName, stat := resolveDirOp(vfs, &args.Link)
+	dirWcc := beginWcc(vfs, dirPath)
+
+	filePath, err := vfs.ResolveHandle(args.File)
+	if err != nil {
+		log.Warnf("link: vfs.ResolveHandle(%x): %v", args.File, err)
+		return sizeConsumed, replyStatus(
+			w, nfs.NFS3ERR_STALE,
+			&nfs.PostOpAttr{AttributesFollow: false},
+			dirWcc.data(),
+		)
+	}
+
+	if stat == nfs.NFS3_OK {
+		stat = linkFile(ctx, filePath, pathName)
+	}
+
+	log.Debugf("link(%s, %s): %d", filePath, pathName, stat)
+
+	res := &nfs.LINK3resok{
+		FileAttrs:  postOpAttr(vfs, filePath),
+		LinkDirWcc: dirWcc.data(),
+	}
+	return sizeConsumed, replyStatus(w, stat, res)
+}
+
+func linkFile(ctx nfs.RPCContext, filePath, pathName string) uint32 {
+	vfs := ctx.GetFS()
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	fi, err := vfs.Stat(filePath)
+	if err != nil {
+		return nfs.NFS3err(err)
+	}
+	if fi.IsDir() {
+		// Hard links to directories are not allowed.
+		return nfs.NFS3ERR_ISDIR
+	}
+
+	if _, err := vfs.Stat(pathName); err == nil {
+		return nfs.NFS3ERR_EXIST
+	}
+	if stat := recall(ctx, filePath, true); stat != nfs.NFS3_OK {
+		return stat
+	}
+
+	if err := vfs.Link(filePath, pathName); err != nil {
+		log.Warnf("link: vfs.Link(%s, %s): %v", filePath, pathName, err)
+		return nfs.NFS3err(err)
+	}
+	return nfs.NFS3_OK
+}
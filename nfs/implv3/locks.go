@@ -0,0 +1,10 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/locks"
+)
+
+// fileLocks serializes the calls working on the same file. Being
+// stateless, each of them opens the file on its own, which a backend
+// (e.g. memfs) may not support concurrently.
+var fileLocks = locks.NewPathLocks()
@@ -1,9 +1,8 @@
 package implv3
 
 import (
-	"bytes"
 	"fmt"
-	"time"
+	"path"
 
 	fstools "github.com/smallfz/libnfs-go/fs"
 	"github.com/smallfz/libnfs-go/log"
@@ -71,87 +70,58 @@ func Lookup(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 
 	// --- proc result ---
 
-	zeros := string([]byte{0})
-	folder := string(bytes.TrimRight(args.Dir, zeros))
-	filename := args.Filename
-	filename = fstools.Abs(fstools.Join(folder, filename))
-
-	fs := ctx.GetFS()
-	rsCode := nfs.NFS3_OK
-
-	if fs != nil {
-
-		di, err := fs.Stat(fstools.Abs(folder))
-		if err != nil {
-			if _, err := w.WriteUint32(nfs.NFS3ERR_ACCES); err != nil {
-				return sizeConsumed, err
-			}
-			attributesFollow := false
-			if _, err := w.WriteAny(attributesFollow); err != nil {
-				return sizeConsumed, err
-			}
-			return sizeConsumed, nil
-		}
+	vfs := ctx.GetFS()
 
-		if fi, err := fs.Stat(filename); err == nil {
-
-			if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
-				return sizeConsumed, err
-			}
-
-			now := time.Now()
-
-			ftype := nfs.FTYPE_NF3DIR
-			if !fi.IsDir() {
-				ftype = nfs.FTYPE_NF3REG
-			}
-
-			attr := &nfs.LOOKUP3resok{
-				Object: []byte(fi.Name()),
-				ObjAttrs: &nfs.PostOpAttr{
-					AttributesFollow: true,
-					Attributes: &nfs.FileAttrs{
-						Type:  ftype,
-						Mode:  uint32(fi.Mode()),
-						ATime: nfs.MakeNfsTime(now),
-						MTime: nfs.MakeNfsTime(fi.ModTime()),
-						CTime: nfs.MakeNfsTime(fi.ModTime()),
-					},
-				},
-				DirAttrs: &nfs.PostOpAttr{
-					AttributesFollow: true,
-					Attributes: &nfs.FileAttrs{
-						Type:  nfs.FTYPE_NF3DIR,
-						Mode:  uint32(di.Mode()),
-						ATime: nfs.MakeNfsTime(now),
-						MTime: nfs.MakeNfsTime(di.ModTime()),
-						CTime: nfs.MakeNfsTime(di.ModTime()),
-					},
-				},
-			}
-			if _, err := w.WriteAny(attr); err != nil {
-				return sizeConsumed, err
-			}
-			return sizeConsumed, nil
-
-		} else {
-			log.Warn(fmt.Sprintf("fs.Stat(%s): %v", filename, err))
-			rsCode = nfs.NFS3ERR_ACCES
-		}
-	} else {
-		log.Warnf("no filesystem specified.")
-		rsCode = nfs.NFS3ERR_IO
+	fail := func(code uint32, dirAttrs *nfs.PostOpAttr) error {
+		return replyStatus(w, code, dirAttrs)
 	}
 
-	if _, err := w.WriteUint32(rsCode); err != nil {
-		return sizeConsumed, err
+	folder, err := vfs.ResolveHandle(args.Dir)
+	if err != nil {
+		log.Warnf("lookup: vfs.ResolveHandle(%x): %v", args.Dir, err)
+		return sizeConsumed, fail(nfs.NFS3ERR_STALE, &nfs.PostOpAttr{AttributesFollow: false})
+	}
+
+	dirAttrs := postOpAttr(vfs, folder)
+
+	if di, err := vfs.Stat(folder); err != nil {
+		return sizeConsumed, fail(nfs.NFS3err(err), dirAttrs)
+	} else if !di.IsDir() {
+		return sizeConsumed, fail(nfs.NFS3ERR_NOTDIR, dirAttrs)
 	}
 
-	// post_op_attr.attributes_follow
-	attributesFollow := false
-	if _, err := w.WriteAny(attributesFollow); err != nil {
+	filename := fstools.Join(folder, args.Filename)
+	switch args.Filename {
+	case ".":
+		filename = folder
+	case "..":
+		filename = path.Dir(folder)
+	}
+
+	fi, err := vfs.Stat(filename)
+	if err != nil {
+		log.Warn(fmt.Sprintf("fs.Stat(%s): %v", filename, err))
+		return sizeConsumed, fail(nfs.NFS3err(err), dirAttrs)
+	}
+
+	fh, err := vfs.GetHandle(fi)
+	if err != nil {
+		log.Warnf("lookup: vfs.GetHandle(%s): %v", filename, err)
+		return sizeConsumed, fail(nfs.NFS3ERR_SERVERFAULT, dirAttrs)
+	}
+
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
 		return sizeConsumed, err
 	}
 
-	return sizeConsumed, nil
+	res := &nfs.LOOKUP3resok{
+		Object: fh,
+		ObjAttrs: &nfs.PostOpAttr{
+			AttributesFollow: true,
+			Attributes:       fileAttrs(vfs, fi),
+		},
+		DirAttrs: dirAttrs,
+	}
+	_, err = w.WriteAny(res)
+	return sizeConsumed, err
 }
@@ -0,0 +1,103 @@
+package implv3
+
+import (
+	"os"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func readMkdirArgs(r *xdr.Reader) (*nfs.MKDIR3args, int, error) {
+	args := &nfs.MKDIR3args{}
+
+	sizeConsumed, err := r.ReadAs(&args.Where)
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+
+	attrs, size, err := readSattr3(r)
+	sizeConsumed += size
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	args.Attrs = attrs
+
+	return args, sizeConsumed, nil
+}
+
+// Mkdir:
+//
+// SYNOPSIS
+//
+//	MKDIR3res NFSPROC3_MKDIR(MKDIR3args) = 9;
+//
+//	struct MKDIR3args {
+//	     diropargs3   where;
+//	     sattr3       attributes;
+//	};
+//
+//	struct MKDIR3resok {
+//	     post_op_fh3   obj;
+//	     post_op_attr  obj_attributes;
+//	     wcc_data      dir_wcc;
+//	};
+//
+//	struct MKDIR3resfail {
+//	     wcc_data      dir_wcc;
+//	};
+func Mkdir(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling mkdir.")
+
+	args, sizeConsumed, err := readMkdirArgs(r)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, pathName, stat := resolveDirOp(vfs, &args.Where)
+	dirWcc := beginWcc(vfs, dirPath)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	if _, err := vfs.Stat(pathName); err == nil {
+		return sizeConsumed, replyStatus(w, nfs.NFS3ERR_EXIST, dirWcc.data())
+	}
+
+	perm := os.FileMode(0o755)
+	if args.Attrs.Mode != nil {
+		perm = os.FileMode(*args.Attrs.Mode & 0o7777)
+	}
+
+	if err := vfs.MkdirAll(pathName, perm|os.ModeDir); err != nil {
+		log.Warnf("mkdir: vfs.MkdirAll(%s): %v", pathName, err)
+		return sizeConsumed, replyStatus(w, nfs.NFS3err(err), dirWcc.data())
+	}
+
+	// The mode has been given to MkdirAll already.
+	attrs := *args.Attrs
+	attrs.Mode = nil
+	if err := setAttrs(vfs, pathName, &attrs); err != nil {
+		log.Warnf("mkdir: setAttrs(%s): %v", pathName, err)
+		return sizeConsumed, replyStatus(w, nfs.NFS3err(err), dirWcc.data())
+	}
+
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(newObject(vfs, dirWcc, pathName))
+	return sizeConsumed, err
+}
@@ -0,0 +1,114 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func readMknodArgs(r *xdr.Reader) (*nfs.MKNOD3args, int, error) {
+	args := &nfs.MKNOD3args{}
+
+	sizeConsumed, err := r.ReadAs(&args.Where)
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+
+	typ, err := r.ReadUint32()
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	sizeConsumed += 4
+	args.Type = typ
+
+	switch typ {
+	case nfs.FTYPE_NF3CHR, nfs.FTYPE_NF3BLK, nfs.FTYPE_NF3SOCK, nfs.FTYPE_NF3FIFO:
+		attrs, size, err := readSattr3(r)
+		sizeConsumed += size
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		args.Attrs = attrs
+	}
+
+	switch typ {
+	case nfs.FTYPE_NF3CHR, nfs.FTYPE_NF3BLK:
+		size, err := r.ReadAs(&args.Spec)
+		sizeConsumed += size
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+	}
+
+	return args, sizeConsumed, nil
+}
+
+// Mknod: special files can't be created through fs.FS, the call
+// fails with NFS3ERR_NOTSUPP(or NFS3ERR_BADTYPE for the types
+// other than the special ones).
+//
+// SYNOPSIS
+//
+//	MKNOD3res NFSPROC3_MKNOD(MKNOD3args) = 11;
+//
+//	struct devicedata3 {
+//	     sattr3     dev_attributes;
+//	     specdata3  spec;
+//	};
+//
+//	union mknoddata3 switch (ftype3 type) {
+//	case NF3CHR:
+//	case NF3BLK:
+//	     devicedata3  device;
+//	case NF3SOCK:
+//	case NF3FIFO:
+//	     sattr3       pipe_attributes;
+//	default:
+//	     void;
+//	};
+//
+//	struct MKNOD3args {
+//	     diropargs3   where;
+//	     mknoddata3   what;
+//	};
+//
+//	struct MKNOD3resok {
+//	     post_op_fh3   obj;
+//	     post_op_attr  obj_attributes;
+//	     wcc_data      dir_wcc;
+//	};
+//
+//	struct MKNOD3resfail {
+//	     wcc_data      dir_wcc;
+//	};
+func Mknod(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling mknod.")
+
+	args, sizeConsumed, err := readMknodArgs(r)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, _, stat := resolveDirOp(vfs, &args.Where)
+	dirWcc := beginWcc(vfs, dirPath)
+	if stat == nfs.NFS3_OK {
+		stat = nfs.NFS3ERR_NOTSUPP
+		if args.Attrs == nil {
+			stat = nfs.NFS3ERR_BADTYPE
+		}
+	}
+
+	log.Debugf("mknod(%s, type=%d): %d", args.Where.Filename, args.Type, stat)
+
+	return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+}
@@ -0,0 +1,17 @@
+//go:build linux || darwin
+
+package implv3
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the uid and gid of a file, known when the fs.FS
+// exposes the stat of the underlying file(e.g. unixfs).
+func fileOwner(fi os.FileInfo) (uint32, uint32) {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return st.Uid, st.Gid
+	}
+	return 0, 0
+}
@@ -1,8 +1,6 @@
 package implv3
 
 import (
-	"time"
-
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
 )
@@ -20,7 +18,7 @@ func PathConf(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 		sizeConsumed += size
 	}
 
-	log.Infof("pathconf: path = %s", string(fh3))
+	log.Infof("pathconf: fh3 = %x", fh3)
 
 	resp, err := ctx.Authenticate(h.Cred, h.Verf)
 	if authErr, ok := err.(*nfs.AuthError); ok {
@@ -66,24 +64,17 @@ func PathConf(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 
 	// --- proc result ---
 
+	objAttrs, stat := handleAttr(ctx.GetFS(), fh3)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, objAttrs)
+	}
+
 	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
 		return sizeConsumed, err
 	}
 
-	now := time.Now()
 	rs := &nfs.PATHCONF3resok{
-		ObjAttrs: &nfs.PostOpAttr{
-			AttributesFollow: true,
-			Attributes: &nfs.FileAttrs{
-				Type:  nfs.FTYPE_NF3DIR,
-				Mode:  uint32(0o755),
-				Size:  1 << 63,
-				Used:  0,
-				ATime: nfs.MakeNfsTime(now),
-				MTime: nfs.MakeNfsTime(now),
-				CTime: nfs.MakeNfsTime(now),
-			},
-		},
+		ObjAttrs:        objAttrs,
 		LinkMax:         1024,
 		NameMax:         64,
 		NoTrunc:         true,
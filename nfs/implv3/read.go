@@ -0,0 +1,135 @@
+package implv3
+
+import (
+	"io"
+	"syscall"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// maxRead is the largest read size, advertised as rtmax by FSINFO.
+const maxRead = 1024 * 1024 * 4
+
+// Read:
+//
+// SYNOPSIS
+//
+//	READ3res NFSPROC3_READ(READ3args) = 6;
+//
+//	struct READ3args {
+//	     nfs_fh3  file;
+//	     offset3  offset;
+//	     count3   count;
+//	};
+//
+//	struct READ3resok {
+//	     post_op_attr   file_attributes;
+//	     count3         count;
+//	     bool           eof;
+//	     opaque         data<>;
+//	};
+//
+//	struct READ3resfail {
+//	     post_op_attr   file_attributes;
+//	};
+func Read(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling read.")
+
+	args := &nfs.READ3args{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return 0, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	pathName, err := vfs.ResolveHandle(args.File)
+	if err != nil {
+		log.Warnf("read: vfs.ResolveHandle(%x): %v", args.File, err)
+		if _, err := w.WriteUint32(nfs.NFS3ERR_STALE); err != nil {
+			return sizeConsumed, err
+		}
+		_, err := w.WriteAny(&nfs.PostOpAttr{AttributesFollow: false})
+		return sizeConsumed, err
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	// A write delegation of the file is recalled first.
+	if stat := recall(ctx, pathName, false); stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, postOpAttr(vfs, pathName))
+	}
+
+	data, eof, err := readFile(ctx, pathName, args.Offset, args.Count)
+	if err != nil {
+		log.Warnf("read(%s): %v", pathName, err)
+		if _, err := w.WriteUint32(nfs.NFS3err(err)); err != nil {
+			return sizeConsumed, err
+		}
+		_, err := w.WriteAny(postOpAttr(vfs, pathName))
+		return sizeConsumed, err
+	}
+
+	log.Debugf("read(%s, offset=%d): %d bytes, eof=%v", pathName, args.Offset, len(data), eof)
+
+	res := &nfs.READ3resok{
+		FileAttrs: postOpAttr(vfs, pathName),
+		Count:     uint32(len(data)),
+		EOF:       eof,
+		Data:      data,
+	}
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(res)
+	return sizeConsumed, err
+}
+
+func readFile(ctx nfs.RPCContext, pathName string, offset uint64, count uint32) ([]byte, bool, error) {
+	f, err := ctx.GetFS().Open(pathName)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	if fi.IsDir() {
+		return nil, false, syscall.EISDIR
+	}
+
+	size := uint64(fi.Size())
+	if offset >= size {
+		return []byte{}, true, nil
+	}
+
+	if count > maxRead {
+		count = maxRead
+	}
+	if rest := size - offset; uint64(count) > rest {
+		count = uint32(rest)
+	}
+
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, false, err
+	}
+
+	data := make([]byte, count)
+	n, err := io.ReadFull(f, data)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, false, err
+	}
+	return data[:n], offset+uint64(n) >= size, nil
+}
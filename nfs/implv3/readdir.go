@@ -0,0 +1,186 @@
+package implv3
+
+import (
+	"errors"
+	"path"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// readdirResOverhead is the encoded size of a READDIR3resok without
+// any entry: status, dir_attributes, cookieverf, the end of the list
+// and eof.
+const readdirResOverhead = 4 + (4 + 84) + 8 + 4 + 4
+
+// entry3Size returns the encoded size of an entry3 in a list.
+func entry3Size(name string) int {
+	return 4 + 8 + 4 + (len(name)+3)/4*4 + 8
+}
+
+// entryPlus3Size returns the encoded size of an entryplus3 in a list.
+func entryPlus3Size(e *nfs.EntryPlus3) int {
+	size := entry3Size(e.Name) + (4 + 84) + 4
+	if e.NameHandle != nil && e.NameHandle.HandleFollow {
+		size += 4 + (len(e.NameHandle.Handle)+3)/4*4
+	}
+	return size
+}
+
+// listDir lists the entries of a directory following a cookie, at most
+// n of them if the directory is a fs.DirCursor, and tells whether they
+// end the directory. Otherwise the whole directory is read, and the
+// cookies are hashed from the names of the entries, as by
+// fs.HashDirEntries.
+func listDir(ctx nfs.RPCContext, dirPath string, cookie uint64, n int) ([]fs.DirEntry, bool, uint32) {
+	vfs := ctx.GetFS()
+
+	di, err := vfs.Stat(dirPath)
+	if err != nil {
+		return nil, false, nfs.NFS3err(err)
+	}
+	if !di.IsDir() {
+		return nil, false, nfs.NFS3ERR_NOTDIR
+	}
+
+	dir, err := vfs.Open(dirPath)
+	if err != nil {
+		return nil, false, nfs.NFS3err(err)
+	}
+	defer dir.Close()
+
+	if c, ok := dir.(fs.DirCursor); ok {
+		entries, eof, err := c.ReaddirFrom(cookie, n)
+		if err != nil {
+			if errors.Is(err, fs.ErrBadCookie) {
+				return nil, false, nfs.NFS3ERR_BAD_COOKIE
+			}
+			return nil, false, nfs.NFS3err(err)
+		}
+		return entries, eof, nfs.NFS3_OK
+	}
+
+	children, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, false, nfs.NFS3err(err)
+	}
+	return fs.EntriesAfter(fs.HashDirEntries(children), cookie), true, nfs.NFS3_OK
+}
+
+// ReadDir: like READDIRPLUS, the cookie of an entry is hashed from its
+// name, unless the directory is a fs.DirCursor giving the cookies.
+//
+// SYNOPSIS
+//
+//	READDIR3res NFSPROC3_READDIR(READDIR3args) = 16;
+//
+//	struct READDIR3args {
+//	     nfs_fh3      dir;
+//	     cookie3      cookie;
+//	     cookieverf3  cookieverf;
+//	     count3       count;
+//	};
+//
+//	struct entry3 {
+//	     fileid3      fileid;
+//	     filename3    name;
+//	     cookie3      cookie;
+//	     entry3       *nextentry;
+//	};
+//
+//	struct dirlist3 {
+//	     entry3       *entries;
+//	     bool         eof;
+//	};
+//
+//	struct READDIR3resok {
+//	     post_op_attr dir_attributes;
+//	     cookieverf3  cookieverf;
+//	     dirlist3     reply;
+//	};
+//
+//	struct READDIR3resfail {
+//	     post_op_attr dir_attributes;
+//	};
+func ReadDir(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling readdir.")
+
+	args := &nfs.READDIR3args{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, err := vfs.ResolveHandle(args.Dir)
+	if err != nil {
+		log.Warnf("readdir: vfs.ResolveHandle(%x): %v", args.Dir, err)
+		return sizeConsumed, replyStatus(
+			w, nfs.NFS3ERR_STALE, &nfs.PostOpAttr{AttributesFollow: false},
+		)
+	}
+
+	entries, eof, stat := readDir(ctx, dirPath, args.Cookie, int(args.Count))
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, postOpAttr(vfs, dirPath))
+	}
+
+	log.Debugf("readdir(%s, cookie=%d): %d entries, eof=%v", dirPath, args.Cookie, len(entries), eof)
+
+	seq := []interface{}{
+		nfs.NFS3_OK,
+		postOpAttr(vfs, dirPath),
+		uint64(0), // cookieverf
+	}
+	for _, entry := range entries {
+		seq = append(seq, true, entry)
+	}
+	seq = append(seq, false, eof)
+
+	for _, v := range seq {
+		if _, err := w.WriteAny(v); err != nil {
+			return sizeConsumed, err
+		}
+	}
+	return sizeConsumed, nil
+}
+
+// readDir lists the entries of a directory following cookie, as many
+// as fit in count bytes of reply.
+func readDir(ctx nfs.RPCContext, dirPath string, cookie uint64, count int) ([]*nfs.Entry3, bool, uint32) {
+	vfs := ctx.GetFS()
+
+	list, eof, stat := listDir(ctx, dirPath, cookie, count/entry3Size("")+1)
+	if stat != nfs.NFS3_OK {
+		return nil, false, stat
+	}
+
+	entries := []*nfs.Entry3{}
+	size := readdirResOverhead
+	for _, e := range list {
+		name := path.Base(e.Info.Name())
+		size += entry3Size(name)
+		if size > count {
+			if len(entries) == 0 {
+				return nil, false, nfs.NFS3ERR_TOOSMALL
+			}
+			return entries, false, nfs.NFS3_OK
+		}
+		entries = append(entries, &nfs.Entry3{
+			FileId: vfs.GetFileId(e.Info),
+			Name:   name,
+			Cookie: e.Cookie,
+		})
+	}
+	return entries, eof, nfs.NFS3_OK
+}
@@ -1,11 +1,8 @@
 package implv3
 
 import (
-	"bytes"
 	"fmt"
-	"time"
 
-	"github.com/smallfz/libnfs-go/fs"
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
 )
@@ -32,52 +29,14 @@ func ReaddirPlus(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 	))
 	log.Debugf(" args.dir : %v", args.Dir)
 
-	resp, err := ctx.Authenticate(h.Cred, h.Verf)
-	if authErr, ok := err.(*nfs.AuthError); ok {
-		rh := &nfs.RPCMsgReply{
-			Xid:       h.Xid,
-			MsgType:   nfs.RPC_REPLY,
-			ReplyStat: nfs.MSG_DENIED,
-		}
-
-		if _, err := w.WriteAny(rh); err != nil {
-			return sizeConsumed, err
-		}
-
-		if _, err := w.WriteUint32(nfs.REJECT_AUTH_ERROR); err != nil {
-			return sizeConsumed, err
-		}
-
-		if _, err := w.WriteUint32(authErr.Code); err != nil {
-			return sizeConsumed, err
-		}
-
-		return sizeConsumed, nil
-	} else if err != nil {
-		return sizeConsumed, err
-	}
-
-	rh := &nfs.RPCMsgReply{
-		Xid:       h.Xid,
-		MsgType:   nfs.RPC_REPLY,
-		ReplyStat: nfs.MSG_ACCEPTED,
-	}
-	if _, err := w.WriteAny(rh); err != nil {
-		return sizeConsumed, err
-	}
-
-	if _, err := w.WriteAny(resp); err != nil {
-		return sizeConsumed, err
-	}
-
-	if _, err := w.WriteUint32(nfs.ACCEPT_SUCCESS); err != nil {
+	if ok, err := accept(h, ctx); !ok || err != nil {
 		return sizeConsumed, err
 	}
 
 	// --- proc result ---
 
 	fail := func(code uint32) error {
-		if _, err := w.WriteUint32(nfs.NFS3ERR_IO); err != nil {
+		if _, err := w.WriteUint32(code); err != nil {
 			return err
 		}
 		attributesFollow := false
@@ -87,114 +46,91 @@ func ReaddirPlus(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 		return nil
 	}
 
-	zeros := string([]byte{0})
-	folder := string(bytes.TrimRight(args.Dir, zeros))
-	folder = fs.Abs(folder)
-
-	log.Debugf(" - dir: %s", folder)
-
 	vfs := ctx.GetFS()
 
-	if vfs != nil {
-		if di, err := vfs.Stat(folder); err != nil {
-			return sizeConsumed, fail(nfs.NFS3ERR_IO)
-		} else {
-			dir, err := vfs.Open(folder)
-			if err != nil {
-				return sizeConsumed, fail(nfs.NFS3ERR_IO)
-			}
-
-			children, err := dir.Readdir(-1)
-			if err != nil {
-				return sizeConsumed, fail(nfs.NFS3ERR_IO)
-			}
-
-			now := time.Now()
-
-			entries := []*nfs.EntryPlus3{}
+	folder, err := vfs.ResolveHandle(args.Dir)
+	if err != nil {
+		log.Warnf("readdirplus: vfs.ResolveHandle(%x): %v", args.Dir, err)
+		return sizeConsumed, fail(nfs.NFS3ERR_STALE)
+	}
 
-			for i, fi := range children {
-				item := fileinfoToEntryPlus3(folder, fi)
+	log.Debugf(" - dir: %s", folder)
 
-				// pathName := fs.Join(folder, fi.Name())
-				// h, err := vfs.GetHandle(pathName)
-				// if err != nil {
-				// 	log.Warnf("vfs.GetHandle: %v", err)
-				// } else {
-				// 	item.NameHandle.Handle = h
-				// }
+	// The entries following the cookie, as many as fit in maxcount
+	// bytes of reply.
+	maxCount := int(args.MaxCount)
+	list, eof, stat := listDir(ctx, folder, args.Cookie, maxCount/(entry3Size("")+88+4)+1)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, fail(stat)
+	}
 
-				item.Cookie = uint64(i + 1)
-				entries = append(entries, item)
+	entries := []*nfs.EntryPlus3{}
+	size := readdirResOverhead
+	for _, e := range list {
+		item := fileinfoToEntryPlus3(vfs, e.Info)
+		item.Cookie = e.Cookie
+		size += entryPlus3Size(item)
+		if size > maxCount {
+			if len(entries) == 0 {
+				return sizeConsumed, fail(nfs.NFS3ERR_TOOSMALL)
 			}
+			eof = false
+			break
+		}
+		entries = append(entries, item)
+	}
 
-			log.Infof(" %d entries found.", len(entries))
-			for _, item := range entries {
-				log.Infof(
-					"  > %s(fileid=%d)",
-					item.Name,
-					item.FileId,
-				)
-			}
+	log.Infof(" %d entries found.", len(entries))
+	for _, item := range entries {
+		log.Infof(
+			"  > %s(fileid=%d)",
+			item.Name,
+			item.FileId,
+		)
+	}
 
-			dirAttrs := &nfs.PostOpAttr{
-				AttributesFollow: true,
-				Attributes: &nfs.FileAttrs{
-					Type:  nfs.FTYPE_NF3DIR,
-					Mode:  uint32(di.Mode()),
-					ATime: nfs.MakeNfsTime(now),
-					MTime: nfs.MakeNfsTime(di.ModTime()),
-					CTime: nfs.MakeNfsTime(di.ModTime()),
-				},
-			}
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
 
-			if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
-				return sizeConsumed, err
-			}
+	// READDIRPLUS3resok.dir_attributes
+	if _, err := w.WriteAny(postOpAttr(vfs, folder)); err != nil {
+		return sizeConsumed, err
+	}
 
-			// READDIRPLUS3resok.dir_attributes
-			if _, err := w.WriteAny(dirAttrs); err != nil {
-				return sizeConsumed, err
-			}
+	// READDIRPLUS3resok.cookieverf
+	// (a fixed-size opaque: not to be written as a []byte.)
+	cookieverf := uint64(0)
+	if _, err := w.WriteAny(cookieverf); err != nil {
+		return sizeConsumed, err
+	}
 
-			// READDIRPLUS3resok.cookieverf
-			cookieverf := make([]byte, 8)
-			if _, err := w.WriteAny(cookieverf); err != nil {
+	// dirlistplus3.entries
+	if len(entries) > 0 {
+		if _, err := w.WriteAny(true); err != nil {
+			return sizeConsumed, err
+		}
+		for i, entry := range entries {
+			if _, err := w.WriteAny(entry); err != nil {
 				return sizeConsumed, err
-			}
-
-			// dirlistplus3.entries
-			if len(entries) > 0 {
-				if _, err := w.WriteAny(true); err != nil {
-					return sizeConsumed, err
-				}
-				for i, entry := range entries {
-					if _, err := w.WriteAny(entry); err != nil {
-						return sizeConsumed, err
-					} else {
-						// has next
-						hasNext := i < len(entries)-1
-						if _, err := w.WriteAny(hasNext); err != nil {
-							return sizeConsumed, err
-						}
-					}
-				}
 			} else {
-				if _, err := w.WriteAny(false); err != nil {
+				// has next
+				hasNext := i < len(entries)-1
+				if _, err := w.WriteAny(hasNext); err != nil {
 					return sizeConsumed, err
 				}
 			}
-
-			// dirlistplus3.eof
-			eof := true
-			if _, err := w.WriteAny(eof); err != nil {
-				return sizeConsumed, err
-			}
-
-			return sizeConsumed, nil
 		}
+	} else {
+		if _, err := w.WriteAny(false); err != nil {
+			return sizeConsumed, err
+		}
+	}
+
+	// dirlistplus3.eof
+	if _, err := w.WriteAny(eof); err != nil {
+		return sizeConsumed, err
 	}
 
-	log.Warnf("no filesystem specified.")
-	return sizeConsumed, fail(nfs.NFS3ERR_IO)
+	return sizeConsumed, nil
 }
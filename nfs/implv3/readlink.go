@@ -0,0 +1,66 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// ReadLink:
+//
+// SYNOPSIS
+//
+//	READLINK3res NFSPROC3_READLINK(READLINK3args) = 5;
+//
+//	struct READLINK3args {
+//	     nfs_fh3  symlink;
+//	};
+//
+//	struct READLINK3resok {
+//	     post_op_attr   symlink_attributes;
+//	     nfspath3       data;
+//	};
+//
+//	struct READLINK3resfail {
+//	     post_op_attr   symlink_attributes;
+//	};
+func ReadLink(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling readlink.")
+
+	fh := []byte{}
+	sizeConsumed, err := r.ReadAs(&fh)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	pathName, err := vfs.ResolveHandle(fh)
+	if err != nil {
+		log.Warnf("readlink: vfs.ResolveHandle(%x): %v", fh, err)
+		return sizeConsumed, replyStatus(
+			w, nfs.NFS3ERR_STALE, &nfs.PostOpAttr{AttributesFollow: false},
+		)
+	}
+
+	target, err := vfs.Readlink(pathName)
+	if err != nil {
+		log.Warnf("readlink: vfs.Readlink(%s): %v", pathName, err)
+		return sizeConsumed, replyStatus(w, nfs.NFS3err(err), postOpAttr(vfs, pathName))
+	}
+
+	log.Debugf("readlink(%s): %s", pathName, target)
+
+	res := &nfs.READLINK3resok{
+		SymlinkAttrs: postOpAttr(vfs, pathName),
+		Data:         target,
+	}
+	return sizeConsumed, replyStatus(w, nfs.NFS3_OK, res)
+}
@@ -0,0 +1,37 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// LockHolder is implemented by a nfs.RPCContext giving access to the
+// table of the locks of the server, shared with NLM and NFSv4.
+type LockHolder interface {
+	LockTable() *locks.Table
+}
+
+// recall recalls the delegations of a file to the NFSv4 clients
+// conflicting with an access, writing it if write is set. It returns
+// NFS3ERR_JUKEBOX until they're returned, for the client to retry.
+func recall(ctx nfs.RPCContext, pathName string, write bool) uint32 {
+	h, ok := ctx.(LockHolder)
+	if !ok || h.LockTable() == nil {
+		return nfs.NFS3_OK
+	}
+
+	vfs := ctx.GetFS()
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		// Nothing to recall: the access fails on its own.
+		return nfs.NFS3_OK
+	}
+	fh, err := vfs.GetHandle(fi)
+	if err != nil {
+		return nfs.NFS3_OK
+	}
+	if !h.LockTable().Recall(string(fh), write) {
+		return nfs.NFS3ERR_JUKEBOX
+	}
+	return nfs.NFS3_OK
+}
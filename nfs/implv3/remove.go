@@ -0,0 +1,87 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// Remove:
+//
+// SYNOPSIS
+//
+//	REMOVE3res NFSPROC3_REMOVE(REMOVE3args) = 12;
+//
+//	struct REMOVE3args {
+//	     diropargs3  object;
+//	};
+//
+//	struct REMOVE3resok {
+//	     wcc_data    dir_wcc;
+//	};
+//
+//	struct REMOVE3resfail {
+//	     wcc_data    dir_wcc;
+//	};
+func Remove(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling remove.")
+
+	args := &nfs.DirOpArgs3{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, pathName, stat := resolveDirOp(vfs, args)
+	dirWcc := beginWcc(vfs, dirPath)
+	if stat == nfs.NFS3_OK {
+		stat = removeFile(ctx, pathName, false)
+	}
+
+	log.Debugf("remove(%s): %d", pathName, stat)
+
+	return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+}
+
+// removeFile removes a file, or an empty directory if dir is true.
+func removeFile(ctx nfs.RPCContext, pathName string, dir bool) uint32 {
+	vfs := ctx.GetFS()
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		return nfs.NFS3err(err)
+	}
+	if fi.IsDir() != dir {
+		if dir {
+			return nfs.NFS3ERR_NOTDIR
+		}
+		return nfs.NFS3ERR_ISDIR
+	}
+	if stat := recall(ctx, pathName, true); stat != nfs.NFS3_OK {
+		return stat
+	}
+
+	if err := vfs.Remove(pathName); err != nil {
+		log.Warnf("vfs.Remove(%s): %v", pathName, err)
+		stat := nfs.NFS3err(err)
+		if dir && stat == nfs.NFS3ERR_EXIST {
+			// e.g. memfs, refusing to remove a non-empty directory.
+			stat = nfs.NFS3ERR_NOTEMPTY
+		}
+		return stat
+	}
+	createVerifiers.remove(pathName)
+	return nfs.NFS3_OK
+}
@@ -0,0 +1,190 @@
+package implv3
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// Rename:
+//
+// SYNOPSIS
+//
+//	RENAME3res NFSPROC3_RENAME(RENAME3args) = 14;
+//
+//	struct RENAME3args {
+//	     diropargs3   from;
+//	     diropargs3   to;
+//	};
+//
+//	struct RENAME3resok {
+//	     wcc_data     fromdir_wcc;
+//	     wcc_data     todir_wcc;
+//	};
+//
+//	struct RENAME3resfail {
+//	     wcc_data     fromdir_wcc;
+//	     wcc_data     todir_wcc;
+//	};
+func Rename(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling rename.")
+
+	args := &nfs.RENAME3args{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	fromDir, from, stat := resolveDirOp(vfs, &args.From)
+	toDir, to, toStat := resolveDirOp(vfs, &args.To)
+	fromWcc, toWcc := beginWcc(vfs, fromDir), beginWcc(vfs, toDir)
+	if stat == nfs.NFS3_OK {
+		stat = toStat
+	}
+	if stat == nfs.NFS3_OK {
+		stat = renameFile(ctx, from, to)
+	}
+
+	log.Debugf("rename(%s, %s): %d", from, to, stat)
+
+	res := &nfs.RENAME3resok{
+		FromDirWcc: fromWcc.data(),
+		ToDirWcc:   toWcc.data(),
+	}
+	return sizeConsumed, replyStatus(w, stat, res)
+}
+
+// renameFile renames from to to, replacing the file to if any as
+// rename(2) does.
+func renameFile(ctx nfs.RPCContext, from, to string) uint32 {
+	vfs := ctx.GetFS()
+
+	if from == to {
+		unlock := fileLocks.Lock(from)
+		defer unlock()
+
+		if _, err := vfs.Stat(from); err != nil {
+			return nfs.NFS3err(err)
+		}
+		return nfs.NFS3_OK
+	}
+
+	// The files and their directories, locked in order not to deadlock
+	// with a rename the other way: the directories stay as they are
+	// until the target replaced is removed.
+	paths := []string{from, to, path.Dir(from), path.Dir(to)}
+	sort.Strings(paths)
+	for i, pathName := range paths {
+		if i == 0 || pathName != paths[i-1] {
+			unlock := fileLocks.Lock(pathName)
+			defer unlock()
+		}
+	}
+
+	fi, err := vfs.Stat(from)
+	if err != nil {
+		return nfs.NFS3err(err)
+	}
+	if fi.IsDir() && strings.HasPrefix(to, from+"/") {
+		// A directory can't be moved into itself.
+		return nfs.NFS3ERR_INVAL
+	}
+
+	ti, err := vfs.Stat(to)
+	if err == nil {
+		switch {
+		case fi.IsDir() && !ti.IsDir():
+			return nfs.NFS3ERR_NOTDIR
+		case !fi.IsDir() && ti.IsDir():
+			return nfs.NFS3ERR_ISDIR
+		}
+	}
+	targetExists := err == nil
+
+	// The delegations of the file, and of the one it replaces, are
+	// recalled first.
+	for _, pathName := range []string{from, to} {
+		if stat := recall(ctx, pathName, true); stat != nfs.NFS3_OK {
+			return stat
+		}
+	}
+
+	err = vfs.Rename(from, to)
+	if err != nil && os.IsExist(err) && targetExists {
+		// The fs.FS doesn't replace the target(e.g. memfs).
+		return replaceFile(vfs, from, to)
+	}
+	if err != nil {
+		log.Warnf("rename: vfs.Rename(%s, %s): %v", from, to, err)
+		return nfs.NFS3err(err)
+	}
+	createVerifiers.remove(from)
+	createVerifiers.remove(to)
+	return nfs.NFS3_OK
+}
+
+// replaceFile renames from to to in a fs.FS not replacing the files:
+// the target is moved aside first, and removed only once from took its
+// place. Each step is undone on failure, so that neither file is lost.
+func replaceFile(vfs fs.FS, from, to string) uint32 {
+	aside := ""
+	for i := 0; aside == ""; i++ {
+		name := path.Join(path.Dir(to), fmt.Sprintf(".nfs%016x%d", time.Now().UnixNano(), i))
+		if _, err := vfs.Stat(name); os.IsNotExist(err) {
+			aside = name
+		} else if err != nil {
+			log.Warnf("rename: vfs.Stat(%s): %v", name, err)
+			return nfs.NFS3err(err)
+		}
+	}
+	unlock := fileLocks.Lock(aside)
+	defer unlock()
+
+	if err := vfs.Rename(to, aside); err != nil {
+		log.Warnf("rename: vfs.Rename(%s, %s): %v", to, aside, err)
+		return nfs.NFS3err(err)
+	}
+
+	if err := vfs.Rename(from, to); err != nil {
+		log.Warnf("rename: vfs.Rename(%s, %s): %v", from, to, err)
+		if err := vfs.Rename(aside, to); err != nil {
+			log.Errorf("rename: vfs.Rename(%s, %s): %v", aside, to, err)
+		}
+		return nfs.NFS3err(err)
+	}
+
+	if err := vfs.Remove(aside); err != nil {
+		// e.g. a directory not empty.
+		log.Warnf("rename: vfs.Remove(%s): %v", aside, err)
+		if err := vfs.Rename(to, from); err != nil {
+			log.Errorf("rename: vfs.Rename(%s, %s): %v", to, from, err)
+		} else if err := vfs.Rename(aside, to); err != nil {
+			log.Errorf("rename: vfs.Rename(%s, %s): %v", aside, to, err)
+		}
+		if os.IsExist(err) {
+			return nfs.NFS3ERR_NOTEMPTY
+		}
+		return nfs.NFS3err(err)
+	}
+
+	createVerifiers.remove(from)
+	createVerifiers.remove(to)
+	return nfs.NFS3_OK
+}
@@ -0,0 +1,71 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// accept authenticates the call and writes the header of its reply.
+// It reports false if the credential has been rejected: the reply
+// is then complete.
+func accept(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (bool, error) {
+	w := ctx.Writer()
+
+	resp, err := ctx.Authenticate(h.Cred, h.Verf)
+	if authErr, ok := err.(*nfs.AuthError); ok {
+		rh := &nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_DENIED,
+		}
+
+		if _, err := w.WriteAny(rh); err != nil {
+			return false, err
+		}
+
+		if _, err := w.WriteUint32(nfs.REJECT_AUTH_ERROR); err != nil {
+			return false, err
+		}
+
+		if _, err := w.WriteUint32(authErr.Code); err != nil {
+			return false, err
+		}
+
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	rh := &nfs.RPCMsgReply{
+		Xid:       h.Xid,
+		MsgType:   nfs.RPC_REPLY,
+		ReplyStat: nfs.MSG_ACCEPTED,
+	}
+	if _, err := w.WriteAny(rh); err != nil {
+		return false, err
+	}
+
+	if _, err := w.WriteAny(resp); err != nil {
+		return false, err
+	}
+
+	if _, err := w.WriteUint32(nfs.ACCEPT_SUCCESS); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// replyStatus writes the status of a procedure along with the body of
+// its result, for the procedures whose resok and resfail are alike.
+func replyStatus(w *xdr.Writer, status uint32, resfail ...interface{}) error {
+	if _, err := w.WriteUint32(status); err != nil {
+		return err
+	}
+	for _, v := range resfail {
+		if _, err := w.WriteAny(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
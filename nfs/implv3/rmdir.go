@@ -0,0 +1,53 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// Rmdir:
+//
+// SYNOPSIS
+//
+//	RMDIR3res NFSPROC3_RMDIR(RMDIR3args) = 13;
+//
+//	struct RMDIR3args {
+//	     diropargs3  object;
+//	};
+//
+//	struct RMDIR3resok {
+//	     wcc_data    dir_wcc;
+//	};
+//
+//	struct RMDIR3resfail {
+//	     wcc_data    dir_wcc;
+//	};
+func Rmdir(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling rmdir.")
+
+	args := &nfs.DirOpArgs3{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, pathName, stat := resolveDirOp(vfs, args)
+	dirWcc := beginWcc(vfs, dirPath)
+	if stat == nfs.NFS3_OK {
+		stat = removeFile(ctx, pathName, true)
+	}
+
+	log.Debugf("rmdir(%s): %d", pathName, stat)
+
+	return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+}
@@ -0,0 +1,134 @@
+package implv3
+
+import (
+	"io"
+	"os"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// readSattr3 decodes a sattr3:
+//
+//	struct sattr3 {
+//	     set_mode3   mode;
+//	     set_uid3    uid;
+//	     set_gid3    gid;
+//	     set_size3   size;
+//	     set_atime   atime;
+//	     set_mtime   mtime;
+//	};
+//
+// Each of its fields is a union discriminated by a bool(or a time_how
+// for the times) telling whether the value follows.
+func readSattr3(r *xdr.Reader) (*nfs.Sattr3, int, error) {
+	sizeConsumed := 0
+	attrs := &nfs.Sattr3{}
+
+	for _, v := range []**uint32{&attrs.Mode, &attrs.Uid, &attrs.Gid} {
+		set, err := r.ReadUint32()
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		sizeConsumed += 4
+		if set == 0 {
+			continue
+		}
+		val, err := r.ReadUint32()
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		sizeConsumed += 4
+		*v = &val
+	}
+
+	set, err := r.ReadUint32()
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	sizeConsumed += 4
+	if set != 0 {
+		size := uint64(0)
+		n, err := r.ReadAs(&size)
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		sizeConsumed += n
+		attrs.Size = &size
+	}
+
+	for _, t := range []*nfs.SetTime3{&attrs.ATime, &attrs.MTime} {
+		how, err := r.ReadUint32()
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		sizeConsumed += 4
+		t.How = how
+		if how == nfs.SET_TO_CLIENT_TIME {
+			n, err := r.ReadAs(&t.Time)
+			if err != nil {
+				return nil, sizeConsumed, err
+			}
+			sizeConsumed += n
+		}
+	}
+
+	return attrs, sizeConsumed, nil
+}
+
+// setAttrs applies attrs to a file. Times can't be set through fs.FS
+// and are ignored: SETATTR refuses the times of the client beforehand,
+// while the server time is what the file system stamps anyway.
+func setAttrs(vfs fs.FS, pathName string, attrs *nfs.Sattr3) error {
+	if attrs == nil {
+		return nil
+	}
+
+	if attrs.Mode != nil {
+		if err := vfs.Chmod(pathName, os.FileMode(*attrs.Mode&0o7777)); err != nil {
+			return err
+		}
+	}
+
+	if attrs.Uid != nil || attrs.Gid != nil {
+		uid, gid := -1, -1
+		if attrs.Uid != nil {
+			uid = int(*attrs.Uid)
+		}
+		if attrs.Gid != nil {
+			gid = int(*attrs.Gid)
+		}
+		if err := vfs.Chown(pathName, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	if attrs.Size != nil {
+		if err := truncateFile(vfs, pathName, *attrs.Size); err != nil {
+			return err
+		}
+	}
+
+	if attrs.ATime.How != nfs.DONT_CHANGE || attrs.MTime.How != nfs.DONT_CHANGE {
+		log.Debugf("setattr(%s): times ignored.", pathName)
+	}
+
+	return nil
+}
+
+// truncateFile changes the size of a file: as fs.File.Truncate takes no
+// size, the file is truncated at size.
+func truncateFile(vfs fs.FS, pathName string, size uint64) error {
+	f, err := vfs.OpenFile(pathName, os.O_WRONLY, os.FileMode(0o644))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(int64(size), io.SeekStart); err != nil {
+		return err
+	}
+	return f.Truncate()
+}
@@ -0,0 +1,138 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func readSetAttrArgs(r *xdr.Reader) (*nfs.SETATTR3args, int, error) {
+	args := &nfs.SETATTR3args{}
+
+	sizeConsumed, err := r.ReadAs(&args.Object)
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+
+	attrs, size, err := readSattr3(r)
+	sizeConsumed += size
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	args.Attrs = attrs
+
+	check, err := r.ReadUint32()
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	sizeConsumed += 4
+	if check != 0 {
+		ctime := &nfs.NFSTime{}
+		size, err := r.ReadAs(ctime)
+		sizeConsumed += size
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		args.Guard = ctime
+	}
+
+	return args, sizeConsumed, nil
+}
+
+// SetAttr:
+//
+// SYNOPSIS
+//
+//	SETATTR3res NFSPROC3_SETATTR(SETATTR3args) = 2;
+//
+//	union sattrguard3 switch (bool check) {
+//	case TRUE:
+//	     nfstime3  obj_ctime;
+//	case FALSE:
+//	     void;
+//	};
+//
+//	struct SETATTR3args {
+//	     nfs_fh3      object;
+//	     sattr3       new_attributes;
+//	     sattrguard3  guard;
+//	};
+//
+//	struct SETATTR3resok {
+//	     wcc_data  obj_wcc;
+//	};
+//
+//	struct SETATTR3resfail {
+//	     wcc_data  obj_wcc;
+//	};
+func SetAttr(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling setattr.")
+
+	args, sizeConsumed, err := readSetAttrArgs(r)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	pathName, err := vfs.ResolveHandle(args.Object)
+	if err != nil {
+		log.Warnf("setattr: vfs.ResolveHandle(%x): %v", args.Object, err)
+		return sizeConsumed, replyStatus(w, nfs.NFS3ERR_STALE, beginWcc(vfs, "").data())
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	objWcc := beginWcc(vfs, pathName)
+	stat := recall(ctx, pathName, true)
+	if stat == nfs.NFS3_OK {
+		stat = setAttr(ctx, pathName, args)
+	}
+
+	log.Debugf("setattr(%s): %d", pathName, stat)
+
+	return sizeConsumed, replyStatus(w, stat, objWcc.data())
+}
+
+func setAttr(ctx nfs.RPCContext, pathName string, args *nfs.SETATTR3args) uint32 {
+	vfs := ctx.GetFS()
+
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		return nfs.NFS3err(err)
+	}
+
+	if args.Guard != nil {
+		// The ctime as reported to the client.
+		ctime := fileAttrs(vfs, fi).CTime
+		if ctime != *args.Guard {
+			return nfs.NFS3ERR_NOT_SYNC
+		}
+	}
+
+	if fi.IsDir() && args.Attrs.Size != nil {
+		return nfs.NFS3ERR_INVAL
+	}
+
+	// The times given by the client can't be set through fs.FS: none of
+	// the attributes is set. The server time needs nothing, as the file
+	// system stamps the mtime of the file it changes.
+	if args.Attrs.ATime.How == nfs.SET_TO_CLIENT_TIME || args.Attrs.MTime.How == nfs.SET_TO_CLIENT_TIME {
+		return nfs.NFS3ERR_NOTSUPP
+	}
+
+	if err := setAttrs(vfs, pathName, args.Attrs); err != nil {
+		log.Warnf("setattr: setAttrs(%s): %v", pathName, err)
+		return nfs.NFS3err(err)
+	}
+	return nfs.NFS3_OK
+}
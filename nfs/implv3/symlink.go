@@ -0,0 +1,102 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func readSymlinkArgs(r *xdr.Reader) (*nfs.SYMLINK3args, int, error) {
+	args := &nfs.SYMLINK3args{}
+
+	sizeConsumed, err := r.ReadAs(&args.Where)
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+
+	attrs, size, err := readSattr3(r)
+	sizeConsumed += size
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+	args.Attrs = attrs
+
+	size, err = r.ReadAs(&args.Data)
+	sizeConsumed += size
+	if err != nil {
+		return nil, sizeConsumed, err
+	}
+
+	return args, sizeConsumed, nil
+}
+
+// Symlink:
+//
+// SYNOPSIS
+//
+//	SYMLINK3res NFSPROC3_SYMLINK(SYMLINK3args) = 10;
+//
+//	struct symlinkdata3 {
+//	     sattr3    symlink_attributes;
+//	     nfspath3  symlink_data;
+//	};
+//
+//	struct SYMLINK3args {
+//	     diropargs3    where;
+//	     symlinkdata3  symlink;
+//	};
+//
+//	struct SYMLINK3resok {
+//	     post_op_fh3   obj;
+//	     post_op_attr  obj_attributes;
+//	     wcc_data      dir_wcc;
+//	};
+//
+//	struct SYMLINK3resfail {
+//	     wcc_data      dir_wcc;
+//	};
+func Symlink(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling symlink.")
+
+	args, sizeConsumed, err := readSymlinkArgs(r)
+	if err != nil {
+		return sizeConsumed, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	dirPath, pathName, stat := resolveDirOp(vfs, &args.Where)
+	dirWcc := beginWcc(vfs, dirPath)
+	if stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, dirWcc.data())
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	if _, err := vfs.Stat(pathName); err == nil {
+		return sizeConsumed, replyStatus(w, nfs.NFS3ERR_EXIST, dirWcc.data())
+	}
+
+	// The attributes of a symbolic link are not used: they are ignored.
+	if err := vfs.Symlink(args.Data, pathName); err != nil {
+		log.Warnf("symlink: vfs.Symlink(%s, %s): %v", args.Data, pathName, err)
+		return sizeConsumed, replyStatus(w, nfs.NFS3err(err), dirWcc.data())
+	}
+
+	log.Debugf("symlink(%s -> %s): done.", pathName, args.Data)
+
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(newObject(vfs, dirWcc, pathName))
+	return sizeConsumed, err
+}
@@ -1,63 +1,131 @@
 package implv3
 
 import (
-	"crypto/md5"
-	"encoding/binary"
 	"os"
 	"path"
-	"time"
 
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
 )
 
-func getFileId(name string) uint64 {
-	h := md5.New()
-	h.Write([]byte(name))
-	dat := h.Sum(nil)
-	return binary.BigEndian.Uint64(dat[0:8])
+func fileinfoToEntryPlus3(vfs fs.FS, fi fs.FileInfo) *nfs.EntryPlus3 {
+	attrs := fileAttrs(vfs, fi)
+
+	return &nfs.EntryPlus3{
+		FileId: attrs.FileId,
+		Name:   path.Base(fi.Name()),
+		Cookie: uint64(0),
+		NameAttrs: &nfs.PostOpAttr{
+			AttributesFollow: true,
+			Attributes:       attrs,
+		},
+		NameHandle: postOpFhOf(vfs, fi),
+	}
 }
 
-func fileinfoToEntryPlus3(dir string, fi os.FileInfo) *nfs.EntryPlus3 {
-	now := time.Now()
+// fileType returns the ftype3 of a file.
+func fileType(mode os.FileMode) uint32 {
+	switch {
+	case mode.IsDir():
+		return nfs.FTYPE_NF3DIR
+	case mode&os.ModeSymlink != 0:
+		return nfs.FTYPE_NF3LNK
+	case mode&os.ModeNamedPipe != 0:
+		return nfs.FTYPE_NF3FIFO
+	case mode&os.ModeSocket != 0:
+		return nfs.FTYPE_NF3SOCK
+	case mode&os.ModeCharDevice != 0:
+		return nfs.FTYPE_NF3CHR
+	case mode&os.ModeDevice != 0:
+		return nfs.FTYPE_NF3BLK
+	}
+	return nfs.FTYPE_NF3REG
+}
 
-	ftype := nfs.FTYPE_NF3REG
-	if fi.IsDir() {
-		ftype = nfs.FTYPE_NF3DIR
+// fileMode returns the mode3 of a file: its permission bits along
+// with the setuid, setgid and sticky bits.
+func fileMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		m |= 0o4000
+	}
+	if mode&os.ModeSetgid != 0 {
+		m |= 0o2000
 	}
+	if mode&os.ModeSticky != 0 {
+		m |= 0o1000
+	}
+	return m
+}
 
-	name := path.Base(fi.Name())
-	pathName := fi.Name()
-	if len(dir) > 0 {
-		pathName = path.Join(dir, name)
+// fsId returns the fsid3 of the file system, when it has an id.
+func fsId(vfs fs.FS) uint64 {
+	if i, ok := vfs.(fs.WithId); ok {
+		return i.Id()
 	}
+	return 0
+}
 
-	fileId := getFileId(pathName)
+// fileAttrs returns the fattr3 of a file. The fileid is the one given
+// by fs.FS, as in NFSv4.
+func fileAttrs(vfs fs.FS, fi fs.FileInfo) *nfs.FileAttrs {
+	uid, gid := fileOwner(fi)
 
-	return &nfs.EntryPlus3{
-		FileId: fileId,
-		Name:   name,
-		Cookie: uint64(0),
-		NameAttrs: &nfs.PostOpAttr{
-			AttributesFollow: true,
-			Attributes: &nfs.FileAttrs{
-				Type:   ftype,
-				Mode:   uint32(fi.Mode()),
-				NLink:  4,
-				Uid:    0,
-				Gid:    0,
-				Size:   uint64(fi.Size()),
-				Used:   uint64(fi.Size()),
-				Rdev:   nfs.SpecData{D1: 0, D2: 0},
-				Fsid:   0,
-				FileId: fileId,
-				ATime:  nfs.MakeNfsTime(now),
-				MTime:  nfs.MakeNfsTime(fi.ModTime()),
-				CTime:  nfs.MakeNfsTime(fi.ModTime()),
-			},
-		},
-		NameHandle: &nfs.PostOpFh3yes{
-			HandleFollow: true,
-			Handle:       []byte{},
-		},
+	return &nfs.FileAttrs{
+		Type:   fileType(fi.Mode()),
+		Mode:   fileMode(fi.Mode()),
+		NLink:  uint32(fi.NumLinks()),
+		Uid:    uid,
+		Gid:    gid,
+		Size:   uint64(fi.Size()),
+		Used:   uint64(fi.Size()),
+		Rdev:   nfs.SpecData{D1: 0, D2: 0},
+		Fsid:   fsId(vfs),
+		FileId: vfs.GetFileId(fi),
+		ATime:  nfs.MakeNfsTime(fi.ATime()),
+		MTime:  nfs.MakeNfsTime(fi.ModTime()),
+		CTime:  nfs.MakeNfsTime(fi.CTime()),
+	}
+}
+
+// postOpAttr returns the attributes of a file after an operation,
+// if they can be obtained.
+func postOpAttr(vfs fs.FS, pathName string) *nfs.PostOpAttr {
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		return &nfs.PostOpAttr{AttributesFollow: false}
+	}
+	return &nfs.PostOpAttr{
+		AttributesFollow: true,
+		Attributes:       fileAttrs(vfs, fi),
+	}
+}
+
+// postOpFhOf returns the post_op_fh3 of a file.
+func postOpFhOf(vfs fs.FS, fi fs.FileInfo) *nfs.PostOpFh3yes {
+	fh, err := vfs.GetHandle(fi)
+	if err != nil {
+		log.Warnf("vfs.GetHandle(%s): %v", fi.Name(), err)
+		return &nfs.PostOpFh3yes{HandleFollow: false}
+	}
+	return &nfs.PostOpFh3yes{HandleFollow: true, Handle: fh}
+}
+
+// handleAttr resolves a file handle and returns the post_op_attr
+// of the file.
+func handleAttr(vfs fs.FS, fh []byte) (*nfs.PostOpAttr, uint32) {
+	pathName, err := vfs.ResolveHandle(fh)
+	if err != nil {
+		log.Warnf("vfs.ResolveHandle(%x): %v", fh, err)
+		return &nfs.PostOpAttr{AttributesFollow: false}, nfs.NFS3ERR_STALE
+	}
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		return &nfs.PostOpAttr{AttributesFollow: false}, nfs.NFS3err(err)
 	}
+	return &nfs.PostOpAttr{
+		AttributesFollow: true,
+		Attributes:       fileAttrs(vfs, fi),
+	}, nfs.NFS3_OK
 }
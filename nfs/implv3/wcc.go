@@ -0,0 +1,56 @@
+package implv3
+
+import (
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// wcc keeps the attributes of a file before an operation, to reply its
+// wcc_data(rfc1813, section 2.6) once done: the clients tell apart their
+// own changes from the others' by comparing them to their cache.
+type wcc struct {
+	vfs      fs.FS
+	pathName string
+	before   *nfs.PreOpAttr
+}
+
+// beginWcc takes a snapshot of the size, mtime and ctime of a file
+// about to be changed. pathName is empty if the file is not known.
+func beginWcc(vfs fs.FS, pathName string) *wcc {
+	c := &wcc{
+		vfs:      vfs,
+		pathName: pathName,
+		before:   &nfs.PreOpAttr{AttributesFollow: false},
+	}
+	if pathName == "" {
+		return c
+	}
+
+	fi, err := vfs.Stat(pathName)
+	if err != nil {
+		return c
+	}
+	attrs := fileAttrs(vfs, fi)
+	c.before = &nfs.PreOpAttr{
+		AttributesFollow: true,
+		Attributes: &nfs.WccAttr{
+			Size:  attrs.Size,
+			MTime: attrs.MTime,
+			CTime: attrs.CTime,
+		},
+	}
+	return c
+}
+
+// data returns the wcc_data of the file: the snapshot taken before
+// the operation, along with the attributes of the file now.
+func (c *wcc) data() *nfs.WccData {
+	after := &nfs.PostOpAttr{AttributesFollow: false}
+	if c.pathName != "" {
+		after = postOpAttr(c.vfs, c.pathName)
+	}
+	return &nfs.WccData{
+		Before: c.before,
+		After:  after,
+	}
+}
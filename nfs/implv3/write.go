@@ -0,0 +1,149 @@
+package implv3
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// Write:
+//
+// SYNOPSIS
+//
+//	WRITE3res NFSPROC3_WRITE(WRITE3args) = 7;
+//
+//	enum stable_how {
+//	     UNSTABLE  = 0,
+//	     DATA_SYNC = 1,
+//	     FILE_SYNC = 2
+//	};
+//
+//	struct WRITE3args {
+//	     nfs_fh3     file;
+//	     offset3     offset;
+//	     count3      count;
+//	     stable_how  stable;
+//	     opaque      data<>;
+//	};
+//
+//	struct WRITE3resok {
+//	     wcc_data    file_wcc;
+//	     count3      count;
+//	     stable_how  committed;
+//	     writeverf3  verf;
+//	};
+//
+//	struct WRITE3resfail {
+//	     wcc_data    file_wcc;
+//	};
+func Write(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
+	r, w := ctx.Reader(), ctx.Writer()
+
+	log.Info("handling write.")
+
+	args := &nfs.WRITE3args{}
+	sizeConsumed, err := r.ReadAs(args)
+	if err != nil {
+		return 0, err
+	}
+
+	if ok, err := accept(h, ctx); !ok || err != nil {
+		return sizeConsumed, err
+	}
+
+	// --- proc result ---
+
+	vfs := ctx.GetFS()
+
+	pathName, err := vfs.ResolveHandle(args.File)
+	if err != nil {
+		log.Warnf("write: vfs.ResolveHandle(%x): %v", args.File, err)
+		if _, err := w.WriteUint32(nfs.NFS3ERR_STALE); err != nil {
+			return sizeConsumed, err
+		}
+		_, err := w.WriteAny(beginWcc(vfs, "").data())
+		return sizeConsumed, err
+	}
+
+	unlock := fileLocks.Lock(pathName)
+	defer unlock()
+
+	data := args.Data
+	if uint32(len(data)) > args.Count {
+		data = data[:args.Count]
+	}
+
+	fileWcc := beginWcc(vfs, pathName)
+
+	if stat := recall(ctx, pathName, true); stat != nfs.NFS3_OK {
+		return sizeConsumed, replyStatus(w, stat, fileWcc.data())
+	}
+
+	committed, err := writeFile(ctx, pathName, args.Offset, data, args.Stable)
+	if err != nil {
+		log.Warnf("write(%s): %v", pathName, err)
+		if _, err := w.WriteUint32(nfs.NFS3err(err)); err != nil {
+			return sizeConsumed, err
+		}
+		_, err := w.WriteAny(fileWcc.data())
+		return sizeConsumed, err
+	}
+
+	log.Debugf(
+		"write(%s, offset=%d): %d bytes, committed=%d",
+		pathName, args.Offset, len(data), committed,
+	)
+
+	res := &nfs.WRITE3resok{
+		FileWcc:   fileWcc.data(),
+		Count:     uint32(len(data)),
+		Committed: committed,
+		Verf:      nfs.WriteVerifier(),
+	}
+	if _, err := w.WriteUint32(nfs.NFS3_OK); err != nil {
+		return sizeConsumed, err
+	}
+	_, err = w.WriteAny(res)
+	return sizeConsumed, err
+}
+
+// writeFile writes data at offset, then flushes it to the stable
+// storage unless stable is UNSTABLE. It returns how the data has been
+// committed. A file system failing to flush is reported as UNSTABLE,
+// and resets the write verifier: the client will send the data again.
+func writeFile(ctx nfs.RPCContext, pathName string, offset uint64, data []byte, stable uint32) (uint32, error) {
+	f, err := ctx.GetFS().OpenFile(pathName, os.O_WRONLY, os.FileMode(0o644))
+	if err != nil {
+		return nfs.UNSTABLE, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nfs.UNSTABLE, err
+	}
+	if fi.IsDir() {
+		return nfs.UNSTABLE, syscall.EISDIR
+	}
+
+	if _, err := f.Seek(int64(offset), io.SeekStart); err != nil {
+		return nfs.UNSTABLE, err
+	}
+	if _, err := f.Write(data); err != nil {
+		return nfs.UNSTABLE, err
+	}
+
+	if stable == nfs.UNSTABLE {
+		return nfs.UNSTABLE, nil
+	}
+	if err := f.Sync(); err != nil {
+		log.Warnf("f.Sync(%s): %v", pathName, err)
+		nfs.ResetWriteVerifier()
+		return nfs.UNSTABLE, nil
+	}
+	// Both DATA_SYNC and FILE_SYNC are satisfied by Sync.
+	return nfs.FILE_SYNC, nil
+}
@@ -8,6 +8,7 @@ import (
 	"os/user"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/smallfz/libnfs-go/fs"
 	"github.com/smallfz/libnfs-go/log"
@@ -61,7 +62,7 @@ var attrsDefaultSet = []int{
 	// A_named_attr,
 	A_fsid,
 	// A_unique_handles,
-	// A_lease_time,
+	A_lease_time,
 	A_rdattr_error,
 	A_filehandle,
 	A_fileid,
@@ -246,7 +247,9 @@ func newFAttr4(mask []uint32, valsb64 string) *nfs.FAttr4 {
 	return a
 }
 
-func fileInfoToAttrs(vfs fs.FS, pathName string, fi fs.FileInfo, attrsRequest map[int]bool) *nfs.FAttr4 {
+func fileInfoToAttrs(x nfs.RPCContext, pathName string, fi fs.FileInfo, attrsRequest map[int]bool) *nfs.FAttr4 {
+	vfs := x.GetFS()
+
 	idxSupport := map[int]bool{}
 	for _, a := range attrsSupported {
 		idxSupport[a] = true
@@ -341,8 +344,7 @@ func fileInfoToAttrs(vfs fs.FS, pathName string, fi fs.FileInfo, attrsRequest ma
 			writeAny(a, v, 4)
 
 		case A_change:
-			changeid := uint64(fi.ModTime().Unix())
-			writeAny(a, changeid, 8)
+			writeAny(a, changeOf(fi), 8)
 
 		case A_size:
 			size := uint64(fi.Size())
@@ -365,7 +367,7 @@ func fileInfoToAttrs(vfs fs.FS, pathName string, fi fs.FileInfo, attrsRequest ma
 			writeAny(a, true, 4)
 
 		case A_lease_time:
-			ttl := uint32(300) // seconds, rfc7530:5.8.1.11
+			ttl := uint32(stateOf(x).LeaseTime() / time.Second) // seconds, rfc7530:5.8.1.11
 			writeAny(a, ttl, 4)
 
 		case A_rdattr_error:
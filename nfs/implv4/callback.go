@@ -0,0 +1,89 @@
+package implv4
+
+import (
+	"fmt"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// cbVersion is the version of the callback program of the clients.
+const cbVersion = 1
+
+// Caller calls a procedure of a rpc program at the universal address of a
+// client, sending args in sequence, and returns a reader of its results.
+// The State calls the callback program of the clients with it.
+type Caller func(netId, addr string, prog, vers, proc uint32, args ...interface{}) (*xdr.Reader, error)
+
+// SetCaller sets the Caller of the callbacks. Without it, no callback is
+// called, and no file is delegated.
+func (st *State) SetCaller(c Caller) {
+	st.caller = c
+}
+
+// callback calls a procedure of the callback program of a client.
+func (st *State) callback(clientId uint64, proc uint32, args ...interface{}) (*xdr.Reader, error) {
+	cb, _, _ := st.clients.callbackOf(clientId)
+	if st.caller == nil || cb == nil || cb.CbLocation == nil {
+		return nil, fmt.Errorf("no callback")
+	}
+	return st.caller(cb.CbLocation.NetId, cb.CbLocation.Addr, cb.CbProgram, cbVersion, proc, args...)
+}
+
+// probe checks the callback of a client with CB_NULL. The files are
+// delegated to a client whose callback is up.
+func (st *State) probe(clientId uint64) {
+	cb, _, _ := st.clients.callbackOf(clientId)
+	if st.caller == nil || cb == nil {
+		return
+	}
+	_, err := st.callback(clientId, nfs.PROC4_CB_NULL)
+	if err != nil {
+		log.Debugf("callback of client %x is down: %v", clientId, err)
+	}
+	st.clients.setCallbackUp(clientId, cb, err == nil)
+}
+
+// recall recalls the delegations of a file conflicting with an access by
+// a client, if any. It returns NFS4ERR_DELAY until they're returned.
+func (st *State) recall(fh []byte, clientId uint64, write bool) uint32 {
+	conflicts, recalls := st.opens.recall(fh, clientId, write)
+	for _, d := range recalls {
+		go st.sendRecall(d)
+	}
+	if len(conflicts) > 0 {
+		return nfs.NFS4ERR_DELAY
+	}
+	return nfs.NFS4_OK
+}
+
+// sendRecall recalls a delegation with CB_RECALL. A delegation which
+// can't be recalled is revoked.
+func (st *State) sendRecall(d *delegation) {
+	cb, ident, _ := st.clients.callbackOf(d.clientId)
+
+	status := uint32(0)
+	r, err := st.callback(d.clientId, nfs.PROC4_CB_COMPOUND,
+		&nfs.CB_COMPOUND4args{CallbackIdent: ident},
+		uint32(1), // the operations
+		nfs.OP4_CB_RECALL,
+		&nfs.CB_RECALL4args{
+			StateId: &nfs.StateId4{SeqId: d.seqId, Other: d.other},
+			Fh:      d.fh,
+		},
+	)
+	if err == nil {
+		status, err = r.ReadUint32()
+	}
+	if err == nil && status == nfs.NFS4_OK {
+		log.Debugf("delegation of %s to %x recalled.", d.path, d.clientId)
+		return
+	}
+
+	log.Warnf("CB_RECALL(%s) to %x: %v, %d. delegation revoked.", d.path, d.clientId, err, status)
+	st.opens.removeDeleg(d)
+	if err != nil {
+		st.clients.setCallbackUp(d.clientId, cb, false)
+	}
+}
@@ -0,0 +1,81 @@
+package implv4
+
+import (
+	"sort"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// changeOf returns the change attribute of a file: its counter of
+// changes if the FS counts them, or else the latest of its mtime and
+// ctime, in nanoseconds.
+func changeOf(fi fs.FileInfo) uint64 {
+	if c, ok := fi.(fs.WithChange); ok {
+		return c.Change()
+	}
+	t := fi.CTime()
+	if m := fi.ModTime(); m.After(t) {
+		t = m
+	}
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// dirChange is a change of directories, locked from changeDirs until
+// done.
+type dirChange struct {
+	vfs     fs.FS
+	dirs    []string
+	before  map[string]uint64
+	unlocks []func()
+}
+
+// changeDirs locks directories for a change, and records their change
+// attribute before it. The directories are locked in order, so that two
+// changes of the same directories don't deadlock.
+func (st *State) changeDirs(vfs fs.FS, dirs ...string) *dirChange {
+	c := &dirChange{
+		vfs:    vfs,
+		before: map[string]uint64{},
+	}
+	for _, dir := range dirs {
+		if _, found := c.before[dir]; !found {
+			c.before[dir] = 0
+			c.dirs = append(c.dirs, dir)
+		}
+	}
+	sort.Strings(c.dirs)
+
+	for _, dir := range c.dirs {
+		c.unlocks = append(c.unlocks, st.dirs.Lock(dir))
+		if fi, err := vfs.Stat(dir); err == nil {
+			c.before[dir] = changeOf(fi)
+		}
+	}
+	return c
+}
+
+// info returns the change_info4 of a directory changed.
+func (c *dirChange) info(dir string) *nfs.ChangeInfo4 {
+	cinfo := &nfs.ChangeInfo4{
+		Atomic: true,
+		Before: c.before[dir],
+	}
+	if fi, err := c.vfs.Stat(dir); err == nil {
+		cinfo.After = changeOf(fi)
+	} else {
+		cinfo.Atomic = false
+	}
+	return cinfo
+}
+
+// done unlocks the directories.
+func (c *dirChange) done() {
+	for i := len(c.unlocks) - 1; i >= 0; i-- {
+		c.unlocks[i]()
+	}
+	c.unlocks = nil
+}
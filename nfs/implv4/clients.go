@@ -0,0 +1,255 @@
+package implv4
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/smallfz/libnfs-go/auth"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/utils"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// client is a record of a client, created by SETCLIENTID (rfc7530, 16.33).
+type client struct {
+	id        string // nfs_client_id4.id
+	verifier  uint64 // nfs_client_id4.verifier
+	principal string
+
+	clientId uint64
+	confirm  uint64 // setclientid_confirm
+
+	callback      *nfs.CbClient4
+	callbackIdent uint32
+	callbackUp    bool // the callback answered CB_NULL.
+
+	renewed time.Time // of the lease, or the creation of an unconfirmed record.
+}
+
+// clientTable holds the confirmed and unconfirmed client records,
+// by nfs_client_id4.id.
+type clientTable struct {
+	mu sync.Mutex
+
+	// Client ids embed the boot time, so that the ones of a previous
+	// instance of the server are stale.
+	boot uint32
+	seq  uint32
+
+	leaseTime time.Duration
+
+	confirmed   map[string]*client
+	unconfirmed map[string]*client
+	ids         map[uint64]*client   // confirmed records by client id.
+	expired     map[uint64]time.Time // client ids whose lease expired.
+}
+
+func newClientTable() *clientTable {
+	return &clientTable{
+		boot:        uint32(time.Now().Unix()),
+		leaseTime:   DefaultLeaseTime,
+		confirmed:   map[string]*client{},
+		unconfirmed: map[string]*client{},
+		ids:         map[uint64]*client{},
+		expired:     map[uint64]time.Time{},
+	}
+}
+
+func (t *clientTable) newClientId() uint64 {
+	t.seq++
+	return uint64(t.boot)<<32 | uint64(t.seq)
+}
+
+// setClientId creates an unconfirmed record for a client. The address of
+// the callback of the confirmed record is returned along with a
+// NFS4ERR_CLID_INUSE.
+func (t *clientTable) setClientId(principal string, args *nfs.SETCLIENTID4args) (*client, *nfs.ClientAddr4, uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := string(args.Client.Id)
+
+	conf := t.confirmed[id]
+	if conf != nil && conf.principal != principal && time.Since(conf.renewed) <= t.leaseTime {
+		addr := &nfs.ClientAddr4{}
+		if conf.callback != nil && conf.callback.CbLocation != nil {
+			addr = conf.callback.CbLocation
+		}
+		return nil, addr, nfs.NFS4ERR_CLID_INUSE
+	}
+
+	rec := &client{
+		id:            id,
+		verifier:      args.Client.Verifier,
+		principal:     principal,
+		confirm:       utils.RandUint64(),
+		callback:      args.Callback,
+		callbackIdent: args.CallbackIdent,
+		renewed:       time.Now(),
+	}
+
+	if conf != nil && conf.verifier == rec.verifier {
+		// The callback of a confirmed client is being updated.
+		rec.clientId = conf.clientId
+	} else {
+		// A new client, a client rebooted or an unconfirmed record
+		// replaced.
+		rec.clientId = t.newClientId()
+	}
+
+	t.unconfirmed[id] = rec
+	return rec, nil, nfs.NFS4_OK
+}
+
+// confirm confirms the record created by setClientId (rfc7530, 16.34).
+// The client id of a confirmed record replaced is returned: its state has
+// to be released.
+func (t *clientTable) confirm(principal string, clientId, confirm uint64) (uint64, uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for id, rec := range t.unconfirmed {
+		if rec.clientId != clientId || rec.confirm != confirm {
+			continue
+		}
+		if rec.principal != principal {
+			return 0, nfs.NFS4ERR_CLID_INUSE
+		}
+		delete(t.unconfirmed, id)
+
+		replaced := uint64(0)
+		if conf := t.confirmed[id]; conf != nil {
+			if conf.clientId == rec.clientId {
+				// Callback update: the client keeps its state.
+				conf.callback = rec.callback
+				conf.callbackIdent = rec.callbackIdent
+				conf.callbackUp = false
+				conf.confirm = rec.confirm
+				conf.renewed = time.Now()
+				return 0, nfs.NFS4_OK
+			}
+			// The client rebooted: the previous record goes away.
+			delete(t.ids, conf.clientId)
+			replaced = conf.clientId
+		}
+
+		rec.renewed = time.Now()
+		t.confirmed[id] = rec
+		t.ids[rec.clientId] = rec
+		return replaced, nfs.NFS4_OK
+	}
+
+	// A retransmission of a confirmation already done.
+	if conf := t.ids[clientId]; conf != nil && conf.confirm == confirm {
+		if conf.principal != principal {
+			return 0, nfs.NFS4ERR_CLID_INUSE
+		}
+		return 0, nfs.NFS4_OK
+	}
+
+	return 0, nfs.NFS4ERR_STALE_CLIENTID
+}
+
+// renew renews the lease of a client.
+func (t *clientTable) renew(clientId uint64) uint32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rec, found := t.ids[clientId]; found {
+		rec.renewed = time.Now()
+		return nfs.NFS4_OK
+	}
+	if _, found := t.expired[clientId]; found {
+		return nfs.NFS4ERR_EXPIRED
+	}
+	return nfs.NFS4ERR_STALE_CLIENTID
+}
+
+// idOf returns the nfs_client_id4.id of a confirmed client.
+func (t *clientTable) idOf(clientId uint64) (string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rec, found := t.ids[clientId]; found {
+		return rec.id, true
+	}
+	return "", false
+}
+
+// callbackOf returns the callback of a confirmed client, and whether it
+// is known to be up.
+func (t *clientTable) callbackOf(clientId uint64) (*nfs.CbClient4, uint32, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rec, found := t.ids[clientId]; found {
+		return rec.callback, rec.callbackIdent, rec.callbackUp
+	}
+	return nil, 0, false
+}
+
+// setCallbackUp records whether the callback of a client is up, unless it
+// changed since.
+func (t *clientTable) setCallbackUp(clientId uint64, callback *nfs.CbClient4, up bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if rec, found := t.ids[clientId]; found && rec.callback == callback {
+		rec.callbackUp = up
+	}
+}
+
+// isExpired reports whether the lease of a client expired.
+func (t *clientTable) isExpired(clientId uint64) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	_, found := t.expired[clientId]
+	return found
+}
+
+// reap drops the records whose lease expired, and returns their client ids.
+// The client ids expired are remembered for a while, so that their use
+// fails with NFS4ERR_EXPIRED rather than NFS4ERR_STALE_CLIENTID.
+func (t *clientTable) reap(now time.Time) []uint64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := []uint64{}
+	for id, rec := range t.confirmed {
+		if now.Sub(rec.renewed) > t.leaseTime {
+			delete(t.confirmed, id)
+			delete(t.ids, rec.clientId)
+			t.expired[rec.clientId] = now
+			expired = append(expired, rec.clientId)
+		}
+	}
+	for id, rec := range t.unconfirmed {
+		if now.Sub(rec.renewed) > t.leaseTime {
+			delete(t.unconfirmed, id)
+		}
+	}
+	for clientId, at := range t.expired {
+		if now.Sub(at) > t.leaseTime*expiredLeases {
+			delete(t.expired, clientId)
+		}
+	}
+	return expired
+}
+
+// principalOf identifies the principal of a call: the flavor of its
+// credential and, with AUTH_UNIX, the uid.
+func principalOf(cred *nfs.Auth) string {
+	if cred == nil {
+		return ""
+	}
+	if cred.Flavor == nfs.AUTH_FLAVOR_UNIX {
+		creds := &auth.Creds{}
+		if _, err := xdr.NewReader(bytes.NewBuffer(cred.Body)).ReadAs(creds); err == nil {
+			return fmt.Sprintf("%d:%d", cred.Flavor, creds.UID)
+		}
+	}
+	return fmt.Sprintf("%d:%x", cred.Flavor, cred.Body)
+}
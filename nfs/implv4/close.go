@@ -5,26 +5,45 @@ import (
 	"github.com/smallfz/libnfs-go/nfs"
 )
 
-func closeFile(x nfs.RPCContext, args *nfs.CLOSE4args) (*nfs.CLOSE4res, error) {
-	seqId := uint32(0)
-	if args != nil && args.OpenStateId != nil {
-		seqId = args.OpenStateId.SeqId
+func closeFile(x nfs.RPCContext, args *nfs.CLOSE4args) (*nfs.ResGenericRaw, error) {
+	st := stateOf(x)
+
+	of, status := findOpen(x, args.OpenStateId)
+	oo := (*stateOwner)(nil)
+	if of != nil {
+		oo = st.owners.get(of.clientId, of.owner, true)
+	} else if status == nfs.NFS4ERR_BAD_STATEID && args.OpenStateId != nil {
+		// A retransmission of a CLOSE done.
+		oo = st.owners.closedOwner(args.OpenStateId)
+	}
+	if oo == nil {
+		log.Warnf("close: %d", status)
+		return &nfs.ResGenericRaw{Status: status}, nil
 	}
 
-	log.Infof("CLOSE4, seq=%d", seqId)
+	return oo.do(args.SeqId, false, func() (interface{}, error) {
+		if status == nfs.NFS4_OK && !of.confirmed {
+			status = nfs.NFS4ERR_BAD_STATEID
+		}
+		if status != nfs.NFS4_OK {
+			log.Warnf("close: %d", status)
+			return &nfs.CLOSE4res{Status: status}, nil
+		}
 
-	f := x.Stat().RemoveOpenedFile(seqId)
-	if f == nil {
-		log.Warnf("close: opened file in stat not exists.")
-		return &nfs.CLOSE4res{Status: nfs.NFS4ERR_INVAL}, nil
-	} else {
-		log.Debugf(" - %s closed.", f.File().Name())
-		f.File().Close()
-	}
+		log.Infof("CLOSE4, %s", of.path)
 
-	res := &nfs.CLOSE4res{
-		Status: nfs.NFS4_OK,
-		Ok:     args.OpenStateId,
-	}
-	return res, nil
+		// The locks of the file go away with the open.
+		st.unlock(of)
+		sid := st.opens.remove(of)
+		st.owners.setClosed(of)
+		if err := of.f.Close(); err != nil {
+			log.Warnf("f.Close: %v", err)
+		}
+
+		res := &nfs.CLOSE4res{
+			Status: nfs.NFS4_OK,
+			Ok:     sid,
+		}
+		return res, nil
+	})
 }
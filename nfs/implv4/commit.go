@@ -20,14 +20,15 @@ func commit(x nfs.RPCContext, args *nfs.COMMIT4args) (*nfs.COMMIT4res, error) {
 		args.Count,
 	)
 
-	// verifier := args.Offset
-
-	files := x.Stat().FindOpenedFiles(pathName)
+	files := stateOf(x).opens.find(pathName)
 	if files != nil && len(files) > 0 {
 		for _, of := range files {
 			f := of.File()
 			if err := f.Sync(); err != nil {
 				log.Warnf("commit(%s): of.f.Sync: %v", pathName, err)
+				// The data written may be lost: the new verifier
+				// tells the client to send it again.
+				nfs.ResetWriteVerifier()
 			} else {
 				log.Infof("commit(%s): ok.", pathName)
 			}
@@ -37,7 +38,7 @@ func commit(x nfs.RPCContext, args *nfs.COMMIT4args) (*nfs.COMMIT4res, error) {
 	rs := &nfs.COMMIT4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.COMMIT4resok{
-			Verifier: 0,
+			Verifier: nfs.WriteVerifier(),
 		},
 	}
 	return rs, nil
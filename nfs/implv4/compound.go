@@ -167,6 +167,14 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 				}
 				sizeConsumed += size
 
+			case nfs.OP4_OPEN_CONFIRM:
+				args := &nfs.OPEN_CONFIRM4args{}
+				if size, err := r.ReadAs(args); err != nil {
+					return sizeConsumed, err
+				} else {
+					sizeConsumed += size
+				}
+
 			case nfs.OP4_CLOSE:
 				args := &nfs.CLOSE4args{}
 				if size, err := r.ReadAs(args); err != nil {
@@ -175,6 +183,53 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 					sizeConsumed += size
 				}
 
+			case nfs.OP4_LOCK:
+				_, size, err := readOpLockArgs(r)
+				if err != nil {
+					return sizeConsumed, err
+				}
+				sizeConsumed += size
+
+			case nfs.OP4_LOCKT:
+				args := &nfs.LOCKT4args{}
+				if size, err := r.ReadAs(args); err != nil {
+					return sizeConsumed, err
+				} else {
+					sizeConsumed += size
+				}
+
+			case nfs.OP4_LOCKU:
+				args := &nfs.LOCKU4args{}
+				if size, err := r.ReadAs(args); err != nil {
+					return sizeConsumed, err
+				} else {
+					sizeConsumed += size
+				}
+
+			case nfs.OP4_RELEASE_LOCKOWNER:
+				args := &nfs.RELEASE_LOCKOWNER4args{}
+				if size, err := r.ReadAs(args); err != nil {
+					return sizeConsumed, err
+				} else {
+					sizeConsumed += size
+				}
+
+			case nfs.OP4_DELEGRETURN:
+				args := &nfs.DELEGRETURN4args{}
+				if size, err := r.ReadAs(args); err != nil {
+					return sizeConsumed, err
+				} else {
+					sizeConsumed += size
+				}
+
+			case nfs.OP4_DELEGPURGE:
+				args := &nfs.DELEGPURGE4args{}
+				if size, err := r.ReadAs(args); err != nil {
+					return sizeConsumed, err
+				} else {
+					sizeConsumed += size
+				}
+
 			case nfs.OP4_SETATTR:
 				args := &nfs.SETATTR4args{}
 				if size, err := r.ReadAs(args); err != nil {
@@ -236,7 +291,6 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 			case nfs.OP4_READLINK:
 			default:
 				log.Warnf("op not handled: %d.", opnum4)
-				w.WriteUint32(nfs.NFS4ERR_OP_ILLEGAL)
 				return sizeConsumed, nil
 			}
 		}
@@ -269,6 +323,7 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 	rsOpList := []uint32{}
 	rsList := []interface{}{}
 
+ops:
 	for i := uint32(0); i < opsCnt; i++ {
 		opnum4 := uint32(0)
 		if size, err := r.ReadAs(&opnum4); err != nil {
@@ -287,7 +342,7 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 			} else {
 				sizeConsumed += size
 			}
-			res, err := setClientId(args)
+			res, err := setClientId(ctx, h.Cred, args)
 			if err != nil {
 				return sizeConsumed, err
 			}
@@ -302,7 +357,7 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 			} else {
 				sizeConsumed += size
 			}
-			res, err := setClientIdConfirm(args)
+			res, err := setClientIdConfirm(ctx, h.Cred, args)
 			if err != nil {
 				return sizeConsumed, err
 			}
@@ -517,10 +572,9 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 				sizeConsumed += size
 			}
 
-			// todo: renew client registration. somehow...
-
-			res := &nfs.RENEW4res{
-				Status: nfs.NFS4_OK,
+			res, err := renew(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
 			}
 			rsOpList = append(rsOpList, opnum4)
 			rsStatusList = append(rsStatusList, res.Status)
@@ -575,6 +629,23 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 			rsStatusList = append(rsStatusList, res.Status)
 			rsList = append(rsList, res)
 
+		case nfs.OP4_OPEN_CONFIRM:
+			args := &nfs.OPEN_CONFIRM4args{}
+			if size, err := r.ReadAs(args); err != nil {
+				return sizeConsumed, err
+			} else {
+				sizeConsumed += size
+			}
+
+			res, err := openConfirm(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
 		case nfs.OP4_CLOSE:
 			args := &nfs.CLOSE4args{}
 			if size, err := r.ReadAs(args); err != nil {
@@ -592,6 +663,107 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 			rsStatusList = append(rsStatusList, res.Status)
 			rsList = append(rsList, res)
 
+		case nfs.OP4_LOCK:
+			args, size, err := readOpLockArgs(r)
+			if err != nil {
+				return sizeConsumed, err
+			}
+			sizeConsumed += size
+
+			res, err := lock(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
+		case nfs.OP4_LOCKT:
+			args := &nfs.LOCKT4args{}
+			if size, err := r.ReadAs(args); err != nil {
+				return sizeConsumed, err
+			} else {
+				sizeConsumed += size
+			}
+
+			res, err := lockTest(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
+		case nfs.OP4_LOCKU:
+			args := &nfs.LOCKU4args{}
+			if size, err := r.ReadAs(args); err != nil {
+				return sizeConsumed, err
+			} else {
+				sizeConsumed += size
+			}
+
+			res, err := unlock(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
+		case nfs.OP4_RELEASE_LOCKOWNER:
+			args := &nfs.RELEASE_LOCKOWNER4args{}
+			if size, err := r.ReadAs(args); err != nil {
+				return sizeConsumed, err
+			} else {
+				sizeConsumed += size
+			}
+
+			res, err := releaseLockOwner(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
+		case nfs.OP4_DELEGRETURN:
+			args := &nfs.DELEGRETURN4args{}
+			if size, err := r.ReadAs(args); err != nil {
+				return sizeConsumed, err
+			} else {
+				sizeConsumed += size
+			}
+
+			res, err := delegReturn(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
+		case nfs.OP4_DELEGPURGE:
+			args := &nfs.DELEGPURGE4args{}
+			if size, err := r.ReadAs(args); err != nil {
+				return sizeConsumed, err
+			} else {
+				sizeConsumed += size
+			}
+
+			res, err := delegPurge(ctx, args)
+			if err != nil {
+				return sizeConsumed, err
+			}
+
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+
 		case nfs.OP4_SETATTR:
 			args := &nfs.SETATTR4args{}
 			if size, err := r.ReadAs(args); err != nil {
@@ -748,9 +920,24 @@ func Compound(h *nfs.RPCMsgCall, ctx nfs.RPCContext) (int, error) {
 			rsList = append(rsList, res)
 
 		default:
+			// The args of an op not handled can't be decoded:
+			// the compound stops here (rfc7530, 15.2).
 			log.Warnf("op not handled: %d.", opnum4)
-			w.WriteUint32(nfs.NFS4ERR_OP_ILLEGAL)
-			return sizeConsumed, nil
+			res := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOTSUPP}
+			if opnum4 < nfs.OP4_ACCESS || opnum4 > nfs.OP4_RELEASE_LOCKOWNER {
+				opnum4 = nfs.OP4_ILLEGAL
+				res.Status = nfs.NFS4ERR_OP_ILLEGAL
+			}
+			rsOpList = append(rsOpList, opnum4)
+			rsStatusList = append(rsStatusList, res.Status)
+			rsList = append(rsList, res)
+			break ops
+		}
+
+		// The compound stops at the first op failing: its result is
+		// the last one (rfc7530, 15.2).
+		if rsStatusList[len(rsStatusList)-1] != nfs.NFS4_OK {
+			break ops
 		}
 	}
 
@@ -91,13 +91,15 @@ func create(x nfs.RPCContext, args *nfs.CREATE4args) (*nfs.CREATE4res, error) {
 		return resFailPerm, nil
 	}
 
+	ch := stateOf(x).changeDirs(vfs, cwd)
+	defer ch.done()
+
 	pathName := fs.Join(cwd, args.ObjName)
 	log.Debugf("    create: %s", pathName)
 	if _, err := vfs.Stat(pathName); err == nil {
 		return &nfs.CREATE4res{Status: nfs.NFS4ERR_EXIST}, nil
 	}
 
-	cinfo := &nfs.ChangeInfo4{}
 	attrSet := []uint32{}
 
 	decAttrs, err := decodeFAttrs4(args.CreateAttrs)
@@ -128,7 +130,7 @@ func create(x nfs.RPCContext, args *nfs.CREATE4args) (*nfs.CREATE4res, error) {
 			return resFailPerm, nil
 		}
 
-		attr := fileInfoToAttrs(vfs, pathName, fi, nil)
+		attr := fileInfoToAttrs(x, pathName, fi, nil)
 		attrSet = attr.Mask
 
 		// set current fh to the newly created one.
@@ -162,7 +164,7 @@ func create(x nfs.RPCContext, args *nfs.CREATE4args) (*nfs.CREATE4res, error) {
 			return resFailPerm, nil
 		}
 
-		attr := fileInfoToAttrs(vfs, pathName, fi, nil)
+		attr := fileInfoToAttrs(x, pathName, fi, nil)
 		attrSet = attr.Mask
 
 		// set current fh to the newly created one.
@@ -187,7 +189,7 @@ func create(x nfs.RPCContext, args *nfs.CREATE4args) (*nfs.CREATE4res, error) {
 			return resFailPerm, nil
 		}
 
-		attr := fileInfoToAttrs(vfs, pathName, fi, nil)
+		attr := fileInfoToAttrs(x, pathName, fi, nil)
 		attrSet = attr.Mask
 
 		// set current fh to the newly created one.
@@ -201,7 +203,7 @@ func create(x nfs.RPCContext, args *nfs.CREATE4args) (*nfs.CREATE4res, error) {
 	res := &nfs.CREATE4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.CREATE4resok{
-			CInfo:   cinfo,
+			CInfo:   ch.info(cwd),
 			AttrSet: attrSet,
 		},
 	}
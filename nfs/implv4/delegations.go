@@ -0,0 +1,152 @@
+package implv4
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// delegation is a file delegated to a client by OPEN, as long as no other
+// client uses it in a conflicting way (rfc7530, 10.4): the file is then
+// recalled with CB_RECALL, and the client returns it with DELEGRETURN.
+type delegation struct {
+	clientId uint64
+	fh       []byte
+	path     string
+	typ      uint32 // OPEN_DELEGATE_READ or OPEN_DELEGATE_WRITE.
+
+	other [3]uint32
+	seqId uint32
+
+	recalled time.Time // zero until recalled.
+}
+
+// conflicts reports whether the delegation conflicts with an access by
+// another client: a write delegation conflicts with any access, a read
+// delegation with writing.
+func (d *delegation) conflicts(clientId uint64, write bool) bool {
+	return d.clientId != clientId && (write || d.typ == nfs.OPEN_DELEGATE_WRITE)
+}
+
+// delegate delegates a file to a client, unless other clients use it or
+// the client holds a delegation of it already. A write delegation is
+// given if write is set. No stateid is returned without a delegation.
+func (t *openTable) delegate(clientId uint64, fh []byte, pathName string, write bool) *nfs.StateId4 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, d := range t.delegs {
+		if !bytes.Equal(d.fh, fh) {
+			continue
+		}
+		// Only read delegations are shared.
+		if d.clientId == clientId || write || d.typ == nfs.OPEN_DELEGATE_WRITE || !d.recalled.IsZero() {
+			return nil
+		}
+	}
+	for _, of := range t.files {
+		if bytes.Equal(of.fh, fh) && of.clientId != clientId {
+			if write || of.access&nfs.OPEN4_SHARE_ACCESS_WRITE != 0 {
+				return nil
+			}
+		}
+	}
+
+	d := &delegation{
+		clientId: clientId,
+		fh:       fh,
+		path:     pathName,
+		typ:      nfs.OPEN_DELEGATE_READ,
+		other:    t.newOther(),
+		seqId:    1,
+	}
+	if write {
+		d.typ = nfs.OPEN_DELEGATE_WRITE
+	}
+	t.delegs[d.other] = d
+	return &nfs.StateId4{SeqId: d.seqId, Other: d.other}
+}
+
+// recall returns the delegations of a file conflicting with an access by
+// a client. The ones not recalled yet are marked as recalled, and
+// returned apart so that they're recalled once.
+func (t *openTable) recall(fh []byte, clientId uint64, write bool) ([]*delegation, []*delegation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	conflicts, recalls := []*delegation{}, []*delegation{}
+	for _, d := range t.delegs {
+		if !bytes.Equal(d.fh, fh) || !d.conflicts(clientId, write) {
+			continue
+		}
+		conflicts = append(conflicts, d)
+		if d.recalled.IsZero() {
+			d.recalled = time.Now()
+			recalls = append(recalls, d)
+		}
+	}
+	return conflicts, recalls
+}
+
+// getDeleg returns the delegation of a stateid, on the file of a handle.
+func (t *openTable) getDeleg(sid *nfs.StateId4, fh []byte) (*delegation, uint32) {
+	if sid == nil || isSpecialStateId(sid) {
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	}
+	if sid.Other[0] != t.boot {
+		return nil, nfs.NFS4ERR_STALE_STATEID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	d, found := t.delegs[sid.Other]
+	switch {
+	case !found:
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	case sid.SeqId < d.seqId:
+		return nil, nfs.NFS4ERR_OLD_STATEID
+	case sid.SeqId > d.seqId:
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	case fh != nil && !bytes.Equal(d.fh, fh):
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	}
+	return d, nfs.NFS4_OK
+}
+
+// removeDeleg removes a delegation returned or revoked.
+func (t *openTable) removeDeleg(d *delegation) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.delegs, d.other)
+}
+
+// releaseDelegs removes the delegations of a client.
+func (t *openTable) releaseDelegs(clientId uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for other, d := range t.delegs {
+		if d.clientId == clientId {
+			delete(t.delegs, other)
+		}
+	}
+}
+
+// revoke removes the delegations recalled before a time but not returned
+// since, and returns them.
+func (t *openTable) revoke(before time.Time) []*delegation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := []*delegation{}
+	for other, d := range t.delegs {
+		if !d.recalled.IsZero() && d.recalled.Before(before) {
+			delete(t.delegs, other)
+			rs = append(rs, d)
+		}
+	}
+	return rs
+}
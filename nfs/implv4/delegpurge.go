@@ -0,0 +1,17 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// delegPurge purges the delegations of a client awaiting a reclaim by
+// CLAIM_DELEGATE_PREV (rfc7530, 16.7). The delegations don't outlive the
+// server, so that there's none: only the client id is checked.
+func delegPurge(x nfs.RPCContext, args *nfs.DELEGPURGE4args) (*nfs.DELEGPURGE4res, error) {
+	status := stateOf(x).clients.renew(args.ClientId)
+	if status != nfs.NFS4_OK {
+		log.Warnf("delegpurge(%x): %d", args.ClientId, status)
+	}
+	return &nfs.DELEGPURGE4res{Status: status}, nil
+}
@@ -0,0 +1,23 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// delegReturn returns a delegation to the server (rfc7530, 16.8).
+func delegReturn(x nfs.RPCContext, args *nfs.DELEGRETURN4args) (*nfs.DELEGRETURN4res, error) {
+	st := stateOf(x)
+
+	d, status := st.opens.getDeleg(args.DelegStateId, x.Stat().CurrentHandle())
+	if status != nfs.NFS4_OK {
+		log.Warnf("delegreturn: %d", status)
+		return &nfs.DELEGRETURN4res{Status: status}, nil
+	}
+
+	log.Debugf("delegation of %s returned by %x.", d.path, d.clientId)
+	st.opens.removeDeleg(d)
+	st.clients.renew(d.clientId)
+
+	return &nfs.DELEGRETURN4res{Status: nfs.NFS4_OK}, nil
+}
@@ -44,7 +44,7 @@ func getAttr(x nfs.RPCContext, args *nfs.GETATTR4args) (*nfs.GETATTR4res, error)
 		return &nfs.GETATTR4res{Status: nfs.NFS4ERR_NOENT}, nil
 	}
 
-	attrs := fileInfoToAttrs(vfs, pathName, fi, idxReq)
+	attrs := fileInfoToAttrs(x, pathName, fi, idxReq)
 
 	rs := &nfs.GETATTR4res{
 		Status: nfs.NFS4_OK,
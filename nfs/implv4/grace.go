@@ -0,0 +1,298 @@
+package implv4
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// ClientStore keeps the confirmed clients on stable storage
+// (rfc7530, 9.6.3.4): after a restart, the server lets the clients of its
+// previous instance reclaim their state in a grace period. The clients
+// are identified by their nfs_client_id4.id.
+type ClientStore interface {
+	// Load returns the ids of the clients recorded.
+	Load() ([]string, error)
+
+	// Add records a client.
+	Add(id string) error
+
+	// Remove forgets a client, which lost its state.
+	Remove(id string) error
+}
+
+// FileClientStore is a ClientStore keeping the ids of the clients in
+// a file, one per line, hex-encoded. The file is rewritten by each change.
+type FileClientStore struct {
+	path string
+
+	mu  sync.Mutex
+	ids map[string]bool
+}
+
+var _ ClientStore = (*FileClientStore)(nil)
+
+// NewFileClientStore returns a FileClientStore keeping the clients in the
+// file at path, created if needed.
+func NewFileClientStore(path string) *FileClientStore {
+	return &FileClientStore{
+		path: path,
+		ids:  map[string]bool{},
+	}
+}
+
+func (s *FileClientStore) Load() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	ids := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			id, err := hex.DecodeString(line)
+			if err != nil {
+				log.Warnf("%s: invalid line: %s", s.path, line)
+				continue
+			}
+			ids[string(id)] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	s.ids = ids
+
+	rs := make([]string, 0, len(ids))
+	for id := range ids {
+		rs = append(rs, id)
+	}
+	return rs, nil
+}
+
+func (s *FileClientStore) Add(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ids[id] {
+		return nil
+	}
+	s.ids[id] = true
+	return s.save()
+}
+
+func (s *FileClientStore) Remove(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.ids[id] {
+		return nil
+	}
+	delete(s.ids, id)
+	return s.save()
+}
+
+// save writes the ids to a temporary file replacing the file, so that
+// a crash leaves either version.
+func (s *FileClientStore) save() error {
+	lines := make([]string, 0, len(s.ids))
+	for id := range s.ids {
+		lines = append(lines, hex.EncodeToString([]byte(id)))
+	}
+	sort.Strings(lines)
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(tmp)
+	for _, line := range lines {
+		w.WriteString(line)
+		w.WriteString("\n")
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// grace is the grace period of a State, and its clients on stable
+// storage.
+type grace struct {
+	mu sync.Mutex
+
+	store  ClientStore
+	stored map[uint64]string // ids recorded, by client id.
+
+	end     time.Time       // of the grace period.
+	reclaim map[string]bool // ids of the clients which may reclaim.
+}
+
+func newGrace() *grace {
+	return &grace{
+		stored: map[uint64]string{},
+	}
+}
+
+// SetClientStore sets the stable storage of the clients. Without it, the
+// clients lose their state once the server restarts.
+func (st *State) SetClientStore(s ClientStore) {
+	st.grace.mu.Lock()
+	defer st.grace.mu.Unlock()
+
+	st.grace.store = s
+}
+
+// StartGrace starts a grace period, in which the clients recorded by the
+// ClientStore reclaim their state while no new state is given
+// (rfc7530, 9.6.2). There is no grace period without a client to wait
+// for.
+func (st *State) StartGrace(period time.Duration) error {
+	g := st.grace
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.store == nil {
+		return nil
+	}
+	ids, err := g.store.Load()
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	g.end = time.Now().Add(period)
+	g.reclaim = map[string]bool{}
+	for _, id := range ids {
+		g.reclaim[id] = true
+	}
+	log.Infof("grace period of %v for %d client(s).", period, len(ids))
+	return nil
+}
+
+// InGrace reports whether the grace period is running.
+func (st *State) InGrace() bool {
+	st.endGrace(time.Now())
+
+	st.grace.mu.Lock()
+	defer st.grace.mu.Unlock()
+
+	return st.grace.reclaim != nil
+}
+
+// endGrace ends the grace period once over: the clients which didn't
+// come back are forgotten.
+func (st *State) endGrace(now time.Time) {
+	g := st.grace
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.reclaim == nil || now.Before(g.end) {
+		return
+	}
+
+	back := map[string]bool{}
+	for _, id := range g.stored {
+		back[id] = true
+	}
+	for id := range g.reclaim {
+		if !back[id] {
+			if err := g.store.Remove(id); err != nil {
+				log.Warnf("ClientStore.Remove: %v", err)
+			}
+		}
+	}
+	g.reclaim = nil
+	log.Infof("grace period over.")
+}
+
+// claim checks whether a client may get new state, or reclaim its state.
+// New state waits for the end of the grace period (NFS4ERR_GRACE), and
+// only the clients of the previous instance of the server reclaim,
+// during it (NFS4ERR_NO_GRACE).
+func (st *State) claim(clientId uint64, reclaim bool) uint32 {
+	inGrace := st.InGrace()
+	switch {
+	case !reclaim && inGrace:
+		return nfs.NFS4ERR_GRACE
+	case !reclaim:
+		return nfs.NFS4_OK
+	case !inGrace:
+		return nfs.NFS4ERR_NO_GRACE
+	}
+
+	id, found := st.clients.idOf(clientId)
+
+	st.grace.mu.Lock()
+	defer st.grace.mu.Unlock()
+
+	if !found || !st.grace.reclaim[id] {
+		return nfs.NFS4ERR_NO_GRACE
+	}
+	return nfs.NFS4_OK
+}
+
+// record records a client confirmed on stable storage.
+func (st *State) record(clientId uint64) {
+	id, found := st.clients.idOf(clientId)
+	if !found {
+		return
+	}
+
+	g := st.grace
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.store == nil {
+		return
+	}
+	if err := g.store.Add(id); err != nil {
+		log.Warnf("ClientStore.Add: %v", err)
+		return
+	}
+	g.stored[clientId] = id
+}
+
+// forget removes a client which lost its state from stable storage.
+func (st *State) forget(clientId uint64) {
+	g := st.grace
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	id, found := g.stored[clientId]
+	if !found {
+		return
+	}
+	delete(g.stored, clientId)
+	if err := g.store.Remove(id); err != nil {
+		log.Warnf("ClientStore.Remove: %v", err)
+	}
+}
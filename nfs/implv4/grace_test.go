@@ -0,0 +1,97 @@
+package implv4
+
+import (
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+func TestFileClientStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "clients")
+
+	s := NewFileClientStore(path)
+	if ids, err := s.Load(); err != nil || len(ids) != 0 {
+		t.Fatalf("Load of a missing file: %v, %v", ids, err)
+	}
+	for _, id := range []string{"a", "b\n", "c"} {
+		if err := s.Add(id); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := s.Remove("c"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+
+	ids, err := NewFileClientStore(path).Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	sort.Strings(ids)
+	if len(ids) != 2 || ids[0] != "a" || ids[1] != "b\n" {
+		t.Fatalf("Load: %q", ids)
+	}
+}
+
+func TestStateGrace(t *testing.T) {
+	store := NewFileClientStore(filepath.Join(t.TempDir(), "clients"))
+	if err := store.Add("a"); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	st := NewState()
+	st.SetClientStore(store)
+	if err := st.StartGrace(time.Minute); err != nil {
+		t.Fatalf("StartGrace: %v", err)
+	}
+	if !st.InGrace() {
+		t.Fatalf("no grace period with a client to wait for")
+	}
+
+	setClientId := func(id string) uint64 {
+		args := &nfs.SETCLIENTID4args{
+			Client:   &nfs.NfsClientId4{Verifier: 1, Id: []byte(id)},
+			Callback: &nfs.CbClient4{CbLocation: &nfs.ClientAddr4{}},
+		}
+		rec, _, _ := st.clients.setClientId("u", args)
+		if status := st.confirm("u", rec.clientId, rec.confirm); status != nfs.NFS4_OK {
+			t.Fatalf("confirm: %d", status)
+		}
+		return rec.clientId
+	}
+	a, b := setClientId("a"), setClientId("b")
+
+	for _, c := range []struct {
+		clientId uint64
+		reclaim  bool
+		status   uint32
+	}{
+		{a, false, nfs.NFS4ERR_GRACE},
+		{a, true, nfs.NFS4_OK},
+		{b, true, nfs.NFS4ERR_NO_GRACE},
+		{b, false, nfs.NFS4ERR_GRACE},
+	} {
+		if status := st.claim(c.clientId, c.reclaim); status != c.status {
+			t.Fatalf("claim(%x, %v): %d, expected %d", c.clientId, c.reclaim, status, c.status)
+		}
+	}
+
+	st.endGrace(time.Now().Add(time.Hour))
+	if st.InGrace() {
+		t.Fatalf("grace period not over")
+	}
+	if status := st.claim(a, false); status != nfs.NFS4_OK {
+		t.Fatalf("claim after the grace period: %d", status)
+	}
+	if status := st.claim(a, true); status != nfs.NFS4ERR_NO_GRACE {
+		t.Fatalf("reclaim after the grace period: %d", status)
+	}
+
+	// Both clients came back.
+	ids, _ := store.Load()
+	if len(ids) != 2 {
+		t.Fatalf("clients stored: %q", ids)
+	}
+}
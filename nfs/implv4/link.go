@@ -37,6 +37,12 @@ func link(x nfs.RPCContext, args *nfs.LINK4args) (*nfs.LINK4res, error) {
 		return &nfs.LINK4res{Status: nfs.NFS4err(err)}, nil
 	}
 
+	// The delegations of the file to other clients are recalled first.
+	clientId, _ := stat.ClientId()
+	if status := stateOf(x).recall(savefh, clientId, true); status != nfs.NFS4_OK {
+		return &nfs.LINK4res{Status: status}, nil
+	}
+
 	//
 	// Check destination.
 	//
@@ -47,6 +53,9 @@ func link(x nfs.RPCContext, args *nfs.LINK4args) (*nfs.LINK4res, error) {
 		return &nfs.LINK4res{Status: nfs.NFS4err(err)}, nil
 	}
 
+	ch := stateOf(x).changeDirs(vfs, folder)
+	defer ch.done()
+
 	newpath := path.Join(folder, args.NewName)
 	_, err = vfs.Stat(newpath)
 	if err == nil || os.IsExist(err) {
@@ -68,11 +77,7 @@ func link(x nfs.RPCContext, args *nfs.LINK4args) (*nfs.LINK4res, error) {
 	return &nfs.LINK4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.LINK4resok{
-			CInfo: &nfs.ChangeInfo4{
-				Atomic: true,
-				Before: 0,
-				After:  0,
-			},
+			CInfo: ch.info(folder),
 		},
 	}, nil
 }
@@ -0,0 +1,167 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func readOpLockArgs(r *xdr.Reader) (*nfs.LOCK4args, int, error) {
+	sizeConsumed := 0
+	args := &nfs.LOCK4args{Locker: &nfs.Locker4{}}
+
+	for _, v := range []interface{}{
+		&args.LockType,
+		&args.Reclaim,
+		&args.Offset,
+		&args.Length,
+		&args.Locker.NewLockOwner,
+	} {
+		size, err := r.ReadAs(v)
+		if err != nil {
+			return nil, sizeConsumed, err
+		}
+		sizeConsumed += size
+	}
+
+	// locker4
+	locker := interface{}(nil)
+	if args.Locker.NewLockOwner {
+		args.Locker.OpenOwner = &nfs.OpenToLockOwner4{}
+		locker = args.Locker.OpenOwner
+	} else {
+		args.Locker.LockOwner = &nfs.ExistLockOwner4{}
+		locker = args.Locker.LockOwner
+	}
+	if size, err := r.ReadAs(locker); err != nil {
+		return nil, sizeConsumed, err
+	} else {
+		sizeConsumed += size
+	}
+
+	return args, sizeConsumed, nil
+}
+
+// lockRange returns the lock of a range, in the table of locks. It fails
+// with NFS4ERR_INVAL for an invalid lock type or range.
+func lockRange(fh []byte, owner locks.Owner, lockType uint32, offset, length uint64) (locks.Lock, uint32) {
+	l := locks.Lock{
+		File:   string(fh),
+		Owner:  owner,
+		Offset: offset,
+		Length: length,
+	}
+
+	switch lockType {
+	case nfs.READ_LT, nfs.READW_LT:
+	case nfs.WRITE_LT, nfs.WRITEW_LT:
+		l.Exclusive = true
+	default:
+		return l, nfs.NFS4ERR_INVAL
+	}
+
+	// A length of all ones locks up to the end of the file.
+	switch {
+	case length == 0:
+		return l, nfs.NFS4ERR_INVAL
+	case length == ^uint64(0):
+		l.Length = 0
+	case length > ^uint64(0)-offset:
+		return l, nfs.NFS4ERR_INVAL
+	}
+	return l, nfs.NFS4_OK
+}
+
+// lock acquires a byte-range lock (rfc7530, 16.10). A new lock-owner gets
+// its lock state from an open, sequenced by the open-owner. The blocking
+// lock types are not queued: the clients poll.
+func lock(x nfs.RPCContext, args *nfs.LOCK4args) (*nfs.ResGenericRaw, error) {
+	st := stateOf(x)
+
+	if args.Locker.NewLockOwner {
+		ol := args.Locker.OpenOwner
+
+		of, status := findOpen(x, ol.OpenStateId)
+		if of == nil {
+			log.Warnf("lock: %d", status)
+			return &nfs.ResGenericRaw{Status: status}, nil
+		}
+
+		oo := st.owners.get(of.clientId, of.owner, true)
+		return oo.do(ol.OpenSeqId, false, func() (interface{}, error) {
+			switch {
+			case status != nfs.NFS4_OK:
+			case !of.confirmed:
+				status = nfs.NFS4ERR_BAD_STATEID
+			case ol.LockOwner.ClientId != of.clientId:
+				status = nfs.NFS4ERR_INVAL
+			}
+			if status != nfs.NFS4_OK {
+				log.Warnf("lock: %d", status)
+				return &nfs.LOCK4res{Status: status}, nil
+			}
+
+			lo := st.owners.getLock(of.clientId, ol.LockOwner.Owner, true)
+			return lo.do(ol.LockSeqId, true, func() (interface{}, error) {
+				return lockFile(x, st.opens.addLock(of, ol.LockOwner), args)
+			})
+		})
+	}
+
+	el := args.Locker.LockOwner
+
+	ls, status := findLock(x, el.LockStateId)
+	if ls == nil {
+		log.Warnf("lock: %d", status)
+		return &nfs.ResGenericRaw{Status: status}, nil
+	}
+
+	lo := st.owners.getLock(ls.clientId, ls.owner, true)
+	return lo.do(el.LockSeqId, false, func() (interface{}, error) {
+		if status != nfs.NFS4_OK {
+			log.Warnf("lock: %d", status)
+			return &nfs.LOCK4res{Status: status}, nil
+		}
+		return lockFile(x, ls, args)
+	})
+}
+
+func lockFile(x nfs.RPCContext, ls *lockState, args *nfs.LOCK4args) (*nfs.LOCK4res, error) {
+	st := stateOf(x)
+
+	// New locks wait for the end of the grace period, in which the
+	// clients reclaim their locks.
+	if status := st.claim(ls.clientId, args.Reclaim); status != nfs.NFS4_OK {
+		return &nfs.LOCK4res{Status: status}, nil
+	}
+
+	l, status := lockRange(ls.open.fh, lockOwner(ls.clientId, ls.owner), args.LockType, args.Offset, args.Length)
+	if status != nfs.NFS4_OK {
+		return &nfs.LOCK4res{Status: status}, nil
+	}
+
+	// A read lock needs the file opened for reading, and a write lock
+	// for writing.
+	access := nfs.OPEN4_SHARE_ACCESS_READ
+	if l.Exclusive {
+		access = nfs.OPEN4_SHARE_ACCESS_WRITE
+	}
+	if !st.opens.allows(ls.open, access) {
+		return &nfs.LOCK4res{Status: nfs.NFS4ERR_OPENMODE}, nil
+	}
+
+	if c, ok := st.locks.Lock(l); !ok {
+		log.Debugf("lock(%s): denied.", ls.open.path)
+		return &nfs.LOCK4res{
+			Status: nfs.NFS4ERR_DENIED,
+			Denied: lockDenied(c),
+		}, nil
+	}
+
+	res := &nfs.LOCK4res{
+		Status: nfs.NFS4_OK,
+		Ok:     st.opens.bumpLock(ls),
+	}
+	return res, nil
+}
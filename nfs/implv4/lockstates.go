@@ -0,0 +1,140 @@
+package implv4
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// lockState is the state of the locks of a lock-owner on a file, given by
+// LOCK to the owner along with a stateid of its own. It belongs to an
+// open of the file, and goes away with it.
+type lockState struct {
+	clientId uint64
+	owner    string // lock_owner4.owner
+	open     *openFile
+
+	other [3]uint32
+	seqId uint32
+}
+
+// lockOwner returns the owner of the locks of a lock-owner in the table
+// of locks, which NLM shares.
+func lockOwner(clientId uint64, owner string) locks.Owner {
+	return locks.Owner{Host: lockHost(clientId), Id: owner, ClientId: clientId}
+}
+
+func lockHost(clientId uint64) string {
+	return fmt.Sprintf("nfs4:%x", clientId)
+}
+
+// lockDenied returns the LOCK4denied of a conflicting lock. The locks of
+// NLM have no client id.
+func lockDenied(l locks.Lock) *nfs.LOCK4denied {
+	d := &nfs.LOCK4denied{
+		Offset:   l.Offset,
+		Length:   l.Length,
+		LockType: nfs.READ_LT,
+		Owner:    &nfs.LockOwner4{ClientId: l.Owner.ClientId, Owner: l.Owner.Id},
+	}
+	if l.Length == 0 {
+		d.Length = ^uint64(0)
+	}
+	if l.Exclusive {
+		d.LockType = nfs.WRITE_LT
+	}
+	return d
+}
+
+// addLock returns the lock state of a lock-owner on the file of an open,
+// created if needed.
+func (t *openTable) addLock(of *openFile, owner *nfs.LockOwner4) *lockState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, ls := range t.locks {
+		if ls.open == of && ls.clientId == owner.ClientId && ls.owner == owner.Owner {
+			return ls
+		}
+	}
+
+	ls := &lockState{
+		clientId: owner.ClientId,
+		owner:    owner.Owner,
+		open:     of,
+		other:    t.newOther(),
+	}
+	t.locks[ls.other] = ls
+	return ls
+}
+
+// getLock returns the lock state of a stateid, on the file of a handle.
+// The state is returned along with an error as long as the stateid is
+// known.
+func (t *openTable) getLock(sid *nfs.StateId4, fh []byte) (*lockState, uint32) {
+	if sid == nil || isSpecialStateId(sid) {
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	}
+	if sid.Other[0] != t.boot {
+		return nil, nfs.NFS4ERR_STALE_STATEID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ls, found := t.locks[sid.Other]
+	switch {
+	case !found:
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	case sid.SeqId < ls.seqId:
+		return ls, nfs.NFS4ERR_OLD_STATEID
+	case sid.SeqId > ls.seqId:
+		return ls, nfs.NFS4ERR_BAD_STATEID
+	case fh != nil && !bytes.Equal(ls.open.fh, fh):
+		return ls, nfs.NFS4ERR_BAD_STATEID
+	}
+	return ls, nfs.NFS4_OK
+}
+
+// bumpLock bumps the seqid of a lock state changed, and returns its new
+// stateid.
+func (t *openTable) bumpLock(ls *lockState) *nfs.StateId4 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ls.seqId++
+	return &nfs.StateId4{SeqId: ls.seqId, Other: ls.other}
+}
+
+// removeLocks removes the lock states of the opens given, and returns
+// them.
+func (t *openTable) removeLocks(opens ...*openFile) []*lockState {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := []*lockState{}
+	for other, ls := range t.locks {
+		for _, of := range opens {
+			if ls.open == of {
+				delete(t.locks, other)
+				rs = append(rs, ls)
+				break
+			}
+		}
+	}
+	return rs
+}
+
+// releaseLockOwner removes the lock states of a lock-owner.
+func (t *openTable) releaseLockOwner(clientId uint64, owner string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for other, ls := range t.locks {
+		if ls.clientId == clientId && ls.owner == owner {
+			delete(t.locks, other)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// lockTest tests whether a lock could be acquired, without any state
+// (rfc7530, 16.11).
+func lockTest(x nfs.RPCContext, args *nfs.LOCKT4args) (*nfs.LOCKT4res, error) {
+	st := stateOf(x)
+
+	fh := x.Stat().CurrentHandle()
+	if len(fh) == 0 {
+		return &nfs.LOCKT4res{Status: nfs.NFS4ERR_NOFILEHANDLE}, nil
+	}
+
+	clientId := args.Owner.ClientId
+	if status := st.clients.renew(clientId); status != nfs.NFS4_OK {
+		log.Warnf("lockt: client %x: %d", clientId, status)
+		return &nfs.LOCKT4res{Status: status}, nil
+	}
+
+	if st.InGrace() {
+		return &nfs.LOCKT4res{Status: nfs.NFS4ERR_GRACE}, nil
+	}
+
+	l, status := lockRange(fh, lockOwner(clientId, args.Owner.Owner), args.LockType, args.Offset, args.Length)
+	if status != nfs.NFS4_OK {
+		return &nfs.LOCKT4res{Status: status}, nil
+	}
+
+	if c, found := st.locks.Test(l); found {
+		return &nfs.LOCKT4res{
+			Status: nfs.NFS4ERR_DENIED,
+			Denied: lockDenied(c),
+		}, nil
+	}
+	return &nfs.LOCKT4res{Status: nfs.NFS4_OK}, nil
+}
@@ -0,0 +1,37 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// unlock releases a byte-range lock (rfc7530, 16.12).
+func unlock(x nfs.RPCContext, args *nfs.LOCKU4args) (*nfs.ResGenericRaw, error) {
+	st := stateOf(x)
+
+	ls, status := findLock(x, args.LockStateId)
+	if ls == nil {
+		log.Warnf("locku: %d", status)
+		return &nfs.ResGenericRaw{Status: status}, nil
+	}
+
+	lo := st.owners.getLock(ls.clientId, ls.owner, true)
+	return lo.do(args.SeqId, false, func() (interface{}, error) {
+		if status != nfs.NFS4_OK {
+			log.Warnf("locku: %d", status)
+			return &nfs.LOCKU4res{Status: status}, nil
+		}
+
+		l, status := lockRange(ls.open.fh, lockOwner(ls.clientId, ls.owner), args.LockType, args.Offset, args.Length)
+		if status != nfs.NFS4_OK {
+			return &nfs.LOCKU4res{Status: status}, nil
+		}
+		st.locks.Unlock(l)
+
+		res := &nfs.LOCKU4res{
+			Status: nfs.NFS4_OK,
+			Ok:     st.opens.bumpLock(ls),
+		}
+		return res, nil
+	})
+}
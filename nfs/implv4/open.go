@@ -87,8 +87,8 @@ func readOpOpenArgs(r *xdr.Reader) (*nfs.OPEN4args, int, error) {
 
 		default:
 			return nil, sizeConsumed, fmt.Errorf(
-				"unexpected createmode: %v",
-				how.CreateMode,
+				"%w: unexpected createmode: %v",
+				nfs.ErrGarbageArgs, how.CreateMode,
 			)
 		}
 
@@ -120,6 +120,7 @@ func readOpOpenArgs(r *xdr.Reader) (*nfs.OPEN4args, int, error) {
 		if err != nil {
 			return nil, sizeConsumed, err
 		}
+		sizeConsumed += 4
 		claim.DelegateType = delegateTyp
 
 	case nfs.CLAIM_DELEGATE_CUR:
@@ -141,7 +142,7 @@ func readOpOpenArgs(r *xdr.Reader) (*nfs.OPEN4args, int, error) {
 		claim.FileDelegatePrev = prev
 
 	default:
-		return nil, sizeConsumed, fmt.Errorf("invalid claim: %v", claim.Claim)
+		return nil, sizeConsumed, fmt.Errorf("%w: invalid claim: %v", nfs.ErrGarbageArgs, claim.Claim)
 	}
 
 	args.Claim = claim
@@ -149,6 +150,41 @@ func readOpOpenArgs(r *xdr.Reader) (*nfs.OPEN4args, int, error) {
 }
 
 func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
+	// The open-owner is bound to a confirmed client, whose lease the
+	// open renews.
+	st := stateOf(x)
+	clientId := args.Owner.ClientId
+	if status := st.clients.renew(clientId); status != nfs.NFS4_OK {
+		log.Warnf("open: client %x: %d", clientId, status)
+		return &nfs.ResGenericRaw{Status: status}, nil
+	}
+	x.Stat().SetClientId(clientId)
+
+	oo := st.owners.get(clientId, args.Owner.Owner, true)
+	res, err := oo.do(args.SeqId, true, func() (interface{}, error) {
+		if !oo.confirmed {
+			// The opens of an owner never confirmed are dropped by a
+			// new OPEN.
+			st.closeOpens(st.opens.releaseOwner(clientId, args.Owner.Owner))
+		}
+		res, err := openObject(x, args, oo.confirmed)
+		if err == nil && res.Status == nfs.NFS4_OK {
+			oo.lastFh = x.Stat().CurrentHandle()
+		}
+		return res, err
+	})
+	if err == nil && res.Status == nfs.NFS4_OK {
+		// A replayed OPEN leaves the file opened as the current fh too.
+		oo.mu.Lock()
+		x.Stat().SetCurrentHandle(oo.lastFh)
+		oo.mu.Unlock()
+	}
+	return res, err
+}
+
+// openObject opens a file for an open-owner. The open of an owner not
+// confirmed yet has to be confirmed by OPEN_CONFIRM.
+func openObject(x nfs.RPCContext, args *nfs.OPEN4args, confirmed bool) (*nfs.ResGenericRaw, error) {
 	// log.Infof(toJson(args))
 
 	resFail500 := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_SERVERFAULT}
@@ -156,6 +192,41 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 	resFailDup := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_EXIST}
 	resFail404 := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOENT}
 
+	switch {
+	case args.ShareAccess == 0 || args.ShareAccess&^nfs.OPEN4_SHARE_ACCESS_BOTH != 0:
+		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_INVAL}, nil
+	case args.ShareDeny&^nfs.OPEN4_SHARE_DENY_BOTH != 0:
+		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_INVAL}, nil
+	}
+
+	// New opens wait for the end of the grace period, in which the
+	// clients reclaim the files they opened before a restart of the
+	// server (rfc7530, 9.6.2).
+	st := stateOf(x)
+	name := args.Claim.File
+	reclaim := false
+
+	switch args.Claim.Claim {
+	case nfs.CLAIM_NULL:
+	case nfs.CLAIM_PREVIOUS:
+		reclaim = true
+	case nfs.CLAIM_DELEGATE_CUR:
+		// An open done under a delegation, told to the server before
+		// returning it.
+		cur := args.Claim.DelegateCurInfo
+		if _, status := st.opens.getDeleg(cur.DelegateStateId, nil); status != nfs.NFS4_OK {
+			return &nfs.ResGenericRaw{Status: status}, nil
+		}
+		name = cur.File
+	default:
+		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOTSUPP}, nil
+	}
+	if args.Claim.Claim != nfs.CLAIM_DELEGATE_CUR {
+		if status := st.claim(args.Owner.ClientId, reclaim); status != nfs.NFS4_OK {
+			return &nfs.ResGenericRaw{Status: status}, nil
+		}
+	}
+
 	createIfNotExists := false
 	raiseWhenExists := true
 	trunc := false
@@ -190,18 +261,39 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 	stat := x.Stat()
 	vfs := x.GetFS()
 
-	cwd, err := vfs.ResolveHandle(stat.CurrentHandle())
-	if err != nil {
-		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_PERM}, nil
-	}
+	dir, pathName := "", ""
+	if reclaim {
+		// The current filehandle is the file reclaimed, which is
+		// neither created nor truncated.
+		createIfNotExists, raiseWhenExists, trunc = false, false, false
+
+		p, err := vfs.ResolveHandle(stat.CurrentHandle())
+		if err != nil {
+			return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_RECLAIM_BAD}, nil
+		}
+		dir, pathName = fs.Dir(p), p
+	} else {
+		cwd, err := vfs.ResolveHandle(stat.CurrentHandle())
+		if err != nil {
+			return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_PERM}, nil
+		}
+
+		if di, err := vfs.Stat(cwd); err != nil {
+			return resFail500, nil
+		} else if !di.IsDir() {
+			return resFailPerm, nil
+		}
 
-	if di, err := vfs.Stat(cwd); err != nil {
-		return resFail500, nil
-	} else if !di.IsDir() {
-		return resFailPerm, nil
+		dir, pathName = cwd, fs.Join(cwd, name)
 	}
 
-	pathName := fs.Join(cwd, args.Claim.File)
+	// A creation changes the directory: the changes of the directory
+	// by the other calls wait for it.
+	var ch *dirChange
+	if createIfNotExists {
+		ch = st.changeDirs(vfs, dir)
+		defer ch.done()
+	}
 	createNew := false
 
 	fi, err := vfs.Stat(pathName)
@@ -230,7 +322,12 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 
 	attrSet := []uint32{}
 
-	seqId := uint32(0) // RFC7531: stateid4.seqid
+	opened := (fs.File)(nil)
+	fh := []byte(nil)
+
+	// The share reservation of the open, upgraded if the owner
+	// already opened the file.
+	access, deny := args.ShareAccess, args.ShareDeny
 
 	if createNew {
 		flag := os.O_CREATE | os.O_RDWR | os.O_TRUNC
@@ -244,10 +341,11 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 			log.Warnf("vfs.OpenFile(%s): %v", pathName, err)
 			return resFailPerm, nil
 		} else {
-			seqId = x.Stat().AddOpenedFile(pathName, f)
+			opened = f
 
 			fi, err := f.Stat()
 			if err != nil {
+				f.Close()
 				return resFailPerm, nil
 			}
 
@@ -255,14 +353,40 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 
 			if args.CreateHow != nil && args.CreateHow.CreateAttrs != nil {
 				idxReq := bitmap4Decode(args.CreateHow.CreateAttrs.Mask)
-				a4 := fileInfoToAttrs(vfs, pathName, fi, idxReq)
+				a4 := fileInfoToAttrs(x, pathName, fi, idxReq)
 				attrSet = a4.Mask
 			}
 		}
 
 	} else {
 
+		if h, err := vfs.GetHandle(fi); err != nil {
+			log.Warnf("vfs.GetHandle: %v", err)
+			return resFailPerm, nil
+		} else {
+			fh = h
+		}
+
+		// The delegations of the file to other clients conflicting
+		// with the open are recalled first.
+		write := access&nfs.OPEN4_SHARE_ACCESS_WRITE != 0 || deny&nfs.OPEN4_SHARE_DENY_READ != 0
+		if status := st.recall(fh, args.Owner.ClientId, write); status != nfs.NFS4_OK {
+			return &nfs.ResGenericRaw{Status: status}, nil
+		}
+
+		var status uint32
+		access, deny, status = st.opens.share(args.Owner, fh, access, deny)
+		if status != nfs.NFS4_OK {
+			log.Warnf("open(%s): %d", pathName, status)
+			return &nfs.ResGenericRaw{Status: status}, nil
+		}
+
+		// A read-only open opens the file read-only. Reading is
+		// allowed to an open for writing only as well.
 		flag := os.O_RDWR
+		if access&nfs.OPEN4_SHARE_ACCESS_WRITE == 0 && !trunc {
+			flag = os.O_RDONLY
+		}
 		if trunc {
 			flag = flag | os.O_TRUNC
 		}
@@ -271,31 +395,55 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 			log.Warnf("vfs.OpenFile(%s): %v", pathName, err)
 			return resFailPerm, nil
 		} else {
-			seqId = x.Stat().AddOpenedFile(pathName, f)
+			opened = f
 		}
 
 	}
 
-	if fh, err := vfs.GetHandle(finalFi); err != nil {
-		log.Warnf("vfs.GetHandle: %v", err)
-		return resFailPerm, nil
-	} else {
-		stat.SetCurrentHandle(fh)
+	if fh == nil {
+		if h, err := vfs.GetHandle(finalFi); err != nil {
+			log.Warnf("vfs.GetHandle: %v", err)
+			opened.Close()
+			return resFailPerm, nil
+		} else {
+			fh = h
+		}
+	}
+
+	sid, status := st.opens.add(args.Owner, fh, pathName, opened, access, deny, confirmed)
+	if status != nfs.NFS4_OK {
+		log.Warnf("open(%s): %d", pathName, status)
+		opened.Close()
+		return &nfs.ResGenericRaw{Status: status}, nil
+	}
+	stat.SetCurrentHandle(fh)
+
+	rflags := uint32(0)
+	if !confirmed {
+		rflags |= nfs.OPEN4_RESULT_CONFIRM
+	}
+
+	delegation := &nfs.OpenDelegation4{Type: nfs.OPEN_DELEGATE_NONE}
+	if _, _, up := st.clients.callbackOf(args.Owner.ClientId); up && (!reclaim || args.Claim.DelegateType != nfs.OPEN_DELEGATE_NONE) {
+		delegation = st.delegate(args.Owner.ClientId, fh, pathName, access)
+	}
+
+	cinfo := &nfs.ChangeInfo4{Atomic: true}
+	if ch != nil {
+		cinfo = ch.info(dir)
+	} else if di, err := vfs.Stat(dir); err == nil {
+		cinfo.Before = changeOf(di)
+		cinfo.After = cinfo.Before
 	}
 
 	res := &nfs.OPEN4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.OPEN4resok{
-			StateId: &nfs.StateId4{
-				SeqId: seqId,
-				Other: [3]uint32{0, 0, 0},
-			},
-			CInfo:   &nfs.ChangeInfo4{},
-			Rflags:  uint32(0), // OPEN4_RESULT_*
-			AttrSet: attrSet,
-			Delegation: &nfs.OpenDelegation4{
-				Type: nfs.OPEN_DELEGATE_NONE,
-			},
+			StateId:    sid,
+			CInfo:      cinfo,
+			Rflags:     rflags,
+			AttrSet:    attrSet,
+			Delegation: delegation,
 		},
 	}
 
@@ -309,3 +457,27 @@ func open(x nfs.RPCContext, args *nfs.OPEN4args) (*nfs.ResGenericRaw, error) {
 		Reader: bytes.NewReader(buff.Bytes()),
 	}, nil
 }
+
+// delegate delegates an opened file to a client if possible, for
+// reading only: without CB_GETATTR, the other clients would see stale
+// sizes of a file delegated for writing. A file opened for writing is
+// not delegated.
+func (st *State) delegate(clientId uint64, fh []byte, pathName string, access uint32) *nfs.OpenDelegation4 {
+	if access&nfs.OPEN4_SHARE_ACCESS_WRITE != 0 {
+		return &nfs.OpenDelegation4{Type: nfs.OPEN_DELEGATE_NONE}
+	}
+
+	sid := st.opens.delegate(clientId, fh, pathName, false)
+	if sid == nil {
+		return &nfs.OpenDelegation4{Type: nfs.OPEN_DELEGATE_NONE}
+	}
+
+	return &nfs.OpenDelegation4{
+		Type: nfs.OPEN_DELEGATE_READ,
+		Read: &nfs.OpenReadDelegation4{
+			StateId: sid,
+			// An empty ace: the client has to check the access with ACCESS.
+			Permissions: &nfs.NfsAce4{Type: nfs.ACE4_ACCESS_ALLOWED_ACE_TYPE},
+		},
+	}
+}
@@ -0,0 +1,37 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// openConfirm confirms the first open of an open-owner (rfc7530, 16.20).
+func openConfirm(x nfs.RPCContext, args *nfs.OPEN_CONFIRM4args) (*nfs.ResGenericRaw, error) {
+	st := stateOf(x)
+
+	of, status := findOpen(x, args.OpenStateId)
+	if of == nil {
+		log.Warnf("open_confirm: %d", status)
+		return &nfs.ResGenericRaw{Status: status}, nil
+	}
+
+	oo := st.owners.get(of.clientId, of.owner, true)
+	return oo.do(args.SeqId, false, func() (interface{}, error) {
+		if status == nfs.NFS4_OK && (oo.confirmed || of.confirmed) {
+			status = nfs.NFS4ERR_BAD_STATEID
+		}
+		if status != nfs.NFS4_OK {
+			log.Warnf("open_confirm: %d", status)
+			return &nfs.OPEN_CONFIRM4res{Status: status}, nil
+		}
+
+		oo.confirmed = true
+		res := &nfs.OPEN_CONFIRM4res{
+			Status: nfs.NFS4_OK,
+			Ok: &nfs.OPEN_CONFIRM4resok{
+				OpenStateId: st.opens.confirm(of),
+			},
+		}
+		return res, nil
+	})
+}
@@ -1,6 +1,10 @@
 package implv4
 
 import (
+	"bytes"
+	"os"
+
+	"github.com/smallfz/libnfs-go/fs"
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
 	"github.com/smallfz/libnfs-go/xdr"
@@ -22,18 +26,61 @@ func readOpOpenDgArgs(r *xdr.Reader) (*nfs.OPENDG4args, int, error) {
 func openDg(x nfs.RPCContext, args *nfs.OPENDG4args) (*nfs.ResGenericRaw, error) {
 	// log.Infof(toJson(args))
 
-	// resFail500 := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_SERVERFAULT}
-	resFailPerm := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_PERM}
-	// resFailDup := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_EXIST}
-	// resFail404 := &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOENT}
+	st := stateOf(x)
 
-	state := x.Stat().GetOpenedFile(args.SeqId)
-	if state == nil {
-		log.Warnf("try to open_downgrade on a not-openned file.")
-		return resFailPerm, nil
+	of, status := findOpen(x, args.OpenStateId)
+	if of == nil {
+		log.Warnf("open_downgrade: %d", status)
+		return &nfs.ResGenericRaw{Status: status}, nil
 	}
 
-	return &nfs.ResGenericRaw{
-		Status: nfs.NFS4_OK,
-	}, nil
+	oo := st.owners.get(of.clientId, of.owner, true)
+	return oo.do(args.SeqId, false, func() (interface{}, error) {
+		if status == nfs.NFS4_OK && !of.confirmed {
+			status = nfs.NFS4ERR_BAD_STATEID
+		}
+		if status == nfs.NFS4_OK && !shareSubset(args.ShareAccess, args.ShareDeny, of) {
+			status = nfs.NFS4ERR_INVAL
+		}
+		if status != nfs.NFS4_OK {
+			log.Warnf("open_downgrade: %d", status)
+			return &nfs.ResGenericRaw{Status: status}, nil
+		}
+
+		// An open giving up writing reopens the file read-only.
+		f := (fs.File)(nil)
+		if args.ShareAccess&nfs.OPEN4_SHARE_ACCESS_WRITE == 0 && of.access&nfs.OPEN4_SHARE_ACCESS_WRITE != 0 {
+			opened, err := x.GetFS().OpenFile(of.path, os.O_RDONLY, os.FileMode(0))
+			if err != nil {
+				log.Warnf("vfs.OpenFile(%s): %v", of.path, err)
+				return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_SERVERFAULT}, nil
+			}
+			f = opened
+		}
+
+		// OPEN_DOWNGRADE4resok
+		sid := st.opens.downgrade(of, args.ShareAccess, args.ShareDeny, f)
+
+		buff := bytes.NewBuffer([]byte{})
+		if _, err := xdr.NewWriter(buff).WriteAny(sid); err != nil {
+			return nil, err
+		}
+
+		return &nfs.ResGenericRaw{
+			Status: nfs.NFS4_OK,
+			Reader: bytes.NewReader(buff.Bytes()),
+		}, nil
+	})
+}
+
+// shareSubset reports whether a share reservation is a valid downgrade of
+// the one of an open.
+func shareSubset(access, deny uint32, of *openFile) bool {
+	switch {
+	case access == 0 || access&^nfs.OPEN4_SHARE_ACCESS_BOTH != 0:
+		return false
+	case deny&^nfs.OPEN4_SHARE_DENY_BOTH != 0:
+		return false
+	}
+	return access&^of.access == 0 && deny&^of.deny == 0
 }
@@ -0,0 +1,356 @@
+package implv4
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// openFile is a file opened by an open-owner with OPEN. It belongs to the
+// State rather than to a connection: the client keeps it across
+// reconnections, until it's closed or the lease of the client expires.
+type openFile struct {
+	clientId uint64
+	owner    string // open_owner4.owner
+	fh       []byte
+	path     string
+	f        fs.File
+
+	// The stateid of the open: other is opaque to the client, and the
+	// seqid is bumped by each change of the open.
+	other [3]uint32
+	seqId uint32
+
+	confirmed bool // by OPEN_CONFIRM if the owner was new.
+
+	// The share reservation of the open: OPEN4_SHARE_ACCESS_* and
+	// OPEN4_SHARE_DENY_*.
+	access uint32
+	deny   uint32
+}
+
+var _ fs.FileOpenState = (*openFile)(nil)
+
+func (of *openFile) File() fs.File {
+	return of.f
+}
+
+func (of *openFile) Path() string {
+	return of.path
+}
+
+// Special stateids (rfc7530, 9.1.4.3).
+var (
+	anonymousStateId = nfs.StateId4{}
+	bypassStateId    = nfs.StateId4{
+		SeqId: 0xffffffff,
+		Other: [3]uint32{0xffffffff, 0xffffffff, 0xffffffff},
+	}
+)
+
+func isSpecialStateId(sid *nfs.StateId4) bool {
+	return sid != nil && (*sid == anonymousStateId || *sid == bypassStateId)
+}
+
+// openTable holds the files opened by the clients of a server, by the
+// other field of their stateid (see newOther).
+type openTable struct {
+	mu     sync.Mutex
+	boot   uint32
+	files  map[[3]uint32]*openFile
+	locks  map[[3]uint32]*lockState
+	delegs map[[3]uint32]*delegation
+
+	// The stateids of the clients released, to the client ids: their
+	// use fails with NFS4ERR_EXPIRED while the lease of the client is
+	// told expired.
+	released map[[3]uint32]uint64
+}
+
+func newOpenTable(boot uint32) *openTable {
+	return &openTable{
+		boot:     boot,
+		files:    map[[3]uint32]*openFile{},
+		locks:    map[[3]uint32]*lockState{},
+		delegs:   map[[3]uint32]*delegation{},
+		released: map[[3]uint32]uint64{},
+	}
+}
+
+// newOther returns the other field of a new stateid: the boot time of
+// the server, so that the stateids of its previous instances are told
+// stale, then random words, so that a client can't guess the stateids of
+// another (rfc7530, 9.1.4.1). Called with t.mu held.
+func (t *openTable) newOther() [3]uint32 {
+	buf := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+			panic(fmt.Sprintf("crypto/rand: %v", err))
+		}
+		other := [3]uint32{t.boot, binary.BigEndian.Uint32(buf), binary.BigEndian.Uint32(buf[4:])}
+		_, open := t.files[other]
+		_, lock := t.locks[other]
+		_, deleg := t.delegs[other]
+		if !open && !lock && !deleg {
+			return other
+		}
+	}
+}
+
+// lookup returns the open of an open-owner on a file, if any.
+func (t *openTable) lookup(owner *nfs.OpenOwner4, fh []byte) *openFile {
+	for _, of := range t.files {
+		if of.clientId == owner.ClientId && of.owner == owner.Owner && bytes.Equal(of.fh, fh) {
+			return of
+		}
+	}
+	return nil
+}
+
+// denied reports whether the opens of a file by other open-owners than
+// the one of except conflict with a share reservation (rfc7530, 9.9).
+func (t *openTable) denied(fh []byte, except *nfs.OpenOwner4, access, deny uint32) bool {
+	for _, of := range t.files {
+		if !bytes.Equal(of.fh, fh) {
+			continue
+		}
+		if except != nil && of.clientId == except.ClientId && of.owner == except.Owner {
+			continue
+		}
+		if access&of.deny != 0 || deny&of.access != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// share returns the share reservation an open-owner gets by opening a file
+// with access and deny: the open of the file by the owner, if any, is
+// upgraded to the union of both.
+// It fails with NFS4ERR_SHARE_DENIED if the reservation conflicts with
+// the opens of other owners.
+func (t *openTable) share(owner *nfs.OpenOwner4, fh []byte, access, deny uint32) (uint32, uint32, uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if of := t.lookup(owner, fh); of != nil {
+		access |= of.access
+		deny |= of.deny
+	}
+	if t.denied(fh, owner, access, deny) {
+		return 0, 0, nfs.NFS4ERR_SHARE_DENIED
+	}
+	return access, deny, nfs.NFS4_OK
+}
+
+// denies reports whether an open of a file denies access to the others,
+// as to a READ or WRITE with a special stateid.
+func (t *openTable) denies(fh []byte, access uint32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.denied(fh, nil, access, nfs.OPEN4_SHARE_DENY_NONE)
+}
+
+// add records a file opened by an open-owner with a share reservation
+// given by share. If the owner already opened the file, the open is
+// upgraded: the stateid is kept with its seqid bumped, and the file
+// previously opened is closed.
+// The open of an owner not confirmed yet has to be confirmed.
+// It fails with NFS4ERR_SHARE_DENIED if an open by another owner
+// conflicting with the reservation was added since.
+func (t *openTable) add(owner *nfs.OpenOwner4, fh []byte, pathName string, f fs.File, access, deny uint32, confirmed bool) (*nfs.StateId4, uint32) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.denied(fh, owner, access, deny) {
+		return nil, nfs.NFS4ERR_SHARE_DENIED
+	}
+
+	if of := t.lookup(owner, fh); of != nil {
+		if err := of.f.Close(); err != nil {
+			log.Warnf("f.Close: %v", err)
+		}
+		of.f = f
+		of.path = pathName
+		of.access = access
+		of.deny = deny
+		of.seqId++
+		return &nfs.StateId4{SeqId: of.seqId, Other: of.other}, nfs.NFS4_OK
+	}
+
+	of := &openFile{
+		clientId: owner.ClientId,
+		owner:    owner.Owner,
+		fh:       fh,
+		path:     pathName,
+		f:        f,
+		other:    t.newOther(),
+		seqId:    1,
+
+		confirmed: confirmed,
+		access:    access,
+		deny:      deny,
+	}
+	t.files[of.other] = of
+	return &nfs.StateId4{SeqId: of.seqId, Other: of.other}, nfs.NFS4_OK
+}
+
+// allows reports whether the share reservation of an open allows access.
+func (t *openTable) allows(of *openFile, access uint32) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return of.access&access != 0
+}
+
+// downgrade narrows the share reservation of an open, and returns its new
+// stateid. The file opened is replaced by f unless it's nil.
+func (t *openTable) downgrade(of *openFile, access, deny uint32, f fs.File) *nfs.StateId4 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if f != nil {
+		if err := of.f.Close(); err != nil {
+			log.Warnf("f.Close: %v", err)
+		}
+		of.f = f
+	}
+	of.access = access
+	of.deny = deny
+	of.seqId++
+	return &nfs.StateId4{SeqId: of.seqId, Other: of.other}
+}
+
+// get returns the file opened with a stateid, on the file of a handle.
+// The file is returned along with an error as long as the stateid is
+// known.
+func (t *openTable) get(sid *nfs.StateId4, fh []byte) (*openFile, uint32) {
+	if sid == nil || isSpecialStateId(sid) {
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	}
+	if sid.Other[0] != t.boot {
+		return nil, nfs.NFS4ERR_STALE_STATEID
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	of, found := t.files[sid.Other]
+	switch {
+	case !found:
+		return nil, nfs.NFS4ERR_BAD_STATEID
+	case sid.SeqId < of.seqId:
+		return of, nfs.NFS4ERR_OLD_STATEID
+	case sid.SeqId > of.seqId:
+		return of, nfs.NFS4ERR_BAD_STATEID
+	case fh != nil && !bytes.Equal(of.fh, fh):
+		return of, nfs.NFS4ERR_BAD_STATEID
+	}
+	return of, nfs.NFS4_OK
+}
+
+// confirm confirms an open, and returns its new stateid.
+func (t *openTable) confirm(of *openFile) *nfs.StateId4 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	of.confirmed = true
+	of.seqId++
+	return &nfs.StateId4{SeqId: of.seqId, Other: of.other}
+}
+
+// find returns the files opened at a path.
+func (t *openTable) find(pathName string) []*openFile {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := []*openFile{}
+	for _, of := range t.files {
+		if of.path == pathName {
+			rs = append(rs, of)
+		}
+	}
+	return rs
+}
+
+// remove removes an open, and returns its last stateid.
+func (t *openTable) remove(of *openFile) *nfs.StateId4 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.files, of.other)
+	of.seqId++
+	return &nfs.StateId4{SeqId: of.seqId, Other: of.other}
+}
+
+// release removes the files opened by a client, and remembers its
+// stateids as released.
+func (t *openTable) release(clientId uint64) []*openFile {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := []*openFile{}
+	for other, of := range t.files {
+		if of.clientId == clientId {
+			delete(t.files, other)
+			t.released[other] = clientId
+			rs = append(rs, of)
+		}
+	}
+	for other, ls := range t.locks {
+		if ls.clientId == clientId {
+			t.released[other] = clientId
+		}
+	}
+	for other, d := range t.delegs {
+		if d.clientId == clientId {
+			t.released[other] = clientId
+		}
+	}
+	return rs
+}
+
+// releaseOwner removes the files opened by an open-owner.
+func (t *openTable) releaseOwner(clientId uint64, owner string) []*openFile {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := []*openFile{}
+	for other, of := range t.files {
+		if of.clientId == clientId && of.owner == owner {
+			delete(t.files, other)
+			rs = append(rs, of)
+		}
+	}
+	return rs
+}
+
+// clientOf returns the client id a stateid released was given to.
+func (t *openTable) clientOf(sid *nfs.StateId4) (uint64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	clientId, found := t.released[sid.Other]
+	return clientId, found
+}
+
+// forgetReleased forgets the stateids released of the clients for which
+// keep returns false.
+func (t *openTable) forgetReleased(keep func(clientId uint64) bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for other, clientId := range t.released {
+		if !keep(clientId) {
+			delete(t.released, other)
+		}
+	}
+}
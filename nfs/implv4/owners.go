@@ -0,0 +1,209 @@
+package implv4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// stateOwner is an open-owner or a lock-owner of a client. Its operations
+// are sequenced by seqid, and the reply of the last one is kept for
+// retransmissions (rfc7530, 9.1.7).
+type stateOwner struct {
+	mu sync.Mutex // serializes the operations of the owner.
+
+	confirmed bool // by OPEN_CONFIRM, for an open-owner.
+
+	replied   bool // seqId and the last reply are set.
+	seqId     uint32
+	lastReply []byte // xdr-encoded result, without the status.
+	lastStat  uint32
+	lastFh    []byte // the current fh after the last OPEN.
+}
+
+type ownerKey struct {
+	clientId uint64
+	owner    string
+	lock     bool // a lock-owner.
+}
+
+// ownerTable holds the open-owners and lock-owners of the clients of a
+// server.
+type ownerTable struct {
+	mu     sync.Mutex
+	owners map[ownerKey]*stateOwner
+
+	// The owners of the stateids closed, to answer retransmissions of
+	// CLOSE: only the last CLOSE of an owner is retransmitted, hence
+	// only its stateid is kept, in lastClosed.
+	closed     map[[3]uint32]ownerKey
+	lastClosed map[ownerKey][3]uint32
+}
+
+func newOwnerTable() *ownerTable {
+	return &ownerTable{
+		owners:     map[ownerKey]*stateOwner{},
+		closed:     map[[3]uint32]ownerKey{},
+		lastClosed: map[ownerKey][3]uint32{},
+	}
+}
+
+// get returns an open-owner, created if create is set.
+func (t *ownerTable) get(clientId uint64, owner string, create bool) *stateOwner {
+	return t.owner(ownerKey{clientId: clientId, owner: owner}, create)
+}
+
+// getLock returns a lock-owner, created if create is set.
+func (t *ownerTable) getLock(clientId uint64, owner string, create bool) *stateOwner {
+	return t.owner(ownerKey{clientId: clientId, owner: owner, lock: true}, create)
+}
+
+func (t *ownerTable) owner(key ownerKey, create bool) *stateOwner {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	oo, found := t.owners[key]
+	if !found && create {
+		oo = &stateOwner{}
+		t.owners[key] = oo
+	}
+	return oo
+}
+
+// setClosed records the owner of a stateid closed.
+func (t *ownerTable) setClosed(of *openFile) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := ownerKey{clientId: of.clientId, owner: of.owner}
+	if other, found := t.lastClosed[key]; found {
+		delete(t.closed, other)
+	}
+	t.closed[of.other] = key
+	t.lastClosed[key] = of.other
+}
+
+// closedOwner returns the owner of a stateid closed.
+func (t *ownerTable) closedOwner(sid *nfs.StateId4) *stateOwner {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if key, found := t.closed[sid.Other]; found {
+		return t.owners[key]
+	}
+	return nil
+}
+
+// releaseLock drops a lock-owner.
+func (t *ownerTable) releaseLock(clientId uint64, owner string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.owners, ownerKey{clientId: clientId, owner: owner, lock: true})
+}
+
+// release drops the open-owners and lock-owners of a client.
+func (t *ownerTable) release(clientId uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.owners {
+		if key.clientId == clientId {
+			delete(t.owners, key)
+		}
+	}
+	for other, key := range t.closed {
+		if key.clientId == clientId {
+			delete(t.closed, other)
+			delete(t.lastClosed, key)
+		}
+	}
+}
+
+// seqIdKept reports whether an error leaves the seqid of an owner as is
+// (rfc7530, 9.1.7).
+func seqIdKept(status uint32) bool {
+	switch status {
+	case nfs.NFS4ERR_STALE_CLIENTID,
+		nfs.NFS4ERR_STALE_STATEID,
+		nfs.NFS4ERR_BAD_STATEID,
+		nfs.NFS4ERR_BAD_SEQID,
+		nfs.NFS4ERR_BADXDR,
+		nfs.NFS4ERR_RESOURCE,
+		nfs.NFS4ERR_NOFILEHANDLE:
+		return true
+	}
+	return false
+}
+
+// do runs an operation of the owner with a seqid. A retransmission of the
+// last operation gets its reply again without running it, and any other
+// seqid but the next one fails with NFS4ERR_BAD_SEQID.
+// With start set, an operation of an owner not confirmed yet, but a
+// retransmission, starts the owner over with any seqid: an OPEN of a new
+// open-owner (rfc7530, 16.18.5), or a LOCK of a new lock-owner.
+// The operation returns a result with a status: a *nfs.ResGenericRaw or
+// any struct encoded by xdr.
+func (oo *stateOwner) do(seqId uint32, start bool, op func() (interface{}, error)) (*nfs.ResGenericRaw, error) {
+	oo.mu.Lock()
+	defer oo.mu.Unlock()
+
+	if oo.replied && start && !oo.confirmed && seqId != oo.seqId {
+		oo.replied = false
+	}
+
+	if oo.replied {
+		switch {
+		case seqId == oo.seqId:
+			return &nfs.ResGenericRaw{
+				Status: oo.lastStat,
+				Reader: bytes.NewReader(oo.lastReply),
+			}, nil
+		case seqId != oo.seqId+1:
+			return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_BAD_SEQID}, nil
+		}
+	}
+
+	res, err := op()
+	if err != nil {
+		return nil, err
+	}
+
+	status, reply, err := encodeRes(res)
+	if err != nil {
+		return nil, err
+	}
+	if !seqIdKept(status) {
+		oo.replied = true
+		oo.seqId = seqId
+		oo.lastStat = status
+		oo.lastReply = reply
+	}
+
+	return &nfs.ResGenericRaw{
+		Status: status,
+		Reader: bytes.NewReader(reply),
+	}, nil
+}
+
+// encodeRes returns the status of a result and the rest of it encoded.
+func encodeRes(res interface{}) (uint32, []byte, error) {
+	if raw, ok := res.(*nfs.ResGenericRaw); ok {
+		if raw.Reader == nil {
+			return raw.Status, []byte{}, nil
+		}
+		dat, err := io.ReadAll(raw.Reader)
+		return raw.Status, dat, err
+	}
+
+	buff := bytes.NewBuffer([]byte{})
+	if _, err := xdr.NewWriter(buff).WriteAny(res); err != nil {
+		return 0, nil, err
+	}
+	dat := buff.Bytes()
+	return binary.BigEndian.Uint32(dat[:4]), dat[4:], nil
+}
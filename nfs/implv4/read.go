@@ -3,6 +3,7 @@ package implv4
 import (
 	"bytes"
 	"io"
+	"os"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
@@ -16,17 +17,11 @@ func read(x nfs.RPCContext, args *nfs.READ4args) (*nfs.READ4res, error) {
 
 	// log.Debugf("read data from file: '%s'", pathName)
 
-	seqId := uint32(0)
-	if args != nil && args.StateId != nil {
-		seqId = args.StateId.SeqId
+	f, done, status := ioFile(x, args.StateId, os.O_RDONLY)
+	if status != nfs.NFS4_OK {
+		return &nfs.READ4res{Status: status}, nil
 	}
-
-	of := x.Stat().GetOpenedFile(seqId)
-	if of == nil {
-		return &nfs.READ4res{Status: nfs.NFS4ERR_INVAL}, nil
-	}
-
-	f := of.File()
+	defer done()
 
 	if args.Offset >= 0 {
 		if _, err := f.Seek(int64(args.Offset), io.SeekStart); err != nil {
@@ -2,6 +2,7 @@ package implv4
 
 import (
 	"bytes"
+	"errors"
 
 	"github.com/smallfz/libnfs-go/fs"
 	"github.com/smallfz/libnfs-go/log"
@@ -33,6 +34,36 @@ func encodeReaddirResult(res *nfs.READDIR4res) *nfs.ResGenericRaw {
 	}
 }
 
+// listDir lists the entries of a directory following a cookie, at most n
+// of them if the directory is a fs.DirCursor, and tells whether they end
+// the directory. Otherwise the whole directory is read, and the entries
+// get the cookies of their names (see fs.HashDirEntries).
+func listDir(dir fs.File, cookie uint64, n int) ([]fs.DirEntry, bool, uint32) {
+	// The cookies 1 and 2 are reserved (rfc7530, 16.24.4).
+	if cookie == 1 || cookie == 2 {
+		return nil, false, nfs.NFS4ERR_BAD_COOKIE
+	}
+
+	if c, ok := dir.(fs.DirCursor); ok {
+		entries, eof, err := c.ReaddirFrom(cookie, n)
+		if err != nil {
+			if errors.Is(err, fs.ErrBadCookie) {
+				return nil, false, nfs.NFS4ERR_BAD_COOKIE
+			}
+			log.Warnf("dir.ReaddirFrom: %v", err)
+			return nil, false, nfs.NFS4ERR_NOENT
+		}
+		return entries, eof, nfs.NFS4_OK
+	}
+
+	children, err := dir.Readdir(-1)
+	if err != nil {
+		log.Warnf("dir.Readdir: %v", err)
+		return nil, false, nfs.NFS4ERR_NOENT
+	}
+	return fs.EntriesAfter(fs.HashDirEntries(children), cookie), true, nfs.NFS4_OK
+}
+
 func readDir(x nfs.RPCContext, args *nfs.READDIR4args) (*nfs.ResGenericRaw, error) {
 	stat := x.Stat()
 	vfs := x.GetFS()
@@ -57,15 +88,15 @@ func readDir(x nfs.RPCContext, args *nfs.READDIR4args) (*nfs.ResGenericRaw, erro
 		log.Warnf("vfs.Open(%s): %v", pathName, err)
 		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOENT}, nil
 	}
+	defer dir.Close()
 
-	children, err := dir.Readdir(-1)
-	if err != nil {
-		log.Warnf("dir.Readdir: %v", err)
-		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOENT}, nil
+	// The verifier of the cookies is the change attribute of the
+	// directory, read before its entries.
+	resCookieVerf := uint64(0)
+	if di, err := vfs.Stat(pathName); err == nil {
+		resCookieVerf = changeOf(di)
 	}
 
-	log.Debugf("    readdir: actual entries count = %d", len(children))
-
 	log.Debugf(
 		"    readdir: dircount=%d, maxcount=%d. cookie=%d, cookieverf=%d.",
 		args.DirCount,
@@ -74,6 +105,21 @@ func readDir(x nfs.RPCContext, args *nfs.READDIR4args) (*nfs.ResGenericRaw, erro
 		args.CookieVerf,
 	)
 
+	// A cookie is valid with the verifier it was given with. The
+	// verifier of the first call is zero.
+	if args.Cookie != 0 && args.CookieVerf != 0 && args.CookieVerf != resCookieVerf {
+		log.Debugf("    readdir: cookieverf %d, expects %d.", args.CookieVerf, resCookieVerf)
+		return &nfs.ResGenericRaw{Status: nfs.NFS4ERR_NOT_SAME}, nil
+	}
+
+	// An entry takes 16 bytes of dircount at least.
+	entries, listEof, status := listDir(dir, args.Cookie, int(args.DirCount/16)+1)
+	if status != nfs.NFS4_OK {
+		return &nfs.ResGenericRaw{Status: status}, nil
+	}
+
+	log.Debugf("    readdir: actual entries count = %d", len(entries))
+
 	// force to incease limitations giving by client.
 	// if args.DirCount < 1024 * 32 {
 	// 	args.DirCount = 1024 * 32
@@ -84,73 +130,45 @@ func readDir(x nfs.RPCContext, args *nfs.READDIR4args) (*nfs.ResGenericRaw, erro
 
 	dirList := &nfs.DirList4{HasEntries: false, Eof: true}
 
-	resCookieVerf := uint64(1000)
-
-	cookieReq := int(args.Cookie)
-	if cookieReq == 0 {
-		cookieReq += 1000
-	} else {
-		cookieReq += 1
-	}
-
-	log.Debugf("    readdir: cookie-req = %d", cookieReq)
-
 	attrSize := getAttrsMaxBytesSize(idxReq)
 
 	resDirCount := uint32(0)
 	resMaxCount := uint32(512)
 
-	eof := false
+	eof := listEof
 
-	entryCookies := []int{}
+	entryCookies := []uint64{}
 
-	if len(children) > 0 {
-		dirList.HasEntries = true
-		dirList.Entries = []*nfs.Entry4{}
+	dirList.Entries = []*nfs.Entry4{}
+	for i := 0; i < len(entries); i++ {
+		child := entries[i].Info
+		cookie := entries[i].Cookie
 
-		for i, child := range children {
-			cookie := 1000 + i
-
-			if cookie < cookieReq {
-				continue
-			}
+		entryCookies = append(entryCookies, cookie)
 
-			entryCookies = append(entryCookies, cookie)
-			resCookieVerf = uint64(cookie + 1)
-
-			pathName := fs.Join(cwd, child.Name())
-			// _, err := vfs.GetHandle(pathName)
-			// if err != nil {
-			// 	log.Warnf("vfs.GetHandle(%s): %v", pathName, err)
-			// 	continue
-			// }
-			entry := &nfs.Entry4{
-				Cookie:  uint64(cookie), // should be set. (blood and tears!)
-				Name:    child.Name(),
-				Attrs:   fileInfoToAttrs(vfs, pathName, child, idxReq),
-				HasNext: true,
-			}
-			dirList.Entries = append(dirList.Entries, entry)
-			// log.Debugf(" - entry: %s", child.Name())
-
-			if i == len(children)-1 {
-				eof = true
-			}
+		pathName := fs.Join(cwd, child.Name())
+		entry := &nfs.Entry4{
+			Cookie:  cookie, // should be set. (blood and tears!)
+			Name:    child.Name(),
+			Attrs:   fileInfoToAttrs(x, pathName, child, idxReq),
+			HasNext: true,
+		}
+		dirList.Entries = append(dirList.Entries, entry)
+		// log.Debugf(" - entry: %s", child.Name())
 
-			nameSize := uint32(xdr.Pad(len(child.Name())) + 4)
-			resDirCount += nameSize + 8
-			resMaxCount += uint32(nameSize + 8 + attrSize + 4)
+		nameSize := uint32(xdr.Pad(len(child.Name())) + 4)
+		resDirCount += nameSize + 8
+		resMaxCount += uint32(nameSize + 8 + attrSize + 4)
 
-			if resDirCount >= args.DirCount || resMaxCount > args.MaxCount {
-				break
-			}
+		if resDirCount >= args.DirCount || resMaxCount > args.MaxCount {
+			eof = listEof && i == len(entries)-1
+			break
 		}
+	}
 
-		if len(dirList.Entries) > 0 {
-			dirList.Entries[len(dirList.Entries)-1].HasNext = false
-		}
-	} else {
-		eof = true
+	if len(dirList.Entries) > 0 {
+		dirList.HasEntries = true
+		dirList.Entries[len(dirList.Entries)-1].HasNext = false
 	}
 
 	if len(entryCookies) > 0 {
@@ -0,0 +1,67 @@
+package implv4
+
+import (
+	"os"
+	"testing"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+func TestListDirCookies(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		f, err := mfs.OpenFile("/"+name, os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		f.Close()
+	}
+
+	// list lists the root without its fs.DirCursor.
+	list := func(cookie uint64) ([]fs.DirEntry, uint32) {
+		f, err := mfs.Open("/")
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		defer f.Close()
+		entries, _, status := listDir(struct{ fs.File }{f}, cookie, 1)
+		return entries, status
+	}
+
+	entries, status := list(0)
+	if status != nfs.NFS4_OK || len(entries) != 5 {
+		t.Fatalf("listDir: %d entries, %d", len(entries), status)
+	}
+	for i, e := range entries {
+		if e.Cookie < 3 || (i > 0 && e.Cookie <= entries[i-1].Cookie) {
+			t.Fatalf("listDir: cookie %d of %s", e.Cookie, e.Info.Name())
+		}
+	}
+
+	// The entries following a cookie don't move once another entry is
+	// removed.
+	if err := mfs.Remove("/" + entries[0].Info.Name()); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	rest, status := list(entries[1].Cookie)
+	if status != nfs.NFS4_OK || len(rest) != 3 {
+		t.Fatalf("listDir(%d): %d entries, %d", entries[1].Cookie, len(rest), status)
+	}
+	for i, e := range rest {
+		if e.Cookie != entries[i+2].Cookie || e.Info.Name() != entries[i+2].Info.Name() {
+			t.Fatalf("listDir(%d): %s moved", entries[1].Cookie, e.Info.Name())
+		}
+	}
+
+	// The listing resumes after the cookie of an entry removed.
+	rest, status = list(entries[0].Cookie)
+	if status != nfs.NFS4_OK || len(rest) != 4 || rest[0].Info.Name() != entries[1].Info.Name() {
+		t.Fatalf("listDir(%d) of an entry removed: %d entries, %d", entries[0].Cookie, len(rest), status)
+	}
+
+	if _, status := list(2); status != nfs.NFS4ERR_BAD_COOKIE {
+		t.Fatalf("listDir of a reserved cookie: %d", status)
+	}
+}
@@ -0,0 +1,30 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// releaseLockOwner drops the state of a lock-owner holding no lock
+// (rfc7530, 16.37).
+func releaseLockOwner(x nfs.RPCContext, args *nfs.RELEASE_LOCKOWNER4args) (*nfs.RELEASE_LOCKOWNER4res, error) {
+	st := stateOf(x)
+
+	clientId := args.LockOwner.ClientId
+	if status := st.clients.renew(clientId); status != nfs.NFS4_OK {
+		log.Warnf("release_lockowner: client %x: %d", clientId, status)
+		return &nfs.RELEASE_LOCKOWNER4res{Status: status}, nil
+	}
+
+	owner := lockOwner(clientId, args.LockOwner.Owner)
+	for _, l := range st.locks.List() {
+		if l.Owner == owner {
+			return &nfs.RELEASE_LOCKOWNER4res{Status: nfs.NFS4ERR_LOCKS_HELD}, nil
+		}
+	}
+
+	st.opens.releaseLockOwner(clientId, args.LockOwner.Owner)
+	st.owners.releaseLock(clientId, args.LockOwner.Owner)
+
+	return &nfs.RELEASE_LOCKOWNER4res{Status: nfs.NFS4_OK}, nil
+}
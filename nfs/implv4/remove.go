@@ -20,6 +20,9 @@ func remove(x nfs.RPCContext, args *nfs.REMOVE4args) (*nfs.REMOVE4res, error) {
 		return &nfs.REMOVE4res{Status: nfs.NFS4ERR_PERM}, nil
 	}
 
+	ch := stateOf(x).changeDirs(vfs, folder)
+	defer ch.done()
+
 	pathName := path.Join(folder, args.Target)
 
 	fi, err := vfs.Stat(pathName)
@@ -33,6 +36,14 @@ func remove(x nfs.RPCContext, args *nfs.REMOVE4args) (*nfs.REMOVE4res, error) {
 		return &nfs.REMOVE4res{Status: nfs.NFS3ERR_NOTEMPTY}, nil
 	}
 
+	// The delegations of the file to other clients are recalled first.
+	if target, err := vfs.GetHandle(fi); err == nil {
+		clientId, _ := stat.ClientId()
+		if status := stateOf(x).recall(target, clientId, true); status != nfs.NFS4_OK {
+			return &nfs.REMOVE4res{Status: status}, nil
+		}
+	}
+
 	if err := vfs.Remove(pathName); err != nil {
 		log.Warnf("remove: vfs.Remove(%s): %v", pathName, err)
 		return &nfs.REMOVE4res{Status: nfs.NFS4ERR_PERM}, nil
@@ -41,11 +52,7 @@ func remove(x nfs.RPCContext, args *nfs.REMOVE4args) (*nfs.REMOVE4res, error) {
 	res := &nfs.REMOVE4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.REMOVE4resok{
-			CInfo: &nfs.ChangeInfo4{
-				Atomic: true,
-				Before: 0,
-				After:  0,
-			},
+			CInfo: ch.info(folder),
 		},
 	}
 	return res, nil
@@ -27,8 +27,11 @@ func rename(x nfs.RPCContext, args *nfs.RENAME4args) (*nfs.RENAME4res, error) {
 		return &nfs.RENAME4res{Status: nfs.NFS4err(err)}, nil
 	}
 
+	ch := stateOf(x).changeDirs(vfs, folder)
+	defer ch.done()
+
 	oldpath := path.Join(folder, args.OldName)
-	_, err = vfs.Stat(oldpath)
+	oi, err := vfs.Stat(oldpath)
 	if err != nil {
 		log.Warnf("  rename: vfs.Stat(%s): %v", oldpath, err)
 		return &nfs.RENAME4res{Status: nfs.NFS4err(err)}, nil
@@ -49,6 +52,24 @@ func rename(x nfs.RPCContext, args *nfs.RENAME4args) (*nfs.RENAME4res, error) {
 		return &nfs.RENAME4res{Status: nfs.NFS4err(err)}, nil
 	}
 
+	// The delegations of the file, and of the symlink it replaces, to
+	// other clients are recalled first.
+	clientId, _ := stat.ClientId()
+	targets := [][]byte{}
+	if fh, err := vfs.GetHandle(oi); err == nil {
+		targets = append(targets, fh)
+	}
+	if err == nil {
+		if fh, err := vfs.GetHandle(fi); err == nil {
+			targets = append(targets, fh)
+		}
+	}
+	for _, target := range targets {
+		if status := stateOf(x).recall(target, clientId, true); status != nfs.NFS4_OK {
+			return &nfs.RENAME4res{Status: status}, nil
+		}
+	}
+
 	//
 	// Perform Rename.
 	//
@@ -60,16 +81,8 @@ func rename(x nfs.RPCContext, args *nfs.RENAME4args) (*nfs.RENAME4res, error) {
 	res := &nfs.RENAME4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.RENAME4resok{
-			SourceCInfo: &nfs.ChangeInfo4{
-				Atomic: true,
-				Before: 0,
-				After:  0,
-			},
-			TargetCInfo: &nfs.ChangeInfo4{
-				Atomic: true,
-				Before: 0,
-				After:  0,
-			},
+			SourceCInfo: ch.info(folder),
+			TargetCInfo: ch.info(folder),
 		},
 	}
 	return res, nil
@@ -0,0 +1,17 @@
+package implv4
+
+import (
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+func renew(x nfs.RPCContext, args *nfs.RENEW4args) (*nfs.RENEW4res, error) {
+	status := stateOf(x).clients.renew(args.ClientId)
+	if status != nfs.NFS4_OK {
+		log.Warnf("renew(%x): %d", args.ClientId, status)
+	} else {
+		x.Stat().SetClientId(args.ClientId)
+	}
+
+	return &nfs.RENEW4res{Status: status}, nil
+}
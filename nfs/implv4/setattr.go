@@ -39,15 +39,27 @@ func setAttr(x nfs.RPCContext, args *nfs.SETATTR4args) (*nfs.SETATTR4res, error)
 		return resFailPerm, nil
 	}
 
-	seqId := uint32(0)
-	if args.StateId != nil {
-		seqId = args.StateId.SeqId
-	}
-
 	f := (fs.File)(nil)
 	// pathName := cwd
 
-	of := x.Stat().GetOpenedFile(seqId)
+	// A special stateid sets the attributes without an open.
+	of := (*openFile)(nil)
+	if !isSpecialStateId(args.StateId) {
+		o, status := getOpen(x, args.StateId)
+		if status != nfs.NFS4_OK {
+			return &nfs.SETATTR4res{Status: status}, nil
+		}
+		of = o
+	}
+
+	// The delegations of the file to other clients are recalled first.
+	clientId, _ := x.Stat().ClientId()
+	if of != nil {
+		clientId = of.clientId
+	}
+	if status := stateOf(x).recall(fh, clientId, true); status != nfs.NFS4_OK {
+		return &nfs.SETATTR4res{Status: status}, nil
+	}
 
 	if of != nil {
 		f = of.File()
@@ -112,7 +124,7 @@ func setAttr(x nfs.RPCContext, args *nfs.SETATTR4args) (*nfs.SETATTR4res, error)
 		return resFailPerm, nil
 	}
 
-	attrs := fileInfoToAttrs(vfs, pathName, fi, idxReq)
+	attrs := fileInfoToAttrs(x, pathName, fi, idxReq)
 	attrSet := attrs.Mask
 
 	return &nfs.SETATTR4res{
@@ -1,15 +1,28 @@
 package implv4
 
 import (
+	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
 )
 
-func setClientId(args *nfs.SETCLIENTID4args) (*nfs.SETCLIENTID4res, error) {
+func setClientId(x nfs.RPCContext, cred *nfs.Auth, args *nfs.SETCLIENTID4args) (*nfs.SETCLIENTID4res, error) {
+	if args.Client == nil {
+		return &nfs.SETCLIENTID4res{Status: nfs.NFS4ERR_INVAL}, nil
+	}
+
+	rec, inUse, status := stateOf(x).clients.setClientId(principalOf(cred), args)
+	if status != nfs.NFS4_OK {
+		log.Warnf("setclientid(%q): %d", args.Client.Id, status)
+		return &nfs.SETCLIENTID4res{Status: status, ErrInUse: inUse}, nil
+	}
+
+	log.Debugf("setclientid(%q): clientid=%x", args.Client.Id, rec.clientId)
+
 	rs := &nfs.SETCLIENTID4res{
 		Status: nfs.NFS4_OK,
 		Ok: &nfs.SETCLIENTID4resok{
-			ClientId:           uint64(1),
-			SetClientIdConfirm: args.Client.Verifier,
+			ClientId:           rec.clientId,
+			SetClientIdConfirm: rec.confirm,
 		},
 	}
 	return rs, nil
@@ -1,12 +1,20 @@
 package implv4
 
 import (
+	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
 )
 
-func setClientIdConfirm(args *nfs.SETCLIENTID_CONFIRM4args) (*nfs.SETCLIENTID_CONFIRM4res, error) {
+func setClientIdConfirm(x nfs.RPCContext, cred *nfs.Auth, args *nfs.SETCLIENTID_CONFIRM4args) (*nfs.SETCLIENTID_CONFIRM4res, error) {
+	status := stateOf(x).confirm(principalOf(cred), args.ClientId, args.Verifier)
+	if status != nfs.NFS4_OK {
+		log.Warnf("setclientid_confirm(%x): %d", args.ClientId, status)
+	} else {
+		x.Stat().SetClientId(args.ClientId)
+	}
+
 	rs := &nfs.SETCLIENTID_CONFIRM4res{
-		Status: nfs.NFS4_OK,
+		Status: status,
 	}
 	return rs, nil
 }
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/smallfz/libnfs-go/nfs"
 	"github.com/smallfz/libnfs-go/xdr"
@@ -77,3 +78,112 @@ func TestParsingSETCLIENTID4_res(t *testing.T) {
 
 	fmt.Println(toJson(res))
 }
+
+func TestClientTable(t *testing.T) {
+	tbl := newClientTable()
+
+	args := func(id string, verifier uint64) *nfs.SETCLIENTID4args {
+		return &nfs.SETCLIENTID4args{
+			Client: &nfs.NfsClientId4{Verifier: verifier, Id: []byte(id)},
+			Callback: &nfs.CbClient4{
+				CbProgram:  0x40000000,
+				CbLocation: &nfs.ClientAddr4{NetId: "tcp", Addr: "127.0.0.1.3.232"},
+			},
+		}
+	}
+	setClientId := func(principal string, a *nfs.SETCLIENTID4args) *client {
+		rec, _, status := tbl.setClientId(principal, a)
+		if status != nfs.NFS4_OK {
+			t.Fatalf("setClientId(%s): %d", a.Client.Id, status)
+		}
+		return rec
+	}
+	confirm := func(principal string, rec *client, expected uint32) {
+		if _, status := tbl.confirm(principal, rec.clientId, rec.confirm); status != expected {
+			t.Fatalf("confirm(%x): expects %d but get %d", rec.clientId, expected, status)
+		}
+	}
+
+	// A new client.
+	a := setClientId("u", args("a", 1))
+	if _, status := tbl.confirm("u", a.clientId, a.confirm+1); status != nfs.NFS4ERR_STALE_CLIENTID {
+		t.Fatalf("confirm with a wrong verifier: %d", status)
+	}
+	confirm("x", a, nfs.NFS4ERR_CLID_INUSE)
+	confirm("u", a, nfs.NFS4_OK)
+	confirm("u", a, nfs.NFS4_OK) // retransmission.
+
+	// Another principal can't take the id over.
+	if _, inUse, status := tbl.setClientId("x", args("a", 1)); status != nfs.NFS4ERR_CLID_INUSE {
+		t.Fatalf("setClientId with another principal: %d", status)
+	} else if inUse == nil || inUse.Addr != "127.0.0.1.3.232" {
+		t.Fatalf("unexpected addr in use: %v", inUse)
+	}
+
+	// Callback update: the client id is kept.
+	a2 := setClientId("u", args("a", 1))
+	if a2.clientId != a.clientId || a2.confirm == a.confirm {
+		t.Fatalf("callback update: %x/%x", a2.clientId, a.clientId)
+	}
+	confirm("u", a2, nfs.NFS4_OK)
+
+	// An unconfirmed record is replaced.
+	b := setClientId("u", args("b", 1))
+	b2 := setClientId("u", args("b", 1))
+	if b2.clientId == b.clientId {
+		t.Fatalf("unconfirmed record not replaced")
+	}
+	confirm("u", b, nfs.NFS4ERR_STALE_CLIENTID)
+	confirm("u", b2, nfs.NFS4_OK)
+
+	// Client reboot: a new client id replaces the previous one once
+	// confirmed.
+	a3 := setClientId("u", args("a", 2))
+	if a3.clientId == a.clientId {
+		t.Fatalf("reboot: the client id is kept")
+	}
+	confirm("u", a2, nfs.NFS4_OK)
+	if replaced, status := tbl.confirm("u", a3.clientId, a3.confirm); status != nfs.NFS4_OK || replaced != a.clientId {
+		t.Fatalf("reboot: replaced %x, status %d", replaced, status)
+	}
+	confirm("u", a2, nfs.NFS4ERR_STALE_CLIENTID)
+}
+
+func TestClientTableLeases(t *testing.T) {
+	tbl := newClientTable()
+	tbl.leaseTime = time.Minute
+
+	args := &nfs.SETCLIENTID4args{
+		Client:   &nfs.NfsClientId4{Verifier: 1, Id: []byte("a")},
+		Callback: &nfs.CbClient4{CbLocation: &nfs.ClientAddr4{}},
+	}
+	rec, _, _ := tbl.setClientId("u", args)
+	if status := tbl.renew(rec.clientId); status != nfs.NFS4ERR_STALE_CLIENTID {
+		t.Fatalf("renew of an unconfirmed client: %d", status)
+	}
+	tbl.confirm("u", rec.clientId, rec.confirm)
+	if status := tbl.renew(rec.clientId); status != nfs.NFS4_OK {
+		t.Fatalf("renew: %d", status)
+	}
+
+	if expired := tbl.reap(time.Now()); len(expired) > 0 {
+		t.Fatalf("unexpected expired clients: %v", expired)
+	}
+	expired := tbl.reap(time.Now().Add(time.Minute * 2))
+	if len(expired) != 1 || expired[0] != rec.clientId {
+		t.Fatalf("expects client %x expired but get %v", rec.clientId, expired)
+	}
+	if status := tbl.renew(rec.clientId); status != nfs.NFS4ERR_EXPIRED {
+		t.Fatalf("renew of an expired client: %d", status)
+	}
+
+	// The id is free for another principal.
+	if _, _, status := tbl.setClientId("x", args); status != nfs.NFS4_OK {
+		t.Fatalf("setClientId after expiry: %d", status)
+	}
+
+	tbl.reap(time.Now().Add(time.Minute * (2 + expiredLeases + 1)))
+	if status := tbl.renew(rec.clientId); status != nfs.NFS4ERR_STALE_CLIENTID {
+		t.Fatalf("renew of a client expired long ago: %d", status)
+	}
+}
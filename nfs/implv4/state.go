@@ -0,0 +1,296 @@
+package implv4
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// DefaultLeaseTime is the lease time of a State unless set with SetLeaseTime.
+const DefaultLeaseTime = time.Second * 90
+
+// expiredLeases is how many lease times a client id expired answers
+// NFS4ERR_EXPIRED.
+const expiredLeases = 10
+
+// State is the NFSv4 state of a server. It is shared by all the
+// connections, so that a client reconnecting keeps its state.
+type State struct {
+	clients *clientTable
+	owners  *ownerTable
+	opens   *openTable
+	locks   *locks.Table
+	caller  Caller
+	grace   *grace
+	dirs    *locks.PathLocks // serializes the changes of the directories, for atomic change_info4.
+}
+
+// NewState returns an empty State.
+func NewState() *State {
+	clients := newClientTable()
+	return &State{
+		clients: clients,
+		owners:  newOwnerTable(),
+		opens:   newOpenTable(clients.boot),
+		locks:   locks.NewTable(),
+		grace:   newGrace(),
+		dirs:    locks.NewPathLocks(),
+	}
+}
+
+// SetLockTable sets the table of the byte-range locks, e.g. to share it
+// with the Network Lock Manager of NFSv3. The delegations are recalled
+// through it before the accesses by NFSv3 and NLM.
+func (st *State) SetLockTable(t *locks.Table) {
+	st.locks = t
+	t.SetRecall(func(file string, write bool) bool {
+		// No NFSv4 client has the id 0.
+		return st.recall([]byte(file), 0, write) == nfs.NFS4_OK
+	})
+}
+
+// SetLeaseTime sets the time a client keeps its state without renewing it.
+func (st *State) SetLeaseTime(d time.Duration) {
+	st.clients.mu.Lock()
+	defer st.clients.mu.Unlock()
+
+	st.clients.leaseTime = d
+}
+
+// LeaseTime returns the lease time, as advertised by the lease_time attribute.
+func (st *State) LeaseTime() time.Duration {
+	st.clients.mu.Lock()
+	defer st.clients.mu.Unlock()
+
+	return st.clients.leaseTime
+}
+
+// Reap releases the state of the clients whose lease expired, until ctx
+// is done.
+func (st *State) Reap(ctx context.Context) {
+	for {
+		timer := time.NewTimer(st.LeaseTime() / 4)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			for _, clientId := range st.clients.reap(now) {
+				log.Infof("lease of client %x expired.", clientId)
+				st.release(clientId)
+			}
+			st.opens.forgetReleased(st.clients.isExpired)
+			// The delegations not returned within a lease once
+			// recalled are revoked.
+			for _, d := range st.opens.revoke(now.Add(-st.LeaseTime())) {
+				log.Infof("delegation of %s to %x revoked.", d.path, d.clientId)
+			}
+			st.endGrace(now)
+		}
+	}
+}
+
+// confirm confirms a client (see clientTable.confirm).
+func (st *State) confirm(principal string, clientId, confirm uint64) uint32 {
+	replaced, status := st.clients.confirm(principal, clientId, confirm)
+	if replaced > 0 {
+		st.release(replaced)
+	}
+	if status == nfs.NFS4_OK {
+		st.record(clientId)
+		go st.probe(clientId)
+	}
+	return status
+}
+
+// release releases the state of a client: the files it opened are closed,
+// its locks released and its delegations taken back. It loses its state
+// for good: the ClientStore forgets it.
+func (st *State) release(clientId uint64) {
+	st.closeOpens(st.opens.release(clientId))
+	st.opens.releaseDelegs(clientId)
+	st.forget(clientId)
+	st.owners.release(clientId)
+}
+
+// unlock releases the locks held on the files of opens, along with their
+// lock states.
+func (st *State) unlock(opens ...*openFile) {
+	for _, ls := range st.opens.removeLocks(opens...) {
+		owner := lockOwner(ls.clientId, ls.owner)
+		file := string(ls.open.fh)
+		st.locks.Release(func(l locks.Lock) bool {
+			return l.Owner == owner && l.File == file
+		})
+	}
+}
+
+func (st *State) closeOpens(opens []*openFile) {
+	st.unlock(opens...)
+	for _, of := range opens {
+		log.Debugf(" - %s released.", of.path)
+		if err := of.f.Close(); err != nil {
+			log.Warnf("f.Close: %v", err)
+		}
+	}
+}
+
+// findOpen returns the file opened with a stateid, on the current
+// filehandle. Using it renews the lease of its client implicitly
+// (rfc7530, 9.5).
+// The file is returned along with an error as long as the stateid is
+// known, so that its owner can answer a retransmission.
+func findOpen(x nfs.RPCContext, sid *nfs.StateId4) (*openFile, uint32) {
+	st := stateOf(x)
+	of, status := st.opens.get(sid, x.Stat().CurrentHandle())
+	if of == nil {
+		// The opens of a client whose lease expired are gone.
+		if status == nfs.NFS4ERR_BAD_STATEID && sid != nil && !isSpecialStateId(sid) {
+			if clientId, found := st.opens.clientOf(sid); found && st.clients.isExpired(clientId) {
+				return nil, nfs.NFS4ERR_EXPIRED
+			}
+		}
+		return nil, status
+	}
+	if status := st.clients.renew(of.clientId); status == nfs.NFS4ERR_EXPIRED {
+		return nil, status
+	}
+	return of, status
+}
+
+// findLock is like findOpen, for the lock state of a lock stateid.
+func findLock(x nfs.RPCContext, sid *nfs.StateId4) (*lockState, uint32) {
+	st := stateOf(x)
+	ls, status := st.opens.getLock(sid, x.Stat().CurrentHandle())
+	if ls == nil {
+		if status == nfs.NFS4ERR_BAD_STATEID && sid != nil && !isSpecialStateId(sid) {
+			if clientId, found := st.opens.clientOf(sid); found && st.clients.isExpired(clientId) {
+				return nil, nfs.NFS4ERR_EXPIRED
+			}
+		}
+		return nil, status
+	}
+	if status := st.clients.renew(ls.clientId); status == nfs.NFS4ERR_EXPIRED {
+		return nil, status
+	}
+	return ls, status
+}
+
+// getOpen is like findOpen, for an open confirmed. No file is returned
+// along with an error.
+func getOpen(x nfs.RPCContext, sid *nfs.StateId4) (*openFile, uint32) {
+	of, status := findOpen(x, sid)
+	if status == nfs.NFS4_OK && !of.confirmed {
+		status = nfs.NFS4ERR_BAD_STATEID
+	}
+	if status != nfs.NFS4_OK {
+		return nil, status
+	}
+	return of, nfs.NFS4_OK
+}
+
+// ioFile returns the file to read or write with a stateid. A special
+// stateid reads or writes the file of the current filehandle without an
+// open: the file is opened for the call, and the function returned
+// closes it. It fails with NFS4ERR_LOCKED if an open denies the access.
+// A lock stateid reads or writes the file of its open, and a delegation
+// stateid the file delegated. An open for reading only can't write
+// (NFS4ERR_OPENMODE). The delegations of the file to other clients
+// conflicting with the access are recalled (NFS4ERR_DELAY).
+func ioFile(x nfs.RPCContext, sid *nfs.StateId4, flag int) (fs.File, func(), uint32) {
+	st := stateOf(x)
+	fh := x.Stat().CurrentHandle()
+
+	access := nfs.OPEN4_SHARE_ACCESS_READ
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		access = nfs.OPEN4_SHARE_ACCESS_WRITE
+	}
+	write := access == nfs.OPEN4_SHARE_ACCESS_WRITE
+
+	if !isSpecialStateId(sid) {
+		of, status := getOpen(x, sid)
+		if of == nil && status == nfs.NFS4ERR_BAD_STATEID {
+			if ls, lstatus := findLock(x, sid); ls != nil {
+				of, status = ls.open, lstatus
+			}
+		}
+		if of == nil && status == nfs.NFS4ERR_BAD_STATEID {
+			if d, dstatus := st.opens.getDeleg(sid, fh); d != nil {
+				st.clients.renew(d.clientId)
+				return openCurrent(x, flag)
+			} else if dstatus != nfs.NFS4ERR_BAD_STATEID {
+				status = dstatus
+			}
+		}
+		if status != nfs.NFS4_OK {
+			return nil, nil, status
+		}
+		if write && !st.opens.allows(of, access) {
+			return nil, nil, nfs.NFS4ERR_OPENMODE
+		}
+		if status := st.recall(fh, of.clientId, write); status != nfs.NFS4_OK {
+			return nil, nil, status
+		}
+		return of.File(), func() {}, nfs.NFS4_OK
+	}
+
+	if st.opens.denies(fh, access) {
+		return nil, nil, nfs.NFS4ERR_LOCKED
+	}
+	// Writing without a stateid could conflict with the locks being
+	// reclaimed.
+	if write && st.InGrace() {
+		return nil, nil, nfs.NFS4ERR_GRACE
+	}
+	clientId, _ := x.Stat().ClientId()
+	if status := st.recall(fh, clientId, write); status != nfs.NFS4_OK {
+		return nil, nil, status
+	}
+	return openCurrent(x, flag)
+}
+
+// openCurrent opens the file of the current filehandle for a call. The
+// function returned closes it.
+func openCurrent(x nfs.RPCContext, flag int) (fs.File, func(), uint32) {
+	vfs := x.GetFS()
+	pathName, err := vfs.ResolveHandle(x.Stat().CurrentHandle())
+	if err != nil {
+		log.Warnf("vfs.ResolveHandle: %v", err)
+		return nil, nil, nfs.NFS4ERR_STALE
+	}
+	if fi, err := vfs.Stat(pathName); err != nil {
+		log.Warnf("vfs.Stat(%s): %v", pathName, err)
+		return nil, nil, nfs.NFS4ERR_STALE
+	} else if fi.IsDir() {
+		return nil, nil, nfs.NFS4ERR_ISDIR
+	}
+	f, err := vfs.OpenFile(pathName, flag, os.FileMode(0))
+	if err != nil {
+		log.Warnf("vfs.OpenFile(%s): %v", pathName, err)
+		return nil, nil, nfs.NFS4ERR_ACCESS
+	}
+	return f, func() { f.Close() }, nfs.NFS4_OK
+}
+
+// StateHolder is implemented by a nfs.RPCContext giving access to the
+// State of the server.
+type StateHolder interface {
+	State() *State
+}
+
+// defaultState is used by contexts which don't hold a State.
+var defaultState = NewState()
+
+func stateOf(x nfs.RPCContext) *State {
+	if h, ok := x.(StateHolder); ok {
+		if st := h.State(); st != nil {
+			return st
+		}
+	}
+	return defaultState
+}
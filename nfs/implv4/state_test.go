@@ -0,0 +1,89 @@
+package implv4
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+func TestStateReap(t *testing.T) {
+	st := NewState()
+	st.SetLeaseTime(time.Millisecond * 20)
+
+	args := &nfs.SETCLIENTID4args{
+		Client:   &nfs.NfsClientId4{Verifier: 1, Id: []byte("a")},
+		Callback: &nfs.CbClient4{CbLocation: &nfs.ClientAddr4{}},
+	}
+	rec, _, _ := st.clients.setClientId("u", args)
+	if status := st.confirm("u", rec.clientId, rec.confirm); status != nfs.NFS4_OK {
+		t.Fatalf("confirm: %d", status)
+	}
+
+	mfs := memfs.NewMemFS()
+	f, err := mfs.OpenFile("/a", os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	sid, _ := st.opens.add(&nfs.OpenOwner4{ClientId: rec.clientId, Owner: "o"}, []byte("a"), "/a", f,
+		nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_NONE, true)
+
+	of, _ := st.opens.get(sid, nil)
+	ls := st.opens.addLock(of, &nfs.LockOwner4{ClientId: rec.clientId, Owner: "l"})
+	if _, ok := st.locks.Lock(locks.Lock{File: "a", Owner: lockOwner(ls.clientId, ls.owner), Exclusive: true}); !ok {
+		t.Fatalf("lock not granted")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go st.Reap(ctx)
+
+	deadline := time.Now().Add(time.Second)
+	for _, status := st.opens.get(sid, nil); status == nfs.NFS4_OK; _, status = st.opens.get(sid, nil) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the file opened by an expired client is not released")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	for len(st.locks.List()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the locks of an expired client are not released")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	if status := st.clients.renew(rec.clientId); status != nfs.NFS4ERR_EXPIRED {
+		t.Fatalf("renew of an expired client: %d", status)
+	}
+	if clientId, found := st.opens.clientOf(sid); !found || clientId != rec.clientId {
+		t.Fatalf("stateid of an expired client: %x, %v", clientId, found)
+	}
+}
+
+func TestOwnerTableClosed(t *testing.T) {
+	owners := newOwnerTable()
+	oo := owners.get(1, "o", true)
+
+	// Only the last stateid closed by an owner is kept.
+	for i := uint32(1); i <= 3; i++ {
+		owners.setClosed(&openFile{clientId: 1, owner: "o", other: [3]uint32{0, i, 0}})
+	}
+	if len(owners.closed) != 1 {
+		t.Fatalf("stateids closed kept: %d", len(owners.closed))
+	}
+	if owners.closedOwner(&nfs.StateId4{Other: [3]uint32{0, 1, 0}}) != nil {
+		t.Fatalf("owner of a stateid closed before the last one")
+	}
+	if owners.closedOwner(&nfs.StateId4{Other: [3]uint32{0, 3, 0}}) != oo {
+		t.Fatalf("no owner of the last stateid closed")
+	}
+
+	owners.release(1)
+	if len(owners.closed) != 0 || len(owners.lastClosed) != 0 {
+		t.Fatalf("stateids closed kept after the release of the client")
+	}
+}
@@ -3,6 +3,7 @@ package implv4
 import (
 	"bytes"
 	"io"
+	"os"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
@@ -17,17 +18,11 @@ func write(x nfs.RPCContext, args *nfs.WRITE4args) (*nfs.WRITE4res, error) {
 	// log.Debugf("write data to file: '%s'", pathName)
 	// log.Printf(toJson(args))
 
-	seqId := uint32(0)
-	if args != nil && args.StateId != nil {
-		seqId = args.StateId.SeqId
+	f, done, status := ioFile(x, args.StateId, os.O_WRONLY)
+	if status != nfs.NFS4_OK {
+		return &nfs.WRITE4res{Status: status}, nil
 	}
-
-	of := x.Stat().GetOpenedFile(seqId)
-	if of == nil {
-		return &nfs.WRITE4res{Status: nfs.NFS4ERR_INVAL}, nil
-	}
-
-	f := of.File()
+	defer done()
 
 	if args.Offset >= 0 {
 		// log.Printf("  seek %d", args.Offset)
@@ -65,6 +60,7 @@ func write(x nfs.RPCContext, args *nfs.WRITE4args) (*nfs.WRITE4res, error) {
 		if fsync {
 			if err := f.Sync(); err != nil {
 				log.Warnf("f.Sync(%s): %v", f.Name(), err)
+				nfs.ResetWriteVerifier()
 			} else {
 				resultCommitted = args.Stable
 			}
@@ -76,7 +72,7 @@ func write(x nfs.RPCContext, args *nfs.WRITE4args) (*nfs.WRITE4res, error) {
 		Ok: &nfs.WRITE4resok{
 			Count:     sizeWrote,
 			Committed: resultCommitted,
-			WriteVerf: 0,
+			WriteVerf: nfs.WriteVerifier(),
 		},
 	}
 	return res, nil
@@ -0,0 +1,66 @@
+package nfs
+
+import (
+	"fmt"
+)
+
+// Mount protocol, version 3.
+// https://datatracker.ietf.org/doc/html/rfc1813#appendix-I
+
+const (
+	MNTPATHLEN = 1024 /* Maximum bytes in a path name */
+	MNTNAMLEN  = 255  /* Maximum bytes in a name */
+	FHSIZE3    = 64   /* Maximum bytes in a V3 file handle */
+)
+
+/* mountstat3 */
+const (
+	MNT3_OK             = uint32(0)     /* no error */
+	MNT3ERR_PERM        = uint32(1)     /* Not owner */
+	MNT3ERR_NOENT       = uint32(2)     /* No such file or directory */
+	MNT3ERR_IO          = uint32(5)     /* I/O error */
+	MNT3ERR_ACCES       = uint32(13)    /* Permission denied */
+	MNT3ERR_NOTDIR      = uint32(20)    /* Not a directory */
+	MNT3ERR_INVAL       = uint32(22)    /* Invalid argument */
+	MNT3ERR_NAMETOOLONG = uint32(63)    /* Filename too long */
+	MNT3ERR_NOTSUPP     = uint32(10004) /* Operation not supported */
+	MNT3ERR_SERVERFAULT = uint32(10006) /* A failure on the server */
+)
+
+const (
+	MOUNTPROC3_NULL    = uint32(0)
+	MOUNTPROC3_MNT     = uint32(1)
+	MOUNTPROC3_DUMP    = uint32(2)
+	MOUNTPROC3_UMNT    = uint32(3)
+	MOUNTPROC3_UMNTALL = uint32(4)
+	MOUNTPROC3_EXPORT  = uint32(5)
+)
+
+func MountProcName(proc uint32) string {
+	switch proc {
+	case MOUNTPROC3_NULL:
+		return "null"
+	case MOUNTPROC3_MNT:
+		return "mnt"
+	case MOUNTPROC3_DUMP:
+		return "dump"
+	case MOUNTPROC3_UMNT:
+		return "umnt"
+	case MOUNTPROC3_UMNTALL:
+		return "umntall"
+	case MOUNTPROC3_EXPORT:
+		return "export"
+	}
+	return fmt.Sprintf("%d", proc)
+}
+
+type MountRes3ok struct {
+	Fh          []byte   // type: fhandle3
+	AuthFlavors []uint32 // AUTH_FLAVOR_*
+}
+
+/* struct mountbody, without the link to the next one. */
+type MountBody struct {
+	Hostname  string
+	Directory string
+}
@@ -1,7 +1,11 @@
 package nfs
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
+	"os"
+	"syscall"
 	"time"
 )
 
@@ -137,7 +141,8 @@ type NFSTime struct {
 
 func MakeNfsTime(t time.Time) NFSTime {
 	return NFSTime{
-		Seconds: uint32(t.Unix()),
+		Seconds:     uint32(t.Unix()),
+		NanoSeconds: uint32(t.Nanosecond()),
 	}
 }
 
@@ -273,3 +278,255 @@ type READDIRPLUS3resok struct {
 	CookieVerf uint64
 	Reply      *DirListPlus3
 }
+
+////////////////////// wcc //////////////////////
+
+type WccAttr struct {
+	Size  uint64
+	MTime NFSTime
+	CTime NFSTime
+}
+
+type PreOpAttr struct {
+	AttributesFollow bool
+	Attributes       *WccAttr
+}
+
+type WccData struct {
+	Before *PreOpAttr
+	After  *PostOpAttr
+}
+
+////////////////////// read //////////////////////
+
+type READ3args struct {
+	File   []byte // type: nfs_fh3
+	Offset uint64 // type: offset3
+	Count  uint32 // type: count3
+}
+
+type READ3resok struct {
+	FileAttrs *PostOpAttr
+	Count     uint32
+	EOF       bool
+	Data      []byte
+}
+
+////////////////////// write //////////////////////
+
+/* stable_how */
+const (
+	UNSTABLE  = uint32(0)
+	DATA_SYNC = uint32(1)
+	FILE_SYNC = uint32(2)
+)
+
+type WRITE3args struct {
+	File   []byte // type: nfs_fh3
+	Offset uint64 // type: offset3
+	Count  uint32 // type: count3
+	Stable uint32 // UNSTABLE | DATA_SYNC | FILE_SYNC
+	Data   []byte
+}
+
+type WRITE3resok struct {
+	FileWcc   *WccData
+	Count     uint32
+	Committed uint32 // UNSTABLE | DATA_SYNC | FILE_SYNC
+	Verf      uint64 // type: writeverf3
+}
+
+////////////////////// commit //////////////////////
+
+type COMMIT3args struct {
+	File   []byte // type: nfs_fh3
+	Offset uint64 // type: offset3
+	Count  uint32 // type: count3
+}
+
+type COMMIT3resok struct {
+	FileWcc *WccData
+	Verf    uint64 // type: writeverf3
+}
+
+func NFS3err(err error) uint32 {
+	switch err {
+	case nil:
+		return NFS3_OK
+	case fs.ErrPermission:
+		return NFS3ERR_ACCES
+	case fs.ErrNotExist:
+		return NFS3ERR_NOENT
+	case fs.ErrExist:
+		return NFS3ERR_EXIST
+	}
+
+	// Handle syscall errors
+
+	if os.IsNotExist(err) {
+		return NFS3ERR_NOENT
+	}
+
+	if os.IsExist(err) {
+		return NFS3ERR_EXIST
+	}
+
+	if os.IsPermission(err) {
+		return NFS3ERR_ACCES
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		switch errno {
+		case syscall.ENOTDIR:
+			return NFS3ERR_NOTDIR
+		case syscall.EISDIR:
+			return NFS3ERR_ISDIR
+		case syscall.ENOTEMPTY:
+			return NFS3ERR_NOTEMPTY
+		case syscall.EINVAL:
+			return NFS3ERR_INVAL
+		case syscall.EXDEV:
+			return NFS3ERR_XDEV
+		case syscall.ENOSPC:
+			return NFS3ERR_NOSPC
+		case syscall.EROFS:
+			return NFS3ERR_ROFS
+		case syscall.EMLINK:
+			return NFS3ERR_MLINK
+		case syscall.ENAMETOOLONG:
+			return NFS3ERR_NAMETOOLONG
+		case syscall.EFBIG:
+			return NFS3ERR_FBIG
+		case syscall.EDQUOT:
+			return NFS3ERR_DQUOT
+		}
+	}
+
+	return NFS3ERR_IO
+}
+
+////////////////////// sattr3 //////////////////////
+
+/* time_how */
+const (
+	DONT_CHANGE        = uint32(0)
+	SET_TO_SERVER_TIME = uint32(1)
+	SET_TO_CLIENT_TIME = uint32(2)
+)
+
+type SetTime3 struct {
+	How  uint32 // DONT_CHANGE | SET_TO_SERVER_TIME | SET_TO_CLIENT_TIME
+	Time NFSTime
+}
+
+// Sattr3 is a decoded sattr3: the fields left nil are not to be set.
+type Sattr3 struct {
+	Mode  *uint32
+	Uid   *uint32
+	Gid   *uint32
+	Size  *uint64
+	ATime SetTime3
+	MTime SetTime3
+}
+
+////////////////////// create //////////////////////
+
+/* createmode3 */
+const (
+	UNCHECKED = uint32(0)
+	GUARDED   = uint32(1)
+	EXCLUSIVE = uint32(2)
+)
+
+type CREATE3args struct {
+	Where DirOpArgs3
+	Mode  uint32  // UNCHECKED | GUARDED | EXCLUSIVE
+	Attrs *Sattr3 // UNCHECKED | GUARDED
+	Verf  uint64  // EXCLUSIVE, type: createverf3
+}
+
+// CREATE3resok is the result of CREATE, MKDIR, SYMLINK and MKNOD.
+type CREATE3resok struct {
+	Obj      *PostOpFh3yes
+	ObjAttrs *PostOpAttr
+	DirWcc   *WccData
+}
+
+////////////////////// mkdir //////////////////////
+
+type MKDIR3args struct {
+	Where DirOpArgs3
+	Attrs *Sattr3
+}
+
+////////////////////// symlink //////////////////////
+
+type SYMLINK3args struct {
+	Where DirOpArgs3
+	Attrs *Sattr3
+	Data  string // type: nfspath3
+}
+
+////////////////////// mknod //////////////////////
+
+type MKNOD3args struct {
+	Where DirOpArgs3
+	Type  uint32  // type: ftype3
+	Attrs *Sattr3 // NF3CHR | NF3BLK | NF3SOCK | NF3FIFO
+	Spec  SpecData
+}
+
+////////////////////// rename //////////////////////
+
+type RENAME3args struct {
+	From DirOpArgs3
+	To   DirOpArgs3
+}
+
+type RENAME3resok struct {
+	FromDirWcc *WccData
+	ToDirWcc   *WccData
+}
+
+////////////////////// link //////////////////////
+
+type LINK3args struct {
+	File []byte // type: nfs_fh3
+	Link DirOpArgs3
+}
+
+type LINK3resok struct {
+	FileAttrs  *PostOpAttr
+	LinkDirWcc *WccData
+}
+
+////////////////////// setattr //////////////////////
+
+type SETATTR3args struct {
+	Object []byte // type: nfs_fh3
+	Attrs  *Sattr3
+	Guard  *NFSTime // obj_ctime of sattrguard3, if check is true.
+}
+
+////////////////////// readlink //////////////////////
+
+type READLINK3resok struct {
+	SymlinkAttrs *PostOpAttr
+	Data         string // type: nfspath3
+}
+
+////////////////////// readdir //////////////////////
+
+type READDIR3args struct {
+	Dir        []byte // type: nfs_fh3
+	Cookie     uint64 // type: cookie3
+	CookieVerf uint64 // type: cookieverf3
+	Count      uint32 // type: count3
+}
+
+type Entry3 struct {
+	FileId uint64
+	Name   string
+	Cookie uint64
+}
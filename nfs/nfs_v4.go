@@ -661,10 +661,21 @@ type OpenClaim4 struct {
 	FileDelegatePrev string                 // if Claim == CLAIM_DELEGATE_PREV
 }
 
+const (
+	OPEN4_SHARE_ACCESS_READ  = uint32(0x00000001)
+	OPEN4_SHARE_ACCESS_WRITE = uint32(0x00000002)
+	OPEN4_SHARE_ACCESS_BOTH  = uint32(0x00000003)
+
+	OPEN4_SHARE_DENY_NONE  = uint32(0x00000000)
+	OPEN4_SHARE_DENY_READ  = uint32(0x00000001)
+	OPEN4_SHARE_DENY_WRITE = uint32(0x00000002)
+	OPEN4_SHARE_DENY_BOTH  = uint32(0x00000003)
+)
+
 type OPEN4args struct {
 	SeqId       uint32
-	ShareAccess uint32
-	ShareDeny   uint32
+	ShareAccess uint32 // OPEN4_SHARE_ACCESS_*
+	ShareDeny   uint32 // OPEN4_SHARE_DENY_*
 	Owner       *OpenOwner4
 
 	OpenHow uint32 // OPEN4_NOCREATE | OPEN4_CREATE
@@ -681,6 +692,20 @@ type OPENDG4args struct {
 	ShareDeny   uint32
 }
 
+type OPEN_CONFIRM4args struct {
+	OpenStateId *StateId4
+	SeqId       uint32
+}
+
+type OPEN_CONFIRM4resok struct {
+	OpenStateId *StateId4
+}
+
+type OPEN_CONFIRM4res struct {
+	Status uint32
+	Ok     *OPEN_CONFIRM4resok
+}
+
 type NfsAce4 struct {
 	Type       uint32
 	Flag       uint32
@@ -688,6 +713,15 @@ type NfsAce4 struct {
 	Who        string
 }
 
+const (
+	ACE4_ACCESS_ALLOWED_ACE_TYPE = uint32(0x00000000)
+)
+
+const (
+	NFS_LIMIT_SIZE   = uint32(1)
+	NFS_LIMIT_BLOCKS = uint32(2)
+)
+
 type NfsModifiedLimit4 struct {
 	NumBlocks     uint32
 	BytesPerBlock uint32
@@ -748,6 +782,125 @@ type CLOSE4res struct {
 	Ok     *StateId4 // non-nil if Status == NFS4_OK
 }
 
+const (
+	READ_LT   = uint32(1)
+	WRITE_LT  = uint32(2)
+	READW_LT  = uint32(3) // blocking read
+	WRITEW_LT = uint32(4) // blocking write
+)
+
+type LockOwner4 struct {
+	ClientId uint64
+	Owner    string
+}
+
+type OpenToLockOwner4 struct {
+	OpenSeqId   uint32
+	OpenStateId *StateId4
+	LockSeqId   uint32
+	LockOwner   *LockOwner4
+}
+
+type ExistLockOwner4 struct {
+	LockStateId *StateId4
+	LockSeqId   uint32
+}
+
+type Locker4 struct {
+	NewLockOwner bool
+	OpenOwner    *OpenToLockOwner4 // if NewLockOwner
+	LockOwner    *ExistLockOwner4  // if !NewLockOwner
+}
+
+type LOCK4args struct {
+	LockType uint32 // *_LT
+	Reclaim  bool
+	Offset   uint64
+	Length   uint64
+	Locker   *Locker4
+}
+
+type LOCK4denied struct {
+	Offset   uint64
+	Length   uint64
+	LockType uint32
+	Owner    *LockOwner4
+}
+
+type LOCK4res struct {
+	Status uint32
+	Ok     *StateId4    // if Status == NFS4_OK
+	Denied *LOCK4denied // if Status == NFS4ERR_DENIED
+}
+
+type LOCKT4args struct {
+	LockType uint32
+	Offset   uint64
+	Length   uint64
+	Owner    *LockOwner4
+}
+
+type LOCKT4res struct {
+	Status uint32
+	Denied *LOCK4denied // if Status == NFS4ERR_DENIED
+}
+
+type LOCKU4args struct {
+	LockType    uint32
+	SeqId       uint32
+	LockStateId *StateId4
+	Offset      uint64
+	Length      uint64
+}
+
+type LOCKU4res struct {
+	Status uint32
+	Ok     *StateId4 // if Status == NFS4_OK
+}
+
+type RELEASE_LOCKOWNER4args struct {
+	LockOwner *LockOwner4
+}
+
+type RELEASE_LOCKOWNER4res struct {
+	Status uint32
+}
+
+type DELEGRETURN4args struct {
+	DelegStateId *StateId4
+}
+
+type DELEGRETURN4res struct {
+	Status uint32
+}
+
+type DELEGPURGE4args struct {
+	ClientId uint64
+}
+
+type DELEGPURGE4res struct {
+	Status uint32
+}
+
+// CB_COMPOUND4args is followed by the operations: nfs_cb_argop4<>.
+type CB_COMPOUND4args struct {
+	Tag           string
+	MinorVersion  uint32
+	CallbackIdent uint32
+}
+
+// CB_COMPOUND4res is followed by the results: nfs_cb_resop4<>.
+type CB_COMPOUND4res struct {
+	Status uint32
+	Tag    string
+}
+
+type CB_RECALL4args struct {
+	StateId  *StateId4
+	Truncate bool
+	Fh       []byte
+}
+
 type SETATTR4args struct {
 	StateId *StateId4
 	Attrs   *FAttr4
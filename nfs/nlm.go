@@ -0,0 +1,219 @@
+package nfs
+
+import (
+	"fmt"
+)
+
+// Network Lock Manager protocol, version 4.
+// https://pubs.opengroup.org/onlinepubs/9629799/chap14.htm
+// (along with the NFSv3 extensions of rfc1813, appendix II)
+
+const (
+	NLMPROC4_NULL        = uint32(0)
+	NLMPROC4_TEST        = uint32(1)
+	NLMPROC4_LOCK        = uint32(2)
+	NLMPROC4_CANCEL      = uint32(3)
+	NLMPROC4_UNLOCK      = uint32(4)
+	NLMPROC4_GRANTED     = uint32(5)
+	NLMPROC4_TEST_MSG    = uint32(6)
+	NLMPROC4_LOCK_MSG    = uint32(7)
+	NLMPROC4_CANCEL_MSG  = uint32(8)
+	NLMPROC4_UNLOCK_MSG  = uint32(9)
+	NLMPROC4_GRANTED_MSG = uint32(10)
+	NLMPROC4_TEST_RES    = uint32(11)
+	NLMPROC4_LOCK_RES    = uint32(12)
+	NLMPROC4_CANCEL_RES  = uint32(13)
+	NLMPROC4_UNLOCK_RES  = uint32(14)
+	NLMPROC4_GRANTED_RES = uint32(15)
+	NLMPROC4_SHARE       = uint32(20)
+	NLMPROC4_UNSHARE     = uint32(21)
+	NLMPROC4_NM_LOCK     = uint32(22)
+	NLMPROC4_FREE_ALL    = uint32(23)
+)
+
+func NlmProcName(proc uint32) string {
+	switch proc {
+	case NLMPROC4_NULL:
+		return "null"
+	case NLMPROC4_TEST:
+		return "test"
+	case NLMPROC4_LOCK:
+		return "lock"
+	case NLMPROC4_CANCEL:
+		return "cancel"
+	case NLMPROC4_UNLOCK:
+		return "unlock"
+	case NLMPROC4_GRANTED:
+		return "granted"
+	case NLMPROC4_TEST_MSG:
+		return "test_msg"
+	case NLMPROC4_LOCK_MSG:
+		return "lock_msg"
+	case NLMPROC4_CANCEL_MSG:
+		return "cancel_msg"
+	case NLMPROC4_UNLOCK_MSG:
+		return "unlock_msg"
+	case NLMPROC4_GRANTED_MSG:
+		return "granted_msg"
+	case NLMPROC4_TEST_RES:
+		return "test_res"
+	case NLMPROC4_LOCK_RES:
+		return "lock_res"
+	case NLMPROC4_CANCEL_RES:
+		return "cancel_res"
+	case NLMPROC4_UNLOCK_RES:
+		return "unlock_res"
+	case NLMPROC4_GRANTED_RES:
+		return "granted_res"
+	case NLMPROC4_SHARE:
+		return "share"
+	case NLMPROC4_UNSHARE:
+		return "unshare"
+	case NLMPROC4_NM_LOCK:
+		return "nm_lock"
+	case NLMPROC4_FREE_ALL:
+		return "free_all"
+	}
+	return fmt.Sprintf("%d", proc)
+}
+
+/* nlm4_stats */
+const (
+	NLM4_GRANTED             = uint32(0)
+	NLM4_DENIED              = uint32(1)
+	NLM4_DENIED_NOLOCKS      = uint32(2)
+	NLM4_BLOCKED             = uint32(3)
+	NLM4_DENIED_GRACE_PERIOD = uint32(4)
+	NLM4_DEADLCK             = uint32(5)
+	NLM4_ROFS                = uint32(6)
+	NLM4_STALE_FH            = uint32(7)
+	NLM4_FBIG                = uint32(8)
+	NLM4_FAILED              = uint32(9)
+)
+
+/* LM_MAXSTRLEN */
+const NLM_MAXSTRLEN = 1024
+
+type Nlm4Lock struct {
+	CallerName string
+	Fh         []byte // type: netobj
+	Oh         []byte // type: netobj, the owner of the lock.
+	Svid       int32  // the process of the owner.
+	Offset     uint64
+	Length     uint64 // 0: up to the end of the file.
+}
+
+type Nlm4Holder struct {
+	Exclusive bool
+	Svid      int32
+	Oh        []byte // type: netobj
+	Offset    uint64
+	Length    uint64
+}
+
+type Nlm4LockArgs struct {
+	Cookie    []byte // type: netobj
+	Block     bool
+	Exclusive bool
+	Lock      Nlm4Lock
+	Reclaim   bool
+	State     int32
+}
+
+type Nlm4CancArgs struct {
+	Cookie    []byte
+	Block     bool
+	Exclusive bool
+	Lock      Nlm4Lock
+}
+
+type Nlm4TestArgs struct {
+	Cookie    []byte
+	Exclusive bool
+	Lock      Nlm4Lock
+}
+
+type Nlm4UnlockArgs struct {
+	Cookie []byte
+	Lock   Nlm4Lock
+}
+
+type Nlm4Res struct {
+	Cookie []byte
+	Stat   uint32 // nlm4_stats
+}
+
+type Nlm4Notify struct {
+	Name  string
+	State int32
+}
+
+// Network Status Monitor protocol, version 1.
+// https://pubs.opengroup.org/onlinepubs/9629799/chap11.htm
+
+const (
+	SM_MAXSTRLEN = 1024
+)
+
+const (
+	SMPROC_NULL       = uint32(0)
+	SMPROC_STAT       = uint32(1)
+	SMPROC_MON        = uint32(2)
+	SMPROC_UNMON      = uint32(3)
+	SMPROC_UNMON_ALL  = uint32(4)
+	SMPROC_SIMU_CRASH = uint32(5)
+	SMPROC_NOTIFY     = uint32(6)
+)
+
+func NsmProcName(proc uint32) string {
+	switch proc {
+	case SMPROC_NULL:
+		return "null"
+	case SMPROC_STAT:
+		return "stat"
+	case SMPROC_MON:
+		return "mon"
+	case SMPROC_UNMON:
+		return "unmon"
+	case SMPROC_UNMON_ALL:
+		return "unmon_all"
+	case SMPROC_SIMU_CRASH:
+		return "simu_crash"
+	case SMPROC_NOTIFY:
+		return "notify"
+	}
+	return fmt.Sprintf("%d", proc)
+}
+
+/* res */
+const (
+	STAT_SUCC = uint32(0)
+	STAT_FAIL = uint32(1)
+)
+
+type SmMyId struct {
+	MyName string
+	MyProg uint32
+	MyVers uint32
+	MyProc uint32
+}
+
+type SmMonId struct {
+	MonName string
+	MyId    SmMyId
+}
+
+type SmMon struct {
+	MonId SmMonId
+	Priv  [16]byte
+}
+
+type SmStatRes struct {
+	Res   uint32 // STAT_SUCC | STAT_FAIL
+	State int32
+}
+
+type SmStatChge struct {
+	MonName string
+	State   int32
+}
@@ -0,0 +1,107 @@
+package nfs
+
+import (
+	"fmt"
+)
+
+// Port mapper(v2) and rpcbind(v3, v4) protocol.
+// https://datatracker.ietf.org/doc/html/rfc1833
+
+const (
+	IPPROTO_TCP = uint32(6)
+	IPPROTO_UDP = uint32(17)
+)
+
+/* port mapper, version 2 */
+const (
+	PMAPPROC_NULL    = uint32(0)
+	PMAPPROC_SET     = uint32(1)
+	PMAPPROC_UNSET   = uint32(2)
+	PMAPPROC_GETPORT = uint32(3)
+	PMAPPROC_DUMP    = uint32(4)
+	PMAPPROC_CALLIT  = uint32(5)
+)
+
+/* rpcbind, version 3 and 4 */
+const (
+	RPCBPROC_NULL        = uint32(0)
+	RPCBPROC_SET         = uint32(1)
+	RPCBPROC_UNSET       = uint32(2)
+	RPCBPROC_GETADDR     = uint32(3)
+	RPCBPROC_DUMP        = uint32(4)
+	RPCBPROC_CALLIT      = uint32(5) /* v3 only. v4: RPCBPROC_BCAST */
+	RPCBPROC_GETTIME     = uint32(6)
+	RPCBPROC_UADDR2TADDR = uint32(7)
+	RPCBPROC_TADDR2UADDR = uint32(8)
+	RPCBPROC_GETVERSADDR = uint32(9)  /* v4 only */
+	RPCBPROC_INDIRECT    = uint32(10) /* v4 only */
+	RPCBPROC_GETADDRLIST = uint32(11) /* v4 only */
+	RPCBPROC_GETSTAT     = uint32(12) /* v4 only */
+)
+
+func PmapProcName(vers, proc uint32) string {
+	if vers == 2 {
+		switch proc {
+		case PMAPPROC_NULL:
+			return "null"
+		case PMAPPROC_SET:
+			return "set"
+		case PMAPPROC_UNSET:
+			return "unset"
+		case PMAPPROC_GETPORT:
+			return "getport"
+		case PMAPPROC_DUMP:
+			return "dump"
+		case PMAPPROC_CALLIT:
+			return "callit"
+		}
+		return fmt.Sprintf("%d", proc)
+	}
+
+	switch proc {
+	case RPCBPROC_NULL:
+		return "null"
+	case RPCBPROC_SET:
+		return "set"
+	case RPCBPROC_UNSET:
+		return "unset"
+	case RPCBPROC_GETADDR:
+		return "getaddr"
+	case RPCBPROC_DUMP:
+		return "dump"
+	case RPCBPROC_CALLIT:
+		return "callit"
+	case RPCBPROC_GETTIME:
+		return "gettime"
+	case RPCBPROC_UADDR2TADDR:
+		return "uaddr2taddr"
+	case RPCBPROC_TADDR2UADDR:
+		return "taddr2uaddr"
+	case RPCBPROC_GETVERSADDR:
+		return "getversaddr"
+	case RPCBPROC_INDIRECT:
+		return "indirect"
+	case RPCBPROC_GETADDRLIST:
+		return "getaddrlist"
+	case RPCBPROC_GETSTAT:
+		return "getstat"
+	}
+	return fmt.Sprintf("%d", proc)
+}
+
+/* struct mapping */
+type PmapMapping struct {
+	Prog uint32
+	Vers uint32
+	Prot uint32 /* IPPROTO_TCP | IPPROTO_UDP */
+	Port uint32
+}
+
+/* struct rpcb */
+type Rpcb struct {
+	Prog  uint32
+	Vers  uint32
+	Netid string /* e.g.: "tcp", "tcp6" */
+	Addr  string /* universal address, e.g.: "127.0.0.1.8.1" */
+	Owner string
+}
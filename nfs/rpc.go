@@ -1,11 +1,21 @@
 package nfs
 
 import (
+	"errors"
 	"fmt"
 )
 
 // https://datatracker.ietf.org/doc/html/rfc1057
 
+/* rpc program numbers */
+const (
+	PROG_PMAP  = uint32(100000)
+	PROG_NFS   = uint32(100003)
+	PROG_MOUNT = uint32(100005)
+	PROG_NLM   = uint32(100021)
+	PROG_NSM   = uint32(100024)
+)
+
 const (
 	RPC_CALL = uint32(iota)
 	RPC_REPLY
@@ -22,6 +32,7 @@ const (
 	ACCEPT_PROG_MISMATCH                /* remote can't support version #  */
 	ACCEPT_PROC_UNAVAIL                 /* program can't support procedure */
 	ACCEPT_GRABAGE_ARGS                 /* procedure can't decode params   */
+	ACCEPT_SYSTEM_ERR                   /* e.g. memory allocation failure  */
 )
 
 const (
@@ -57,6 +68,15 @@ func (err *AuthError) Error() string {
 	return fmt.Sprintf("auth error: %d", err.Code)
 }
 
+// ErrProcUnavail is returned by a mux for a procedure it does not support.
+// The call is then replied with PROC_UNAVAIL.
+var ErrProcUnavail = errors.New("procedure unavailable")
+
+// ErrGarbageArgs is returned by a mux for the arguments of a call it
+// can't decode. The call is then replied with GARBAGE_ARGS, and with
+// SYSTEM_ERR for any other error.
+var ErrGarbageArgs = errors.New("garbage args")
+
 var (
 	ErrBadCredentials = &AuthError{Code: AUTH_BADCRED}
 	ErrTooWeak        = &AuthError{Code: AUTH_TOOWEAK}
@@ -81,13 +101,22 @@ type RPCMsgCall struct {
 
 func (h *RPCMsgCall) String() string {
 	procName := fmt.Sprintf("%d", h.Proc)
-	if h.Prog == 100003 {
+	switch h.Prog {
+	case PROG_NFS:
 		switch h.Vers {
 		case 3:
 			procName = Proc3Name(h.Proc)
 		case 4:
 			procName = Proc4Name(h.Proc)
 		}
+	case PROG_PMAP:
+		procName = PmapProcName(h.Vers, h.Proc)
+	case PROG_MOUNT:
+		procName = MountProcName(h.Proc)
+	case PROG_NLM:
+		procName = NlmProcName(h.Proc)
+	case PROG_NSM:
+		procName = NsmProcName(h.Proc)
 	}
 	return fmt.Sprintf(
 		"<prog=%d, v=%d, proc=%s>",
@@ -0,0 +1,38 @@
+package nfs
+
+import (
+	"sync"
+	"time"
+)
+
+// writeVerifier is the verifier of the data written, returned by WRITE
+// and COMMIT of both NFSv3 and NFSv4 (rfc1813, 3.3.7; rfc7530, 16.36).
+var writeVerifier = struct {
+	mu sync.Mutex
+	v  uint64
+}{v: uint64(time.Now().UnixNano())}
+
+// WriteVerifier returns the write verifier of the server. It changes
+// when the server restarts, or when the data written UNSTABLE may have
+// been lost, so that the clients send again the data they didn't commit
+// since.
+func WriteVerifier() uint64 {
+	writeVerifier.mu.Lock()
+	defer writeVerifier.mu.Unlock()
+
+	return writeVerifier.v
+}
+
+// ResetWriteVerifier changes the write verifier, when the data written
+// UNSTABLE may have been lost: the servers call it once a file fails to
+// be flushed, and a backend may call it after losing data of its own.
+func ResetWriteVerifier() {
+	writeVerifier.mu.Lock()
+	defer writeVerifier.mu.Unlock()
+
+	v := uint64(time.Now().UnixNano())
+	if v == writeVerifier.v {
+		v++
+	}
+	writeVerifier.v = v
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/smallfz/libnfs-go/auth"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// pmapPort is the port of the port mapper of the clients.
+var pmapPort = 111
+
+// callbackTimeout limits a call of the server to a client.
+const callbackTimeout = time.Second * 10
+
+// maxReplySize limits the size in bytes of the reply to a call of the
+// server to a client: the replies of the procedures called (GETPORT,
+// GRANTED, CB_NULL, CB_COMPOUND of CB_RECALL) are small.
+const maxReplySize = 64 * 1024
+
+// rpcClient calls a rpc program of a client, e.g. to send
+// the GRANTED callbacks of NLM.
+type rpcClient struct {
+	host string // address of the client, without the port.
+}
+
+// getPort asks the port mapper of the client for the port of a program.
+func (c *rpcClient) getPort(prog, vers uint32) (int, error) {
+	r, err := c.call(pmapPort, nfs.PROG_PMAP, 2, nfs.PMAPPROC_GETPORT, &nfs.PmapMapping{
+		Prog: prog,
+		Vers: vers,
+		Prot: nfs.IPPROTO_TCP,
+	})
+	if err != nil {
+		return 0, err
+	}
+	port, err := r.ReadUint32()
+	if err != nil {
+		return 0, err
+	}
+	if port == 0 {
+		return 0, fmt.Errorf("program %d(v%d) not registered at %s", prog, vers, c.host)
+	}
+	return int(port), nil
+}
+
+// callUaddr calls a procedure of a program at a universal address
+// (rfc5665, 5.2.3), e.g. the callback of a NFSv4 client. Only TCP is
+// supported.
+func callUaddr(netId, uaddr string, prog, vers, proc uint32, args ...interface{}) (*xdr.Reader, error) {
+	if netId != "tcp" && netId != "tcp6" {
+		return nil, fmt.Errorf("netid not supported: %s", netId)
+	}
+	host, port, err := parseUaddr(uaddr)
+	if err != nil {
+		return nil, err
+	}
+	c := &rpcClient{host: host}
+	return c.call(port, prog, vers, proc, args...)
+}
+
+// parseUaddr returns the host and the port of a universal address: the
+// host followed by the two bytes of the port, in decimal, dot separated.
+func parseUaddr(uaddr string) (string, int, error) {
+	i := strings.LastIndex(uaddr, ".")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid uaddr: %s", uaddr)
+	}
+	j := strings.LastIndex(uaddr[:i], ".")
+	if j < 0 {
+		return "", 0, fmt.Errorf("invalid uaddr: %s", uaddr)
+	}
+	hi, err := strconv.ParseUint(uaddr[j+1:i], 10, 8)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid uaddr: %s", uaddr)
+	}
+	lo, err := strconv.ParseUint(uaddr[i+1:], 10, 8)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid uaddr: %s", uaddr)
+	}
+	return uaddr[:j], int(hi<<8 | lo), nil
+}
+
+// callCred returns the credential of a call: AUTH_NULL for the NULL
+// procedure of a program only, since the clients refuse it for the
+// others (e.g. CB_COMPOUND of the NFSv4 callback), and AUTH_SYS as root
+// of the host of the server otherwise.
+func callCred(proc uint32) *nfs.Auth {
+	if proc == 0 {
+		return nfs.NewEmptyAuth()
+	}
+	hostname, _ := os.Hostname()
+	body := bytes.NewBuffer([]byte{})
+	xdr.NewWriter(body).WriteAny(&auth.Creds{
+		ExpirationValue:  uint32(time.Now().Unix()),
+		Hostname:         hostname,
+		AdditionalGroups: []uint32{},
+	})
+	return &nfs.Auth{Flavor: nfs.AUTH_FLAVOR_UNIX, Body: body.Bytes()}
+}
+
+// call calls a procedure, sending args in sequence, and returns
+// a reader of its results.
+func (c *rpcClient) call(port int, prog, vers, proc uint32, args ...interface{}) (*xdr.Reader, error) {
+	addr := net.JoinHostPort(c.host, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, callbackTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(callbackTimeout))
+
+	xid := rand.Uint32()
+
+	body := bytes.NewBuffer([]byte{})
+	w := xdr.NewWriter(body)
+	seq := []interface{}{
+		&nfs.RPCMsgCall{
+			Xid:     xid,
+			MsgType: nfs.RPC_CALL,
+			RPCVer:  2,
+			Prog:    prog,
+			Vers:    vers,
+			Proc:    proc,
+			Cred:    callCred(proc),
+			Verf:    nfs.NewEmptyAuth(),
+		},
+	}
+	for _, v := range append(seq, args...) {
+		if _, err := w.WriteAny(v); err != nil {
+			return nil, err
+		}
+	}
+
+	cw := xdr.NewWriter(conn)
+	if _, err := cw.WriteUint32(uint32(body.Len()) | lastFragment); err != nil {
+		return nil, err
+	}
+	if _, err := cw.Write(body.Bytes()); err != nil {
+		return nil, err
+	}
+
+	// The reply, reassembled from its fragments.
+	cr := xdr.NewReader(conn)
+	rec := []byte{}
+	for {
+		frag, err := cr.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		if size := int(frag &^ lastFragment); len(rec)+size > maxReplySize {
+			return nil, fmt.Errorf("reply too large: more than %d bytes", maxReplySize)
+		}
+		dat, err := cr.ReadBytes(int(frag &^ lastFragment))
+		if err != nil {
+			return nil, err
+		}
+		rec = append(rec, dat...)
+		if frag&lastFragment != 0 {
+			break
+		}
+	}
+
+	r := xdr.NewReader(bytes.NewReader(rec))
+	rh := &nfs.RPCMsgReply{}
+	if _, err := r.ReadAs(rh); err != nil {
+		return nil, err
+	}
+	if rh.Xid != xid || rh.MsgType != nfs.RPC_REPLY {
+		return nil, fmt.Errorf("unexpected reply(xid=%d)", rh.Xid)
+	}
+	if rh.ReplyStat != nfs.MSG_ACCEPTED {
+		return nil, fmt.Errorf("call denied")
+	}
+	if _, err := r.ReadAs(&nfs.Auth{}); err != nil {
+		return nil, err
+	}
+	stat, err := r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	if stat != nfs.ACCEPT_SUCCESS {
+		return nil, fmt.Errorf("call not accepted: accept_stat=%d", stat)
+	}
+	return r, nil
+}
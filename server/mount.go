@@ -0,0 +1,270 @@
+package server
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// MountEntry is a record of the mount table: a directory mounted
+// by a client through the MOUNT protocol.
+type MountEntry struct {
+	Host string    // address of the client.
+	Path string    // the directory mounted.
+	Time time.Time // when it was mounted.
+}
+
+// mountTable keeps the directories mounted by the clients. Like the
+// one of mountd, it is informative only: clients that did not unmount
+// (e.g. because they rebooted) remain listed.
+type mountTable struct {
+	mu      sync.Mutex
+	entries []MountEntry
+}
+
+func (t *mountTable) add(host, pathName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i, e := range t.entries {
+		if e.Host == host && e.Path == pathName {
+			t.entries[i].Time = time.Now()
+			return
+		}
+	}
+	t.entries = append(t.entries, MountEntry{
+		Host: host,
+		Path: pathName,
+		Time: time.Now(),
+	})
+}
+
+// remove removes the entries of host: all of them if pathName is empty.
+func (t *mountTable) remove(host, pathName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := t.entries[:0]
+	for _, e := range t.entries {
+		if e.Host == host && (pathName == "" || e.Path == pathName) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	t.entries = entries
+}
+
+func (t *mountTable) list() []MountEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	rs := make([]MountEntry, len(t.entries))
+	copy(rs, t.entries)
+
+	sort.Slice(rs, func(i, j int) bool {
+		if rs[i].Host != rs[j].Host {
+			return rs[i].Host < rs[j].Host
+		}
+		return rs[i].Path < rs[j].Path
+	})
+	return rs
+}
+
+// mountMux answers the calls of the MOUNT protocol, version 3
+// (rfc1813, appendix I). The file handles are the ones of the fs.FS.
+type mountMux struct {
+	reader *xdr.Reader
+	writer *xdr.Writer
+	auth   nfs.AuthenticationHandler
+	fs     fs.FS
+
+	host    string // address of the client.
+	exports []string
+	mounts  *mountTable
+}
+
+func (x *mountMux) HandleProc(h *nfs.RPCMsgCall) (int, error) {
+	switch h.Proc {
+	case nfs.MOUNTPROC3_NULL:
+		return x.null(h)
+	case nfs.MOUNTPROC3_MNT:
+		return x.mnt(h)
+	case nfs.MOUNTPROC3_DUMP:
+		return x.dump(h)
+	case nfs.MOUNTPROC3_UMNT:
+		return x.umnt(h)
+	case nfs.MOUNTPROC3_UMNTALL:
+		return x.umntAll(h)
+	case nfs.MOUNTPROC3_EXPORT:
+		return x.export(h)
+	}
+	return 0, fmt.Errorf("%w: mount %s", nfs.ErrProcUnavail, nfs.MountProcName(h.Proc))
+}
+
+// authenticate writes the header of the reply. It reports false
+// if the credential of the call has been rejected.
+func (x *mountMux) authenticate(h *nfs.RPCMsgCall) (bool, error) {
+	return authenticate(x.writer, x.auth, x.fs, h)
+}
+
+func (x *mountMux) null(h *nfs.RPCMsgCall) (int, error) {
+	_, err := x.authenticate(h)
+	return 0, err
+}
+
+// exported reports whether pathName is an export or lies in one.
+func (x *mountMux) exported(pathName string) bool {
+	for _, e := range x.exports {
+		if e == fs.ROOT || pathName == e || strings.HasPrefix(pathName, e+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *mountMux) mnt(h *nfs.RPCMsgCall) (int, error) {
+	dirPath := ""
+	size, err := x.reader.ReadAs(&dirPath)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := x.authenticate(h); !ok || err != nil {
+		return size, err
+	}
+
+	fh, stat := x.resolve(dirPath)
+	log.Infof("mount: mnt(%s) from %s: %d", dirPath, x.host, stat)
+
+	if stat != nfs.MNT3_OK {
+		_, err := x.writer.WriteUint32(stat)
+		return size, err
+	}
+
+	x.mounts.add(x.host, fs.Abs(dirPath))
+
+	res := &nfs.MountRes3ok{
+		Fh: fh,
+		AuthFlavors: []uint32{
+			nfs.AUTH_FLAVOR_UNIX,
+			nfs.AUTH_FLAVOR_NULL,
+		},
+	}
+	if _, err := x.writer.WriteUint32(nfs.MNT3_OK); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(res)
+	return size, err
+}
+
+// resolve returns the file handle of an exported directory.
+func (x *mountMux) resolve(dirPath string) ([]byte, uint32) {
+	if len(dirPath) > nfs.MNTPATHLEN {
+		return nil, nfs.MNT3ERR_NAMETOOLONG
+	}
+
+	pathName := fs.Abs(dirPath)
+	if !x.exported(pathName) {
+		return nil, nfs.MNT3ERR_ACCES
+	}
+
+	fi, err := x.fs.Stat(pathName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nfs.MNT3ERR_NOENT
+		}
+		log.Warnf("fs.Stat(%s): %v", pathName, err)
+		return nil, nfs.MNT3ERR_IO
+	}
+	if !fi.IsDir() {
+		return nil, nfs.MNT3ERR_NOTDIR
+	}
+
+	if pathName == fs.ROOT {
+		return x.fs.GetRootHandle(), nfs.MNT3_OK
+	}
+
+	fh, err := x.fs.GetHandle(fi)
+	if err != nil {
+		log.Warnf("fs.GetHandle(%s): %v", pathName, err)
+		return nil, nfs.MNT3ERR_SERVERFAULT
+	}
+	return fh, nfs.MNT3_OK
+}
+
+func (x *mountMux) dump(h *nfs.RPCMsgCall) (int, error) {
+	if ok, err := x.authenticate(h); !ok || err != nil {
+		return 0, err
+	}
+
+	// mountlist: an optional-data linked list.
+	for _, e := range x.mounts.list() {
+		seq := []interface{}{
+			true,
+			&nfs.MountBody{
+				Hostname:  e.Host,
+				Directory: e.Path,
+			},
+		}
+		for _, v := range seq {
+			if _, err := x.writer.WriteAny(v); err != nil {
+				return 0, err
+			}
+		}
+	}
+	_, err := x.writer.WriteAny(false)
+	return 0, err
+}
+
+func (x *mountMux) umnt(h *nfs.RPCMsgCall) (int, error) {
+	dirPath := ""
+	size, err := x.reader.ReadAs(&dirPath)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := x.authenticate(h); !ok || err != nil {
+		return size, err
+	}
+
+	log.Infof("mount: umnt(%s) from %s", dirPath, x.host)
+	x.mounts.remove(x.host, fs.Abs(dirPath))
+	return size, nil
+}
+
+func (x *mountMux) umntAll(h *nfs.RPCMsgCall) (int, error) {
+	if ok, err := x.authenticate(h); !ok || err != nil {
+		return 0, err
+	}
+
+	log.Infof("mount: umntall from %s", x.host)
+	x.mounts.remove(x.host, "")
+	return 0, nil
+}
+
+func (x *mountMux) export(h *nfs.RPCMsgCall) (int, error) {
+	if ok, err := x.authenticate(h); !ok || err != nil {
+		return 0, err
+	}
+
+	// exports: an optional-data linked list of exportnode,
+	// whose ex_groups are left empty: everyone is allowed.
+	for _, e := range x.exports {
+		seq := []interface{}{true, e, false}
+		for _, v := range seq {
+			if _, err := x.writer.WriteAny(v); err != nil {
+				return 0, err
+			}
+		}
+	}
+	_, err := x.writer.WriteAny(false)
+	return 0, err
+}
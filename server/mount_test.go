@@ -0,0 +1,99 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+func TestMount(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	mfs.MkdirAll("/export/sub", os.FileMode(0o755))
+	mfs.MkdirAll("/private", os.FileMode(0o755))
+
+	svr := newTestServerFS(t, mfs)
+	svr.Exports = []string{"/export"}
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	mnt := func(xid uint32, dirPath string) (uint32, []byte) {
+		conn.Write(callRecord(xid, nfs.PROG_MOUNT, 3, nfs.MOUNTPROC3_MNT, dirPath))
+		_, stat, r := readReplyBody(t, conn)
+		if stat != nfs.ACCEPT_SUCCESS {
+			t.Fatalf("mnt(%s): stat=%d", dirPath, stat)
+		}
+		status, err := r.ReadUint32()
+		if err != nil {
+			t.Fatalf("mnt(%s): %v", dirPath, err)
+		}
+		if status != nfs.MNT3_OK {
+			return status, nil
+		}
+		res := &nfs.MountRes3ok{}
+		if _, err := r.ReadAs(res); err != nil {
+			t.Fatalf("mnt(%s): %v", dirPath, err)
+		}
+		return status, res.Fh
+	}
+
+	status, fh := mnt(1, "/export/sub")
+	if status != nfs.MNT3_OK {
+		t.Fatalf("mnt(/export/sub): expects MNT3_OK but get %d", status)
+	}
+	fi, _ := mfs.Stat("/export/sub")
+	if want, _ := mfs.GetHandle(fi); !bytes.Equal(fh, want) {
+		t.Fatalf("mnt(/export/sub): expects handle %x but get %x", want, fh)
+	}
+
+	if status, _ := mnt(2, "/private"); status != nfs.MNT3ERR_ACCES {
+		t.Fatalf("mnt(/private): expects MNT3ERR_ACCES but get %d", status)
+	}
+	if status, _ := mnt(3, "/export/missing"); status != nfs.MNT3ERR_NOENT {
+		t.Fatalf("mnt(/export/missing): expects MNT3ERR_NOENT but get %d", status)
+	}
+
+	mounts := svr.Mounts()
+	if len(mounts) != 1 || mounts[0].Path != "/export/sub" || mounts[0].Host != "127.0.0.1" {
+		t.Fatalf("unexpected mount table: %+v", mounts)
+	}
+
+	// export
+	conn.Write(callRecord(4, nfs.PROG_MOUNT, 3, nfs.MOUNTPROC3_EXPORT))
+	_, _, r := readReplyBody(t, conn)
+	exports := []string{}
+	for {
+		follows := false
+		if _, err := r.ReadAs(&follows); err != nil {
+			t.Fatalf("export: %v", err)
+		}
+		if !follows {
+			break
+		}
+		dir, groups := "", false
+		r.ReadAs(&dir)
+		r.ReadAs(&groups)
+		exports = append(exports, dir)
+	}
+	if len(exports) != 1 || exports[0] != "/export" {
+		t.Fatalf("unexpected exports: %v", exports)
+	}
+
+	// umnt
+	conn.Write(callRecord(5, nfs.PROG_MOUNT, 3, nfs.MOUNTPROC3_UMNT, "/export/sub"))
+	if _, stat := readReply(t, conn); stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("umnt: stat=%d", stat)
+	}
+	if mounts := svr.Mounts(); len(mounts) != 0 {
+		t.Fatalf("expects an empty mount table but get %+v", mounts)
+	}
+}
@@ -1,9 +1,10 @@
 package server
 
 import (
-	"errors"
+	"fmt"
 
 	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/locks"
 	"github.com/smallfz/libnfs-go/nfs"
 	handlers "github.com/smallfz/libnfs-go/nfs/implv3"
 	"github.com/smallfz/libnfs-go/xdr"
@@ -15,6 +16,7 @@ type Mux struct {
 	auth   nfs.AuthenticationHandler
 	fs     fs.FS
 	stat   nfs.StatService
+	locks  *locks.Table
 }
 
 var _ nfs.RPCContext = (*Mux)(nil)
@@ -45,6 +47,10 @@ func (x *Mux) GetFS() fs.FS {
 	return x.fs
 }
 
+func (x *Mux) LockTable() *locks.Table {
+	return x.locks
+}
+
 func (x *Mux) HandleProc(h *nfs.RPCMsgCall) (int, error) {
 	switch h.Proc {
 	case nfs.ProcVoid:
@@ -63,6 +69,34 @@ func (x *Mux) HandleProc(h *nfs.RPCMsgCall) (int, error) {
 		return handlers.Lookup(h, x)
 	case nfs.ProcReaddirPlus:
 		return handlers.ReaddirPlus(h, x)
+	case nfs.ProcRead:
+		return handlers.Read(h, x)
+	case nfs.ProcWrite:
+		return handlers.Write(h, x)
+	case nfs.ProcCommit:
+		return handlers.Commit(h, x)
+	case nfs.ProcCreate:
+		return handlers.Create(h, x)
+	case nfs.ProcMkdir:
+		return handlers.Mkdir(h, x)
+	case nfs.ProcSymlink:
+		return handlers.Symlink(h, x)
+	case nfs.ProcMknod:
+		return handlers.Mknod(h, x)
+	case nfs.ProcRemove:
+		return handlers.Remove(h, x)
+	case nfs.ProcRmdir:
+		return handlers.Rmdir(h, x)
+	case nfs.ProcRename:
+		return handlers.Rename(h, x)
+	case nfs.ProcLink:
+		return handlers.Link(h, x)
+	case nfs.ProcSetAttr:
+		return handlers.SetAttr(h, x)
+	case nfs.ProcReadLink:
+		return handlers.ReadLink(h, x)
+	case nfs.ProcReaddir:
+		return handlers.ReadDir(h, x)
 	}
-	return 0, errors.New("not implemented")
+	return 0, fmt.Errorf("%w: %s", nfs.ErrProcUnavail, nfs.Proc3Name(h.Proc))
 }
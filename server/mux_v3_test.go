@@ -0,0 +1,548 @@
+package server
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func TestMuxV3ReadWrite(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	f, err := mfs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.Close()
+	fi, _ := mfs.Stat("/hello.txt")
+	fh, _ := mfs.GetHandle(fi)
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// write
+	conn.Write(callRecord(1, nfs.PROG_NFS, 3, nfs.ProcWrite, &nfs.WRITE3args{
+		File:   fh,
+		Offset: 6,
+		Count:  5,
+		Stable: nfs.UNSTABLE,
+		Data:   []byte("world"),
+	}))
+	_, stat, r := readReplyBody(t, conn)
+	if stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("write: stat=%d", stat)
+	}
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("write: expects NFS3_OK but get %d", status)
+	}
+	skipWccData(t, r)
+	count, _ := r.ReadUint32()
+	committed, _ := r.ReadUint32()
+	verf := uint64(0)
+	r.ReadAs(&verf)
+	if count != 5 || committed != nfs.UNSTABLE {
+		t.Fatalf("write: count=%d, committed=%d", count, committed)
+	}
+
+	// read
+	conn.Write(callRecord(2, nfs.PROG_NFS, 3, nfs.ProcRead, &nfs.READ3args{
+		File:   fh,
+		Offset: 6,
+		Count:  100,
+	}))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("read: expects NFS3_OK but get %d", status)
+	}
+	skipPostOpAttr(t, r)
+	count, _ = r.ReadUint32()
+	eof := false
+	r.ReadAs(&eof)
+	data := []byte{}
+	r.ReadAs(&data)
+	if count != 5 || !eof || string(data) != "world" {
+		t.Fatalf("read: count=%d, eof=%v, data=%q", count, eof, data)
+	}
+
+	// commit
+	conn.Write(callRecord(3, nfs.PROG_NFS, 3, nfs.ProcCommit, &nfs.COMMIT3args{
+		File: fh,
+	}))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("commit: expects NFS3_OK but get %d", status)
+	}
+	skipWccData(t, r)
+	v := uint64(0)
+	if r.ReadAs(&v); v != verf {
+		t.Fatalf("commit: expects verifier %x but get %x", verf, v)
+	}
+}
+
+func TestMuxV3Namespace(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	root := mfs.GetRootHandle()
+	if err := mfs.MkdirAll("/e/f", os.FileMode(0o755)); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	where := func(name string) *nfs.DirOpArgs3 {
+		return &nfs.DirOpArgs3{Dir: root, Filename: name}
+	}
+	// sattr3 setting the mode only.
+	sattr := []interface{}{true, uint32(0o644), false, false, false, nfs.DONT_CHANGE, nfs.DONT_CHANGE}
+
+	calls := []struct {
+		name   string
+		proc   uint32
+		args   []interface{}
+		status uint32
+	}{
+		{"create", nfs.ProcCreate, append([]interface{}{where("a.txt"), nfs.GUARDED}, sattr...), nfs.NFS3_OK},
+		{"create guarded", nfs.ProcCreate, append([]interface{}{where("a.txt"), nfs.GUARDED}, sattr...), nfs.NFS3ERR_EXIST},
+		{"create unchecked", nfs.ProcCreate, append([]interface{}{where("a.txt"), nfs.UNCHECKED}, sattr...), nfs.NFS3_OK},
+		{"create exclusive", nfs.ProcCreate, []interface{}{where("b.txt"), nfs.EXCLUSIVE, uint64(7)}, nfs.NFS3_OK},
+		{"create exclusive again", nfs.ProcCreate, []interface{}{where("b.txt"), nfs.EXCLUSIVE, uint64(7)}, nfs.NFS3_OK},
+		{"create exclusive other", nfs.ProcCreate, []interface{}{where("b.txt"), nfs.EXCLUSIVE, uint64(8)}, nfs.NFS3ERR_EXIST},
+		{"create invalid name", nfs.ProcCreate, append([]interface{}{where("x/y"), nfs.GUARDED}, sattr...), nfs.NFS3ERR_INVAL},
+		{"mkdir", nfs.ProcMkdir, append([]interface{}{where("d")}, sattr...), nfs.NFS3_OK},
+		{"mkdir again", nfs.ProcMkdir, append([]interface{}{where("d")}, sattr...), nfs.NFS3ERR_EXIST},
+		{"mknod", nfs.ProcMknod, append([]interface{}{where("p"), nfs.FTYPE_NF3FIFO}, sattr...), nfs.NFS3ERR_NOTSUPP},
+		{"rename", nfs.ProcRename, []interface{}{where("a.txt"), where("b.txt")}, nfs.NFS3_OK},
+		{"create exclusive replaced", nfs.ProcCreate, []interface{}{where("b.txt"), nfs.EXCLUSIVE, uint64(7)}, nfs.NFS3ERR_EXIST},
+		{"rename gone", nfs.ProcRename, []interface{}{where("a.txt"), where("c.txt")}, nfs.NFS3ERR_NOENT},
+		{"rename onto a full dir", nfs.ProcRename, []interface{}{where("d"), where("e")}, nfs.NFS3ERR_NOTEMPTY},
+		{"rmdir file", nfs.ProcRmdir, []interface{}{where("b.txt")}, nfs.NFS3ERR_NOTDIR},
+		{"remove dir", nfs.ProcRemove, []interface{}{where("d")}, nfs.NFS3ERR_ISDIR},
+		{"rmdir", nfs.ProcRmdir, []interface{}{where("d")}, nfs.NFS3_OK},
+		{"remove", nfs.ProcRemove, []interface{}{where("b.txt")}, nfs.NFS3_OK},
+		{"remove gone", nfs.ProcRemove, []interface{}{where("b.txt")}, nfs.NFS3ERR_NOENT},
+	}
+	for i, c := range calls {
+		conn.Write(callRecord(uint32(i+1), nfs.PROG_NFS, 3, c.proc, c.args...))
+		_, stat, r := readReplyBody(t, conn)
+		if stat != nfs.ACCEPT_SUCCESS {
+			t.Fatalf("%s: stat=%d", c.name, stat)
+		}
+		if status, _ := r.ReadUint32(); status != c.status {
+			t.Fatalf("%s: expects %d but get %d", c.name, c.status, status)
+		}
+
+		if c.name == "create" {
+			follows := false
+			fh := []byte{}
+			r.ReadAs(&follows)
+			r.ReadAs(&fh)
+			pathName, err := mfs.ResolveHandle(fh)
+			if !follows || err != nil || pathName != "/a.txt" {
+				t.Fatalf("create: unexpected handle %x: %s, %v", fh, pathName, err)
+			}
+		}
+	}
+
+	if _, err := mfs.Stat("/a.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expects /a.txt to be renamed: %v", err)
+	}
+	if _, err := mfs.Stat("/d"); !os.IsNotExist(err) {
+		t.Fatalf("expects /d to be removed: %v", err)
+	}
+	if _, err := mfs.Stat("/e/f"); err != nil {
+		t.Fatalf("expects /e/f to be left: %v", err)
+	}
+	f, err := mfs.Open("/")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer f.Close()
+	if infos, _ := f.Readdir(-1); len(infos) != 1 || infos[0].Name() != "e" {
+		t.Fatalf("expects /e only to be left in /, but get %d entries", len(infos))
+	}
+}
+
+func TestMuxV3SetAttrReaddir(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		f, err := mfs.OpenFile(name, os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		f.Write([]byte("hello world"))
+		f.Close()
+	}
+	fi, _ := mfs.Stat("/a.txt")
+	fh, _ := mfs.GetHandle(fi)
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// sattr3 setting the size only.
+	sattr := []interface{}{false, false, false, true, uint64(5), nfs.DONT_CHANGE, nfs.DONT_CHANGE}
+
+	// setattr guarded by a wrong ctime.
+	args := append([]interface{}{fh}, sattr...)
+	conn.Write(callRecord(1, nfs.PROG_NFS, 3, nfs.ProcSetAttr, append(args, true, &nfs.NFSTime{Seconds: 1})...))
+	_, _, r := readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3ERR_NOT_SYNC {
+		t.Fatalf("setattr: expects NFS3ERR_NOT_SYNC but get %d", status)
+	}
+
+	conn.Write(callRecord(2, nfs.PROG_NFS, 3, nfs.ProcSetAttr, append(args, false)...))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("setattr: expects NFS3_OK but get %d", status)
+	}
+	if fi, _ := mfs.Stat("/a.txt"); fi.Size() != 5 {
+		t.Fatalf("setattr: expects size 5 but get %d", fi.Size())
+	}
+
+	// setattr of the mtime of the client, which can't be set.
+	conn.Write(callRecord(100, nfs.PROG_NFS, 3, nfs.ProcSetAttr,
+		fh, false, false, false, true, uint64(3), nfs.DONT_CHANGE,
+		nfs.SET_TO_CLIENT_TIME, &nfs.NFSTime{Seconds: 1}, false,
+	))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3ERR_NOTSUPP {
+		t.Fatalf("setattr: expects NFS3ERR_NOTSUPP but get %d", status)
+	}
+	if fi, _ := mfs.Stat("/a.txt"); fi.Size() != 5 {
+		t.Fatalf("setattr: expects size 5 but get %d", fi.Size())
+	}
+
+	// setattr of the size and of the mtime of the server.
+	conn.Write(callRecord(101, nfs.PROG_NFS, 3, nfs.ProcSetAttr,
+		fh, false, false, false, true, uint64(3), nfs.DONT_CHANGE, nfs.SET_TO_SERVER_TIME, false,
+	))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("setattr: expects NFS3_OK but get %d", status)
+	}
+	if fi, _ := mfs.Stat("/a.txt"); fi.Size() != 3 {
+		t.Fatalf("setattr: expects size 3 but get %d", fi.Size())
+	}
+
+	// readdir, an entry at a time.
+	names := []string{}
+	cookie := uint64(0)
+	for xid := uint32(3); ; xid++ {
+		conn.Write(callRecord(xid, nfs.PROG_NFS, 3, nfs.ProcReaddir, &nfs.READDIR3args{
+			Dir:    mfs.GetRootHandle(),
+			Cookie: cookie,
+			Count:  160,
+		}))
+		_, _, r = readReplyBody(t, conn)
+		if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+			t.Fatalf("readdir: expects NFS3_OK but get %d", status)
+		}
+		skipPostOpAttr(t, r)
+		verf := uint64(0)
+		r.ReadAs(&verf)
+
+		for {
+			follows := false
+			r.ReadAs(&follows)
+			if !follows {
+				break
+			}
+			entry := &nfs.Entry3{}
+			if _, err := r.ReadAs(entry); err != nil {
+				t.Fatalf("read entry3: %v", err)
+			}
+			names = append(names, entry.Name)
+			cookie = entry.Cookie
+		}
+		eof := false
+		r.ReadAs(&eof)
+		if eof {
+			break
+		}
+		if xid > 10 {
+			t.Fatalf("readdir: no eof")
+		}
+	}
+	if len(names) != 3 {
+		t.Fatalf("readdir: unexpected entries: %v", names)
+	}
+}
+
+func TestMuxV3ReaddirPlus(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	for _, name := range []string{"/a.txt", "/b.txt", "/c.txt"} {
+		f, err := mfs.OpenFile(name, os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		f.Close()
+	}
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// readdirplus, an entry at a time, removing the entries as they are
+	// listed, like rm -rf.
+	names := []string{}
+	cookie := uint64(0)
+	for xid := uint32(1); ; xid++ {
+		conn.Write(callRecord(xid, nfs.PROG_NFS, 3, nfs.ProcReaddirPlus, &nfs.READDIRPLUS3args{
+			Dir:      mfs.GetRootHandle(),
+			Cookie:   cookie,
+			DirCount: 300,
+			MaxCount: 300,
+		}))
+		_, _, r := readReplyBody(t, conn)
+		if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+			t.Fatalf("readdirplus: expects NFS3_OK but get %d", status)
+		}
+		skipPostOpAttr(t, r)
+		verf := uint64(0)
+		r.ReadAs(&verf)
+
+		count := 0
+		for {
+			follows := false
+			r.ReadAs(&follows)
+			if !follows {
+				break
+			}
+			entry := &nfs.EntryPlus3{}
+			if _, err := r.ReadAs(entry); err != nil {
+				t.Fatalf("read entryplus3: %v", err)
+			}
+			names = append(names, entry.Name)
+			cookie = entry.Cookie
+			count++
+			if err := mfs.Remove("/" + entry.Name); err != nil {
+				t.Fatalf("Remove: %v", err)
+			}
+		}
+		if count != 1 {
+			t.Fatalf("readdirplus: %d entries in a reply", count)
+		}
+		eof := false
+		r.ReadAs(&eof)
+		if eof {
+			break
+		}
+		if xid > 10 {
+			t.Fatalf("readdirplus: no eof")
+		}
+	}
+	if len(names) != 3 {
+		t.Fatalf("readdirplus: unexpected entries: %v", names)
+	}
+
+	// A cookie never issued is invalid.
+	conn.Write(callRecord(20, nfs.PROG_NFS, 3, nfs.ProcReaddirPlus, &nfs.READDIRPLUS3args{
+		Dir:      mfs.GetRootHandle(),
+		Cookie:   cookie + 100,
+		DirCount: 300,
+		MaxCount: 300,
+	}))
+	_, _, r := readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3ERR_BAD_COOKIE {
+		t.Fatalf("readdirplus: expects NFS3ERR_BAD_COOKIE but get %d", status)
+	}
+}
+
+func TestMuxV3Attributes(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	if err := mfs.MkdirAll("/d", os.FileMode(0o755)); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	f, err := mfs.OpenFile("/d/a.txt", os.O_CREATE|os.O_RDWR, os.FileMode(0o640))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	di, _ := mfs.Stat("/d")
+	dh, _ := mfs.GetHandle(di)
+	fi, _ := mfs.Stat("/d/a.txt")
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// lookup
+	conn.Write(callRecord(1, nfs.PROG_NFS, 3, nfs.ProcLookup, &nfs.DirOpArgs3{
+		Dir:      dh,
+		Filename: "a.txt",
+	}))
+	_, _, r := readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("lookup: expects NFS3_OK but get %d", status)
+	}
+	fh := []byte{}
+	r.ReadAs(&fh)
+	if pathName, err := mfs.ResolveHandle(fh); err != nil || pathName != "/d/a.txt" {
+		t.Fatalf("lookup: unexpected handle %x: %s, %v", fh, pathName, err)
+	}
+
+	// getattr
+	conn.Write(callRecord(2, nfs.PROG_NFS, 3, nfs.ProcGetAttr, fh))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("getattr: expects NFS3_OK but get %d", status)
+	}
+	attrs := &nfs.FileAttrs{}
+	r.ReadAs(attrs)
+	if attrs.Type != nfs.FTYPE_NF3REG || attrs.Mode != 0o640 || attrs.Size != 5 {
+		t.Fatalf("getattr: unexpected attributes: %+v", attrs)
+	}
+	if attrs.FileId != mfs.GetFileId(fi) || attrs.NLink != uint32(fi.NumLinks()) {
+		t.Fatalf("getattr: unexpected attributes: %+v", attrs)
+	}
+
+	// a handle not known
+	conn.Write(callRecord(3, nfs.PROG_NFS, 3, nfs.ProcGetAttr, []byte{0, 0, 0, 0, 0, 0, 0x10, 0}))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3ERR_STALE {
+		t.Fatalf("getattr: expects NFS3ERR_STALE but get %d", status)
+	}
+}
+
+func TestMuxV3Wcc(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	f, err := mfs.OpenFile("/hello.txt", os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	fi, _ := mfs.Stat("/hello.txt")
+	fh, _ := mfs.GetHandle(fi)
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(callRecord(1, nfs.PROG_NFS, 3, nfs.ProcWrite, &nfs.WRITE3args{
+		File:   fh,
+		Offset: 5,
+		Count:  6,
+		Stable: nfs.FILE_SYNC,
+		Data:   []byte(" world"),
+	}))
+	_, _, r := readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("write: expects NFS3_OK but get %d", status)
+	}
+	before, after := readWccData(t, r)
+	if before == nil || after == nil || before.Size != 5 || after.Size != 11 {
+		t.Fatalf("write: unexpected wcc_data: %+v, %+v", before, after)
+	}
+
+	// the directory of a file created.
+	sattr := []interface{}{false, false, false, false, nfs.DONT_CHANGE, nfs.DONT_CHANGE}
+	args := []interface{}{&nfs.DirOpArgs3{Dir: mfs.GetRootHandle(), Filename: "a.txt"}, nfs.UNCHECKED}
+	conn.Write(callRecord(2, nfs.PROG_NFS, 3, nfs.ProcCreate, append(args, sattr...)...))
+	_, _, r = readReplyBody(t, conn)
+	if status, _ := r.ReadUint32(); status != nfs.NFS3_OK {
+		t.Fatalf("create: expects NFS3_OK but get %d", status)
+	}
+	follows := false
+	r.ReadAs(&follows)
+	r.ReadAs(&[]byte{})
+	skipPostOpAttr(t, r)
+	before, after = readWccData(t, r)
+	if before == nil || after == nil || after.MTime == before.MTime {
+		t.Fatalf("create: unexpected dir_wcc: %+v, %+v", before, after)
+	}
+}
+
+// readWccData reads a wcc_data: nil for the attributes not given.
+func readWccData(t *testing.T, r *xdr.Reader) (*nfs.WccAttr, *nfs.FileAttrs) {
+	var before *nfs.WccAttr
+	var after *nfs.FileAttrs
+
+	follows := false
+	if _, err := r.ReadAs(&follows); err != nil {
+		t.Fatalf("read pre_op_attr: %v", err)
+	}
+	if follows {
+		before = &nfs.WccAttr{}
+		if _, err := r.ReadAs(before); err != nil {
+			t.Fatalf("read pre_op_attr: %v", err)
+		}
+	}
+	if _, err := r.ReadAs(&follows); err != nil {
+		t.Fatalf("read post_op_attr: %v", err)
+	}
+	if follows {
+		after = &nfs.FileAttrs{}
+		if _, err := r.ReadAs(after); err != nil {
+			t.Fatalf("read post_op_attr: %v", err)
+		}
+	}
+	return before, after
+}
+
+func skipPostOpAttr(t *testing.T, r *xdr.Reader) {
+	follows := false
+	if _, err := r.ReadAs(&follows); err != nil {
+		t.Fatalf("read post_op_attr: %v", err)
+	}
+	if follows {
+		if _, err := r.ReadAs(&nfs.FileAttrs{}); err != nil {
+			t.Fatalf("read post_op_attr: %v", err)
+		}
+	}
+}
+
+func skipWccData(t *testing.T, r *xdr.Reader) {
+	follows := false
+	if _, err := r.ReadAs(&follows); err != nil {
+		t.Fatalf("read pre_op_attr: %v", err)
+	}
+	if follows {
+		if _, err := r.ReadAs(&nfs.WccAttr{}); err != nil {
+			t.Fatalf("read pre_op_attr: %v", err)
+		}
+	}
+	skipPostOpAttr(t, r)
+}
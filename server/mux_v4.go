@@ -15,9 +15,13 @@ type Muxv4 struct {
 	auth   nfs.AuthenticationHandler
 	fs     fs.FS
 	stat   nfs.StatService
+	state  *v4.State
 }
 
-var _ nfs.RPCContext = (*Muxv4)(nil)
+var (
+	_ nfs.RPCContext = (*Muxv4)(nil)
+	_ v4.StateHolder = (*Muxv4)(nil)
+)
 
 func (x *Muxv4) Reader() *xdr.Reader {
 	return x.reader
@@ -45,6 +49,10 @@ func (x *Muxv4) GetFS() fs.FS {
 	return x.fs
 }
 
+func (x *Muxv4) State() *v4.State {
+	return x.state
+}
+
 func (x *Muxv4) HandleProc(h *nfs.RPCMsgCall) (int, error) {
 	// Clear authentication
 
@@ -54,5 +62,5 @@ func (x *Muxv4) HandleProc(h *nfs.RPCMsgCall) (int, error) {
 	case nfs.PROC4_COMPOUND:
 		return v4.Compound(h, x)
 	}
-	return 0, fmt.Errorf("not implemented: %s", nfs.Proc4Name(h.Proc))
+	return 0, fmt.Errorf("%w: %s", nfs.ErrProcUnavail, nfs.Proc4Name(h.Proc))
 }
@@ -0,0 +1,1042 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// op4 is an operation of a COMPOUND call.
+type op4 struct {
+	op   uint32
+	args []interface{}
+}
+
+func newOp4(op uint32, args ...interface{}) op4 {
+	return op4{op: op, args: args}
+}
+
+// compound sends a COMPOUND call and returns its status along with a
+// reader of the results.
+func compound(t *testing.T, conn net.Conn, xid uint32, ops ...op4) (uint32, *xdr.Reader) {
+	args := []interface{}{"", uint32(0), uint32(len(ops))}
+	for _, op := range ops {
+		args = append(args, op.op)
+		args = append(args, op.args...)
+	}
+	conn.Write(callRecord(xid, nfs.PROG_NFS, 4, nfs.PROC4_COMPOUND, args...))
+
+	_, stat, r := readReplyBody(t, conn)
+	if stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("compound: stat=%d", stat)
+	}
+	status, _ := r.ReadUint32()
+	tag := ""
+	r.ReadAs(&tag)
+	r.ReadUint32() // count of results.
+	return status, r
+}
+
+// result reads the opnum and status of the next result of a COMPOUND.
+func result(t *testing.T, r *xdr.Reader, op uint32) uint32 {
+	opnum, err := r.ReadUint32()
+	if err != nil {
+		t.Fatalf("read opnum: %v", err)
+	}
+	if opnum != op {
+		t.Fatalf("expects result of %s but get %s", nfs.Proc4Name(op), nfs.Proc4Name(opnum))
+	}
+	status, err := r.ReadUint32()
+	if err != nil {
+		t.Fatalf("read status: %v", err)
+	}
+	return status
+}
+
+func expectsResult(t *testing.T, r *xdr.Reader, op, expected uint32) {
+	if status := result(t, r, op); status != expected {
+		t.Fatalf("%s: expects status %d but get %d", nfs.Proc4Name(op), expected, status)
+	}
+}
+
+// setClientId registers and confirms a client.
+func setClientId(t *testing.T, conn net.Conn, xid uint32, id string) uint64 {
+	return setClientIdCallback(t, conn, xid, id, "127.0.0.1.0.0")
+}
+
+// setClientIdCallback is like setClientId, with the universal address of
+// the callback of the client.
+func setClientIdCallback(t *testing.T, conn net.Conn, xid uint32, id, uaddr string) uint64 {
+	_, r := compound(t, conn, xid, newOp4(nfs.OP4_SETCLIENTID, &nfs.SETCLIENTID4args{
+		Client: &nfs.NfsClientId4{Verifier: 1, Id: []byte(id)},
+		Callback: &nfs.CbClient4{
+			CbProgram:  0x40000000,
+			CbLocation: &nfs.ClientAddr4{NetId: "tcp", Addr: uaddr},
+		},
+	}))
+	expectsResult(t, r, nfs.OP4_SETCLIENTID, nfs.NFS4_OK)
+	res := &nfs.SETCLIENTID4resok{}
+	if _, err := r.ReadAs(res); err != nil {
+		t.Fatalf("read setclientid4resok: %v", err)
+	}
+
+	_, r = compound(t, conn, xid+1, newOp4(nfs.OP4_SETCLIENTID_CONFIRM, &nfs.SETCLIENTID_CONFIRM4args{
+		ClientId: res.ClientId,
+		Verifier: res.SetClientIdConfirm,
+	}))
+	expectsResult(t, r, nfs.OP4_SETCLIENTID_CONFIRM, nfs.NFS4_OK)
+	return res.ClientId
+}
+
+// owner4 is an open-owner, counting its seqids.
+type owner4 struct {
+	clientId uint64
+	name     string
+	seqId    uint32
+}
+
+func (o *owner4) next() uint32 {
+	o.seqId++
+	return o.seqId
+}
+
+// openArgs returns the args of an OPEN creating a file in the current
+// directory if needed.
+func openArgs(o *owner4, name string, access, deny uint32) []interface{} {
+	return []interface{}{
+		o.next(),
+		access,
+		deny,
+		&nfs.OpenOwner4{ClientId: o.clientId, Owner: o.name},
+		nfs.OPEN4_CREATE,
+		nfs.UNCHECKED4,
+		&nfs.FAttr4{Mask: []uint32{}, Vals: []byte{}},
+		nfs.CLAIM_NULL,
+		name,
+	}
+}
+
+// readOpenResult reads the stateid of an OPEN4resok, skipping the rest.
+func readOpenResult(t *testing.T, r *xdr.Reader) (*nfs.StateId4, uint32) {
+	sid, rflags, delegation := readOpenDelegation(t, r)
+	if delegation.Type != nfs.OPEN_DELEGATE_NONE {
+		t.Fatalf("unexpected delegation: %d", delegation.Type)
+	}
+	return sid, rflags
+}
+
+// readOpenDelegation is like readOpenResult, for an OPEN which may
+// delegate the file.
+func readOpenDelegation(t *testing.T, r *xdr.Reader) (*nfs.StateId4, uint32, *nfs.OpenDelegation4) {
+	sid := &nfs.StateId4{}
+	if _, err := r.ReadAs(sid); err != nil {
+		t.Fatalf("read stateid: %v", err)
+	}
+	cinfo := &nfs.ChangeInfo4{}
+	r.ReadAs(cinfo)
+	rflags, _ := r.ReadUint32()
+	attrset := []uint32{}
+	r.ReadAs(&attrset)
+
+	delegation := &nfs.OpenDelegation4{}
+	delegation.Type, _ = r.ReadUint32()
+	switch delegation.Type {
+	case nfs.OPEN_DELEGATE_NONE:
+	case nfs.OPEN_DELEGATE_READ:
+		delegation.Read = &nfs.OpenReadDelegation4{}
+		r.ReadAs(delegation.Read)
+	default:
+		t.Fatalf("unexpected delegation: %d", delegation.Type)
+	}
+	return sid, rflags, delegation
+}
+
+// open opens a file of the root, returning its handle and the stateid.
+// The open is confirmed if needed.
+func open(t *testing.T, conn net.Conn, xid uint32, o *owner4, name string) ([]byte, *nfs.StateId4) {
+	return openShare(t, conn, xid, o, name, nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+}
+
+// openShare is like open, with a share reservation. The OPEN is expected
+// to end with a status.
+func openShare(t *testing.T, conn net.Conn, xid uint32, o *owner4, name string, access, deny, st uint32) ([]byte, *nfs.StateId4) {
+	fh, sid, delegation := openDelegation(t, conn, xid, o, name, access, deny, st)
+	if delegation != nil && delegation.Type != nfs.OPEN_DELEGATE_NONE {
+		t.Fatalf("unexpected delegation: %d", delegation.Type)
+	}
+	return fh, sid
+}
+
+// openDelegation is like openShare, for an OPEN which may delegate the
+// file.
+func openDelegation(t *testing.T, conn net.Conn, xid uint32, o *owner4, name string, access, deny, st uint32) ([]byte, *nfs.StateId4, *nfs.OpenDelegation4) {
+	_, r := compound(t, conn, xid,
+		newOp4(nfs.OP4_PUTROOTFH),
+		newOp4(nfs.OP4_OPEN, openArgs(o, name, access, deny)...),
+		newOp4(nfs.OP4_GETFH),
+	)
+	expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_OPEN, st)
+	if st != nfs.NFS4_OK {
+		return nil, nil, nil
+	}
+	sid, rflags, delegation := readOpenDelegation(t, r)
+	expectsResult(t, r, nfs.OP4_GETFH, nfs.NFS4_OK)
+	fh := []byte{}
+	if _, err := r.ReadAs(&fh); err != nil {
+		t.Fatalf("read fh: %v", err)
+	}
+
+	if rflags&nfs.OPEN4_RESULT_CONFIRM != 0 {
+		_, r = compound(t, conn, xid, newOp4(nfs.OP4_PUTFH, fh), newOp4(nfs.OP4_OPEN_CONFIRM, sid, o.next()))
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_OPEN_CONFIRM, nfs.NFS4_OK)
+		r.ReadAs(sid)
+	}
+	return fh, sid, delegation
+}
+
+func TestMuxV4Reconnect(t *testing.T) {
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+
+	o := &owner4{clientId: setClientId(t, conn, 1, "a"), name: "o"}
+	fh, sid := open(t, conn, 3, o, "f")
+
+	_, r := compound(t, conn, 4,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_WRITE, &nfs.WRITE4args{StateId: sid, Stable: nfs.FILE_SYNC4, Data: []byte("hello")}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_WRITE, nfs.NFS4_OK)
+	conn.Close()
+
+	// The client reconnects: the file is still opened.
+	conn, err = net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	_, r = compound(t, conn, 5,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_READ, &nfs.READ4args{StateId: sid, Count: 100}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_READ, nfs.NFS4_OK)
+	res := &nfs.READ4resok{}
+	if _, err := r.ReadAs(res); err != nil {
+		t.Fatalf("read read4resok: %v", err)
+	}
+	if string(res.Data) != "hello" {
+		t.Fatalf("unexpected data: %q", res.Data)
+	}
+
+	_, r = compound(t, conn, 6,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_CLOSE, o.next(), sid),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_CLOSE, nfs.NFS4_OK)
+}
+
+func TestMuxV4StateIds(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	o := &owner4{clientId: setClientId(t, conn, 1, "a"), name: "o"}
+	fh, sid := open(t, conn, 3, o, "f")
+	if sid.SeqId != 2 || sid.Other == [3]uint32{} {
+		t.Fatalf("unexpected stateid: %v", sid)
+	}
+
+	// Opening again upgrades the open.
+	_, sid2 := open(t, conn, 4, o, "f")
+	if sid2.Other != sid.Other || sid2.SeqId != 3 {
+		t.Fatalf("unexpected stateid after upgrade: %v", sid2)
+	}
+
+	xid := uint32(10)
+	read := func(fh []byte, sid *nfs.StateId4, expected uint32) {
+		xid++
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_READ, &nfs.READ4args{StateId: sid, Count: 10}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_READ, expected)
+	}
+
+	read(fh, sid2, nfs.NFS4_OK)
+	read(fh, sid, nfs.NFS4ERR_OLD_STATEID)
+	read(fh, &nfs.StateId4{SeqId: 4, Other: sid.Other}, nfs.NFS4ERR_BAD_STATEID)
+	read(fh, &nfs.StateId4{SeqId: 3, Other: [3]uint32{sid.Other[0], sid.Other[1], 0xff}}, nfs.NFS4ERR_BAD_STATEID)
+	read(fh, &nfs.StateId4{SeqId: 3, Other: [3]uint32{sid.Other[0] - 1, sid.Other[1], sid.Other[2]}}, nfs.NFS4ERR_STALE_STATEID)
+	read(mfs.GetRootHandle(), sid2, nfs.NFS4ERR_BAD_STATEID)
+
+	// The stateids are not derived from the client id nor from each
+	// other.
+	_, sidg := open(t, conn, 5, o, "g")
+	if sidg.Other[0] != sid.Other[0] || sidg.Other[1] == uint32(o.clientId) || sidg.Other[2] == sid.Other[2]+1 {
+		t.Fatalf("guessable stateid: %v after %v", sidg, sid)
+	}
+
+	// Special stateids.
+	read(fh, &nfs.StateId4{}, nfs.NFS4_OK)
+	read(fh, &nfs.StateId4{SeqId: 0xffffffff, Other: [3]uint32{0xffffffff, 0xffffffff, 0xffffffff}}, nfs.NFS4_OK)
+
+	_, r := compound(t, conn, 20,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_WRITE, &nfs.WRITE4args{StateId: &nfs.StateId4{}, Stable: nfs.FILE_SYNC4, Data: []byte("x")}),
+		newOp4(nfs.OP4_OPEN_DOWNGRADE, &nfs.OPENDG4args{
+			OpenStateId: sid2,
+			SeqId:       o.next(),
+			ShareAccess: nfs.OPEN4_SHARE_ACCESS_READ,
+			ShareDeny:   nfs.OPEN4_SHARE_DENY_NONE,
+		}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_WRITE, nfs.NFS4_OK)
+	r.ReadAs(&nfs.WRITE4resok{})
+	expectsResult(t, r, nfs.OP4_OPEN_DOWNGRADE, nfs.NFS4_OK)
+	sid3 := &nfs.StateId4{}
+	r.ReadAs(sid3)
+	if sid3.Other != sid.Other || sid3.SeqId != 4 {
+		t.Fatalf("unexpected stateid after downgrade: %v", sid3)
+	}
+
+	_, r = compound(t, conn, 21,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_CLOSE, o.next(), sid3),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_CLOSE, nfs.NFS4_OK)
+
+	read(fh, sid3, nfs.NFS4ERR_BAD_STATEID)
+}
+
+func TestMuxV4OpenOwnerSeqIds(t *testing.T) {
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	o := &owner4{clientId: setClientId(t, conn, 1, "a"), name: "o"}
+
+	openf := func(xid, seqId uint32) (*nfs.StateId4, uint32) {
+		o.seqId = seqId - 1
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTROOTFH),
+			newOp4(nfs.OP4_OPEN, openArgs(o, "f", nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_NONE)...),
+		)
+		expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_OPEN, nfs.NFS4_OK)
+		return readOpenResult(t, r)
+	}
+
+	sid, rflags := openf(3, 7)
+	if rflags&nfs.OPEN4_RESULT_CONFIRM == 0 {
+		t.Fatalf("open of a new owner should ask for a confirm")
+	}
+
+	// A retransmitted OPEN gets the same reply.
+	sid1, _ := openf(4, 7)
+	if *sid1 != *sid {
+		t.Fatalf("replayed open differs: %v, %v", sid1, sid)
+	}
+
+	fh, _ := open(t, conn, 5, &owner4{clientId: o.clientId, name: "p"}, "f")
+
+	confirm := func(xid, seqId uint32, st uint32) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_OPEN_CONFIRM, sid, seqId),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_OPEN_CONFIRM, st)
+	}
+	confirm(6, 9, nfs.NFS4ERR_BAD_SEQID)
+	confirm(7, 8, nfs.NFS4_OK)
+	confirm(8, 8, nfs.NFS4_OK)
+
+	// Confirmed owners are sequenced on OPEN too.
+	_, r := compound(t, conn, 9,
+		newOp4(nfs.OP4_PUTROOTFH),
+		newOp4(nfs.OP4_OPEN, openArgs(&owner4{clientId: o.clientId, name: "o", seqId: 2}, "f", nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_NONE)...),
+	)
+	expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_OPEN, nfs.NFS4ERR_BAD_SEQID)
+
+	sid.SeqId++
+	closef := func(xid, seqId uint32, st uint32) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_CLOSE, seqId, sid),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_CLOSE, st)
+	}
+	closef(10, 9, nfs.NFS4_OK)
+	closef(11, 9, nfs.NFS4_OK)
+	closef(12, 10, nfs.NFS4ERR_BAD_STATEID)
+}
+
+func TestMuxV4ShareReservations(t *testing.T) {
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	a := &owner4{clientId: setClientId(t, conn, 1, "a"), name: "o"}
+	b := &owner4{clientId: setClientId(t, conn, 3, "b"), name: "o"}
+
+	fh, sid := openShare(t, conn, 5, a, "f", nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_WRITE, nfs.NFS4_OK)
+
+	openShare(t, conn, 6, b, "f", nfs.OPEN4_SHARE_ACCESS_WRITE, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4ERR_SHARE_DENIED)
+	openShare(t, conn, 7, b, "f", nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_READ, nfs.NFS4ERR_SHARE_DENIED)
+	openShare(t, conn, 8, b, "f", nfs.OPEN4_SHARE_ACCESS_READ, 0x10, nfs.NFS4ERR_INVAL)
+	_, sidb := openShare(t, conn, 9, b, "f", nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+
+	write := func(xid uint32, sid *nfs.StateId4, st uint32) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_WRITE, &nfs.WRITE4args{StateId: sid, Stable: nfs.FILE_SYNC4, Data: []byte("x")}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_WRITE, st)
+	}
+	write(10, sid, nfs.NFS4_OK)
+	write(11, sidb, nfs.NFS4ERR_OPENMODE)
+	write(12, &nfs.StateId4{}, nfs.NFS4ERR_LOCKED)
+
+	downgrade := func(xid uint32, access, deny, st uint32) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_OPEN_DOWNGRADE, &nfs.OPENDG4args{
+				OpenStateId: sid,
+				SeqId:       a.next(),
+				ShareAccess: access,
+				ShareDeny:   deny,
+			}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_OPEN_DOWNGRADE, st)
+		if st == nfs.NFS4_OK {
+			r.ReadAs(sid)
+		}
+	}
+	downgrade(13, nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_BOTH, nfs.NFS4ERR_INVAL)
+	downgrade(14, nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+
+	write(15, sid, nfs.NFS4ERR_OPENMODE)
+	write(16, &nfs.StateId4{}, nfs.NFS4_OK)
+	openShare(t, conn, 17, b, "f", nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+}
+
+func TestMuxV4ChangeInfo(t *testing.T) {
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// change returns the change attribute of the root.
+	change := func(xid uint32) uint64 {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTROOTFH),
+			newOp4(nfs.OP4_GETATTR, []uint32{1 << 3}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_GETATTR, nfs.NFS4_OK)
+		attrs := &nfs.FAttr4{}
+		r.ReadAs(attrs)
+		v := uint64(0)
+		xdr.NewReader(bytes.NewReader(attrs.Vals)).ReadAs(&v)
+		return v
+	}
+
+	expectsChange := func(cinfo *nfs.ChangeInfo4, before uint64) uint64 {
+		if !cinfo.Atomic || cinfo.Before != before || cinfo.After <= cinfo.Before {
+			t.Fatalf("change_info4: %+v, expects change after %d", cinfo, before)
+		}
+		return cinfo.After
+	}
+
+	v := change(1)
+
+	_, r := compound(t, conn, 2,
+		newOp4(nfs.OP4_PUTROOTFH),
+		newOp4(nfs.OP4_CREATE, nfs.NF4DIR, "d", &nfs.FAttr4{Mask: []uint32{}, Vals: []byte{}}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_CREATE, nfs.NFS4_OK)
+	cinfo := &nfs.ChangeInfo4{}
+	r.ReadAs(cinfo)
+	v = expectsChange(cinfo, v)
+	if c := change(3); c != v {
+		t.Fatalf("change: %d, expects %d", c, v)
+	}
+
+	_, r = compound(t, conn, 4,
+		newOp4(nfs.OP4_PUTROOTFH),
+		newOp4(nfs.OP4_REMOVE, "d"),
+	)
+	expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_REMOVE, nfs.NFS4_OK)
+	r.ReadAs(cinfo)
+	v = expectsChange(cinfo, v)
+
+	o := &owner4{clientId: setClientId(t, conn, 5, "a"), name: "o"}
+	_, r = compound(t, conn, 7,
+		newOp4(nfs.OP4_PUTROOTFH),
+		newOp4(nfs.OP4_OPEN, openArgs(o, "f", nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_NONE)...),
+	)
+	expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_OPEN, nfs.NFS4_OK)
+	r.ReadAs(&nfs.StateId4{})
+	r.ReadAs(cinfo)
+	expectsChange(cinfo, v)
+}
+
+func TestMuxV4Readdir(t *testing.T) {
+	mfs := memfs.NewMemFS()
+	names := []string{"a", "b", "c", "d", "e", "f"}
+	for _, name := range names {
+		f, err := mfs.OpenFile("/"+name, os.O_CREATE|os.O_RDWR, os.FileMode(0o644))
+		if err != nil {
+			t.Fatalf("OpenFile: %v", err)
+		}
+		f.Close()
+	}
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// readdir lists two entries from a cookie.
+	readdir := func(xid uint32, cookie, verf uint64, st uint32) ([]*nfs.Entry4, uint64, bool) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTROOTFH),
+			newOp4(nfs.OP4_READDIR, &nfs.READDIR4args{
+				Cookie:      cookie,
+				CookieVerf:  verf,
+				DirCount:    20,
+				MaxCount:    8192,
+				AttrRequest: []uint32{},
+			}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTROOTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_READDIR, st)
+		if st != nfs.NFS4_OK {
+			return nil, 0, false
+		}
+		r.ReadAs(&verf)
+		entries := []*nfs.Entry4{}
+		more, eof := false, false
+		for r.ReadAs(&more); more; more = entries[len(entries)-1].HasNext {
+			entry := &nfs.Entry4{}
+			if _, err := r.ReadAs(entry); err != nil {
+				t.Fatalf("read entry: %v", err)
+			}
+			entries = append(entries, entry)
+		}
+		r.ReadAs(&eof)
+		return entries, verf, eof
+	}
+
+	entries, verf, eof := readdir(1, 0, 0, nfs.NFS4_OK)
+	if len(entries) != 2 || eof {
+		t.Fatalf("readdir: %d entries, eof=%v", len(entries), eof)
+	}
+	listed := map[string]bool{}
+	for _, entry := range entries {
+		listed[entry.Name] = true
+	}
+
+	// An entry not listed yet is removed: the entries following the
+	// cookie don't move, but the cookie verifier changes.
+	removed := ""
+	for _, name := range names {
+		if !listed[name] {
+			removed = name
+			break
+		}
+	}
+	if err := mfs.Remove("/" + removed); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	cookie := entries[len(entries)-1].Cookie
+	readdir(2, cookie, verf, nfs.NFS4ERR_NOT_SAME)
+	readdir(3, 12345, 0, nfs.NFS4ERR_BAD_COOKIE)
+
+	_, verf, _ = readdir(4, 0, 0, nfs.NFS4_OK)
+	for xid := uint32(5); !eof; xid++ {
+		entries, _, eof = readdir(xid, cookie, verf, nfs.NFS4_OK)
+		for _, entry := range entries {
+			if listed[entry.Name] {
+				t.Fatalf("readdir: %s listed twice", entry.Name)
+			}
+			listed[entry.Name] = true
+			cookie = entry.Cookie
+		}
+	}
+	if len(listed) != len(names)-1 || listed[removed] {
+		t.Fatalf("readdir: %v listed, %s removed", listed, removed)
+	}
+}
+
+func TestMuxV4WriteVerifier(t *testing.T) {
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	o := &owner4{clientId: setClientId(t, conn, 1, "a"), name: "o"}
+	fh, sid := open(t, conn, 3, o, "f")
+
+	_, r := compound(t, conn, 5,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_WRITE, &nfs.WRITE4args{StateId: sid, Stable: nfs.UNSTABLE4, Data: []byte("x")}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_WRITE, nfs.NFS4_OK)
+	res := &nfs.WRITE4resok{}
+	r.ReadAs(res)
+	if res.WriteVerf == 0 || res.WriteVerf != nfs.WriteVerifier() {
+		t.Fatalf("write: verifier %x, expects %x", res.WriteVerf, nfs.WriteVerifier())
+	}
+
+	commit := func(xid uint32) uint64 {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_COMMIT, &nfs.COMMIT4args{}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_COMMIT, nfs.NFS4_OK)
+		v := uint64(0)
+		r.ReadAs(&v)
+		return v
+	}
+	if v := commit(6); v != res.WriteVerf {
+		t.Fatalf("commit: verifier %x, expects %x", v, res.WriteVerf)
+	}
+
+	// The data written UNSTABLE is lost: the client has to send it
+	// again.
+	nfs.ResetWriteVerifier()
+	if v := commit(7); v == res.WriteVerf {
+		t.Fatalf("commit: verifier unchanged after a loss of data")
+	}
+}
+
+func TestMuxV4Locks(t *testing.T) {
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	a := &owner4{clientId: setClientId(t, conn, 1, "a"), name: "o"}
+	b := &owner4{clientId: setClientId(t, conn, 3, "b"), name: "o"}
+
+	fh, sida := open(t, conn, 5, a, "f")
+	_, sidb := open(t, conn, 6, b, "f")
+
+	// lock locks a range with a new lock-owner of an open-owner.
+	lock := func(xid uint32, o *owner4, sid *nfs.StateId4, lockSeqId, lockType uint32, offset, length uint64, st uint32) *xdr.Reader {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_LOCK, &nfs.LOCK4args{
+				LockType: lockType,
+				Offset:   offset,
+				Length:   length,
+				Locker: &nfs.Locker4{
+					NewLockOwner: true,
+					OpenOwner: &nfs.OpenToLockOwner4{
+						OpenSeqId:   o.next(),
+						OpenStateId: sid,
+						LockSeqId:   lockSeqId,
+						LockOwner:   &nfs.LockOwner4{ClientId: o.clientId, Owner: "l"},
+					},
+				},
+			}),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_LOCK, st)
+		return r
+	}
+	denied := func(r *xdr.Reader, o *owner4) {
+		d := &nfs.LOCK4denied{}
+		if _, err := r.ReadAs(d); err != nil {
+			t.Fatalf("read LOCK4denied: %v", err)
+		}
+		if d.LockType != nfs.WRITE_LT || d.Offset != 0 || d.Length != 100 ||
+			d.Owner.ClientId != o.clientId || d.Owner.Owner != "l" {
+			t.Fatalf("unexpected conflict: %+v, %+v", d, d.Owner)
+		}
+	}
+
+	r := lock(7, a, sida, 0, nfs.WRITE_LT, 0, 100, nfs.NFS4_OK)
+	lsid := &nfs.StateId4{}
+	r.ReadAs(lsid)
+	if lsid.SeqId != 1 || lsid.Other == sida.Other {
+		t.Fatalf("unexpected lock stateid: %v", lsid)
+	}
+
+	r = lock(8, b, sidb, 0, nfs.READ_LT, 50, 10, nfs.NFS4ERR_DENIED)
+	denied(r, a)
+	lock(9, b, sidb, 1, nfs.READ_LT, 50, 0, nfs.NFS4ERR_INVAL)
+
+	// The compound stops at LOCKT denied: READ is not done.
+	status, r := compound(t, conn, 10,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_LOCKT, &nfs.LOCKT4args{
+			LockType: nfs.READ_LT,
+			Offset:   99,
+			Length:   ^uint64(0),
+			Owner:    &nfs.LockOwner4{ClientId: b.clientId, Owner: "l"},
+		}),
+		newOp4(nfs.OP4_READ, &nfs.READ4args{StateId: lsid, Offset: 0, Count: 10}),
+	)
+	if status != nfs.NFS4ERR_DENIED {
+		t.Fatalf("compound: expects status %d but get %d", nfs.NFS4ERR_DENIED, status)
+	}
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_LOCKT, nfs.NFS4ERR_DENIED)
+	denied(r, a)
+	if op, err := r.ReadUint32(); err == nil {
+		t.Fatalf("compound: unexpected result of %s", nfs.Proc4Name(op))
+	}
+
+	_, r = compound(t, conn, 20,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_READ, &nfs.READ4args{StateId: lsid, Offset: 0, Count: 10}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_READ, nfs.NFS4_OK)
+	r.ReadAs(&nfs.READ4resok{})
+
+	if locks := svr.Locks(); len(locks) != 1 || !locks[0].Exclusive {
+		t.Fatalf("unexpected locks: %v", locks)
+	}
+
+	_, r = compound(t, conn, 11,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_LOCKU, &nfs.LOCKU4args{
+			LockType:    nfs.WRITE_LT,
+			SeqId:       1,
+			LockStateId: lsid,
+			Offset:      0,
+			Length:      ^uint64(0),
+		}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_LOCKU, nfs.NFS4_OK)
+	r.ReadAs(lsid)
+	if lsid.SeqId != 2 {
+		t.Fatalf("unexpected lock stateid: %v", lsid)
+	}
+
+	lock(12, b, sidb, 2, nfs.READ_LT, 50, 10, nfs.NFS4_OK)
+
+	releaseOwner := func(xid uint32, st uint32) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_RELEASE_LOCKOWNER, &nfs.LockOwner4{ClientId: b.clientId, Owner: "l"}),
+		)
+		expectsResult(t, r, nfs.OP4_RELEASE_LOCKOWNER, st)
+	}
+	releaseOwner(13, nfs.NFS4ERR_LOCKS_HELD)
+
+	// Closing the file releases its locks.
+	_, r = compound(t, conn, 14,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_CLOSE, b.next(), sidb),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_CLOSE, nfs.NFS4_OK)
+
+	if locks := svr.Locks(); len(locks) != 0 {
+		t.Fatalf("unexpected locks: %v", locks)
+	}
+	releaseOwner(15, nfs.NFS4_OK)
+}
+
+// serveCallback answers the callbacks of a NFSv4 client, sending the
+// procedures called to procs and the delegations recalled to recalls.
+// As the Linux clients, it refuses AUTH_NULL but for CB_NULL.
+func serveCallback(l net.Listener, procs chan uint32, recalls chan *nfs.CB_RECALL4args) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		r := xdr.NewReader(conn)
+		frag, _ := r.ReadUint32()
+		dat, _ := r.ReadBytes(int(frag &^ lastFragment))
+		br := xdr.NewReader(bytes.NewReader(dat))
+		h := &nfs.RPCMsgCall{}
+		br.ReadAs(h)
+		procs <- h.Proc
+
+		body := bytes.NewBuffer([]byte{})
+		w := xdr.NewWriter(body)
+		if h.Proc != nfs.PROC4_CB_NULL && h.Cred.Flavor != nfs.AUTH_FLAVOR_UNIX {
+			w.WriteAny(&nfs.RPCMsgReply{
+				Xid:       h.Xid,
+				MsgType:   nfs.RPC_REPLY,
+				ReplyStat: nfs.MSG_DENIED,
+			})
+			w.WriteAny(nfs.REJECT_AUTH_ERROR)
+			w.WriteAny(nfs.AUTH_TOOWEAK)
+			xdr.NewWriter(conn).WriteUint32(uint32(body.Len()) | lastFragment)
+			conn.Write(body.Bytes())
+			conn.Close()
+			continue
+		}
+		w.WriteAny(&nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_ACCEPTED,
+		})
+		w.WriteAny(nfs.NewEmptyAuth())
+		w.WriteAny(nfs.ACCEPT_SUCCESS)
+
+		if h.Proc == nfs.PROC4_CB_COMPOUND {
+			br.ReadAs(&nfs.CB_COMPOUND4args{})
+			br.ReadUint32() // the operations
+			br.ReadUint32() // OP4_CB_RECALL
+			args := &nfs.CB_RECALL4args{}
+			br.ReadAs(args)
+			recalls <- args
+
+			w.WriteAny(&nfs.CB_COMPOUND4res{Status: nfs.NFS4_OK})
+			w.WriteAny([]uint32{nfs.OP4_CB_RECALL, nfs.NFS4_OK})
+		}
+
+		xdr.NewWriter(conn).WriteUint32(uint32(body.Len()) | lastFragment)
+		conn.Write(body.Bytes())
+		conn.Close()
+	}
+}
+
+func TestMuxV4Delegations(t *testing.T) {
+	cl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer cl.Close()
+	procs := make(chan uint32, 8)
+	recalls := make(chan *nfs.CB_RECALL4args, 8)
+	go serveCallback(cl, procs, recalls)
+
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	port := listenerPort(cl)
+	uaddr := fmt.Sprintf("127.0.0.1.%d.%d", port>>8, port&0xff)
+	a := &owner4{clientId: setClientIdCallback(t, conn, 1, "a", uaddr), name: "o"}
+	b := &owner4{clientId: setClientId(t, conn, 3, "b"), name: "o"}
+
+	select {
+	case proc := <-procs:
+		if proc != nfs.PROC4_CB_NULL {
+			t.Fatalf("unexpected callback: %d", proc)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("the callback is not probed")
+	}
+
+	// The file is delegated once the callback is known to be up.
+	fh, delegation := []byte(nil), (*nfs.OpenDelegation4)(nil)
+	for i := 0; i < 100; i++ {
+		fh, _, delegation = openDelegation(t, conn, 5, a, "f", nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+		if delegation.Type == nfs.OPEN_DELEGATE_READ {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if delegation.Type != nfs.OPEN_DELEGATE_READ {
+		t.Fatalf("the file is not delegated")
+	}
+	dsid := delegation.Read.StateId
+
+	_, r := compound(t, conn, 6,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_READ, &nfs.READ4args{StateId: dsid, Offset: 0, Count: 10}),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_READ, nfs.NFS4_OK)
+
+	// Another client opening the file for writing has to wait for the
+	// delegation to be returned.
+	openShare(t, conn, 7, b, "f", nfs.OPEN4_SHARE_ACCESS_WRITE, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4ERR_DELAY)
+	select {
+	case args := <-recalls:
+		if *args.StateId != *dsid || !bytes.Equal(args.Fh, fh) {
+			t.Fatalf("unexpected recall: %v", args.StateId)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("the delegation is not recalled")
+	}
+	openShare(t, conn, 8, b, "f", nfs.OPEN4_SHARE_ACCESS_WRITE, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4ERR_DELAY)
+
+	delegReturn := func(xid uint32, st uint32) {
+		_, r := compound(t, conn, xid,
+			newOp4(nfs.OP4_PUTFH, fh),
+			newOp4(nfs.OP4_DELEGRETURN, dsid),
+		)
+		expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+		expectsResult(t, r, nfs.OP4_DELEGRETURN, st)
+	}
+	delegReturn(9, nfs.NFS4_OK)
+	delegReturn(10, nfs.NFS4ERR_BAD_STATEID)
+
+	openShare(t, conn, 11, b, "f", nfs.OPEN4_SHARE_ACCESS_WRITE, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+
+	_, r = compound(t, conn, 12, newOp4(nfs.OP4_DELEGPURGE, a.clientId))
+	expectsResult(t, r, nfs.OP4_DELEGPURGE, nfs.NFS4_OK)
+
+	// Files opened for writing are not delegated.
+	if _, _, delegation := openDelegation(t, conn, 13, a, "w", nfs.OPEN4_SHARE_ACCESS_BOTH, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK); delegation.Type != nfs.OPEN_DELEGATE_NONE {
+		t.Fatalf("unexpected delegation: %d", delegation.Type)
+	}
+
+	select {
+	case args := <-recalls:
+		t.Fatalf("unexpected recall: %v", args.StateId)
+	default:
+	}
+}
+
+func TestMuxV4DelegationsRecalledByV3(t *testing.T) {
+	cl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer cl.Close()
+	procs := make(chan uint32, 8)
+	recalls := make(chan *nfs.CB_RECALL4args, 8)
+	go serveCallback(cl, procs, recalls)
+
+	svr := newTestServerFS(t, memfs.NewMemFS())
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	port := listenerPort(cl)
+	uaddr := fmt.Sprintf("127.0.0.1.%d.%d", port>>8, port&0xff)
+	a := &owner4{clientId: setClientIdCallback(t, conn, 1, "a", uaddr), name: "o"}
+	<-procs
+
+	fh, delegation := []byte(nil), (*nfs.OpenDelegation4)(nil)
+	for i := 0; i < 100; i++ {
+		fh, _, delegation = openDelegation(t, conn, 3, a, "f", nfs.OPEN4_SHARE_ACCESS_READ, nfs.OPEN4_SHARE_DENY_NONE, nfs.NFS4_OK)
+		if delegation.Type == nfs.OPEN_DELEGATE_READ {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if delegation.Type != nfs.OPEN_DELEGATE_READ {
+		t.Fatalf("the file is not delegated")
+	}
+
+	write := func(xid uint32) uint32 {
+		conn.Write(callRecord(xid, nfs.PROG_NFS, 3, nfs.ProcWrite, &nfs.WRITE3args{
+			File:   fh,
+			Count:  5,
+			Stable: nfs.FILE_SYNC,
+			Data:   []byte("hello"),
+		}))
+		_, _, r := readReplyBody(t, conn)
+		status, _ := r.ReadUint32()
+		return status
+	}
+
+	// A NFSv3 client writing the file has to wait for the delegation to
+	// be returned.
+	if status := write(4); status != nfs.NFS3ERR_JUKEBOX {
+		t.Fatalf("write: expects NFS3ERR_JUKEBOX but get %d", status)
+	}
+	select {
+	case args := <-recalls:
+		if *args.StateId != *delegation.Read.StateId {
+			t.Fatalf("unexpected recall: %v", args.StateId)
+		}
+	case <-time.After(time.Second * 2):
+		t.Fatalf("the delegation is not recalled")
+	}
+
+	_, r := compound(t, conn, 5,
+		newOp4(nfs.OP4_PUTFH, fh),
+		newOp4(nfs.OP4_DELEGRETURN, delegation.Read.StateId),
+	)
+	expectsResult(t, r, nfs.OP4_PUTFH, nfs.NFS4_OK)
+	expectsResult(t, r, nfs.OP4_DELEGRETURN, nfs.NFS4_OK)
+
+	if status := write(6); status != nfs.NFS3_OK {
+		t.Fatalf("write: expects NFS3_OK but get %d", status)
+	}
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// blockedTTL is how long a blocking LOCK waits for the conflicting
+// locks to be released. The clients send the call again meanwhile.
+const blockedTTL = time.Minute * 2
+
+// blockedLock is a blocking LOCK waiting for a conflicting lock.
+type blockedLock struct {
+	lock   locks.Lock
+	args   *nfs.Nlm4LockArgs
+	addr   string // address of the client, to send the GRANTED callback to.
+	expiry time.Time
+}
+
+// lockManager is the state of the Network Lock Manager and of the
+// status monitor: the locks granted, the blocking calls waiting for
+// them and the hosts monitored.
+type lockManager struct {
+	locks *locks.Table
+
+	mu       sync.Mutex
+	blocked  []*blockedLock
+	peers    map[string]string        // the address of the hosts, by caller_name.
+	monitors map[string][]nfs.SmMonId // by mon_name
+	state    int32                    // the NSM state number: odd while up.
+}
+
+func newLockManager(table *locks.Table) *lockManager {
+	m := &lockManager{
+		locks:    table,
+		peers:    map[string]string{},
+		monitors: map[string][]nfs.SmMonId{},
+		state:    1,
+	}
+	table.Watch(m.released)
+	return m
+}
+
+// nlmOwner returns the owner of the locks of a NLM client process.
+func nlmOwner(l *nfs.Nlm4Lock) locks.Owner {
+	return locks.Owner{
+		Host: l.CallerName,
+		Id:   fmt.Sprintf("%d:%x", l.Svid, l.Oh),
+	}
+}
+
+// nlmHolder returns the nlm4_holder of a lock.
+func nlmHolder(l locks.Lock) *nfs.Nlm4Holder {
+	h := &nfs.Nlm4Holder{
+		Exclusive: l.Exclusive,
+		Oh:        []byte(l.Owner.Id),
+		Offset:    l.Offset,
+		Length:    l.Length,
+	}
+	// The owner of a NLM lock: see nlmOwner.
+	if i := strings.Index(l.Owner.Id, ":"); i > 0 {
+		svid, err := strconv.ParseInt(l.Owner.Id[:i], 10, 32)
+		oh, err2 := hex.DecodeString(l.Owner.Id[i+1:])
+		if err == nil && err2 == nil {
+			h.Svid, h.Oh = int32(svid), oh
+		}
+	}
+	return h
+}
+
+func nlmLock(l *nfs.Nlm4Lock, exclusive bool) locks.Lock {
+	return locks.Lock{
+		File:      string(l.Fh),
+		Owner:     nlmOwner(l),
+		Exclusive: exclusive,
+		Offset:    l.Offset,
+		Length:    l.Length,
+	}
+}
+
+// lock acquires a lock, or queues it if block is true and the lock
+// conflicts with another one.
+func (m *lockManager) lock(args *nfs.Nlm4LockArgs, addr string) uint32 {
+	l := nlmLock(&args.Lock, args.Exclusive)
+
+	// The delegations of the file conflicting with the lock are recalled
+	// first: the client retries meanwhile.
+	if !m.locks.Recall(l.File, l.Exclusive) {
+		return nfs.NLM4_DENIED_GRACE_PERIOD
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.peers[l.Owner.Host] = addr
+
+	if _, ok := m.locks.Lock(l); ok {
+		m.unblock(l)
+		return nfs.NLM4_GRANTED
+	}
+	if !args.Block {
+		return nfs.NLM4_DENIED
+	}
+
+	// A retransmission only extends the wait.
+	expiry := time.Now().Add(blockedTTL)
+	for _, b := range m.blocked {
+		if b.lock == l {
+			b.args, b.addr, b.expiry = args, addr, expiry
+			return nfs.NLM4_BLOCKED
+		}
+	}
+	m.blocked = append(m.blocked, &blockedLock{
+		lock:   l,
+		args:   args,
+		addr:   addr,
+		expiry: expiry,
+	})
+	return nfs.NLM4_BLOCKED
+}
+
+// unblock removes the blocked calls for l.
+func (m *lockManager) unblock(l locks.Lock) {
+	blocked := m.blocked[:0]
+	for _, b := range m.blocked {
+		if b.lock != l {
+			blocked = append(blocked, b)
+		}
+	}
+	m.blocked = blocked
+}
+
+func (m *lockManager) cancel(args *nfs.Nlm4CancArgs) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.unblock(nlmLock(&args.Lock, args.Exclusive))
+}
+
+// released grants the blocked locks of a file whose locks have been
+// released, then calls the clients back.
+func (m *lockManager) released(file string) {
+	// The table may call back with m.mu held, from lock().
+	go m.retry(file)
+}
+
+func (m *lockManager) retry(file string) {
+	m.mu.Lock()
+	now := time.Now()
+	granted := []*blockedLock{}
+	blocked := []*blockedLock{}
+	for _, b := range m.blocked {
+		if now.After(b.expiry) {
+			continue
+		}
+		if b.lock.File == file && m.locks.Recall(file, b.lock.Exclusive) {
+			if _, ok := m.locks.Lock(b.lock); ok {
+				granted = append(granted, b)
+				continue
+			}
+		}
+		blocked = append(blocked, b)
+	}
+	m.blocked = blocked
+	m.mu.Unlock()
+
+	for _, b := range granted {
+		m.granted(b)
+	}
+}
+
+// granted sends the GRANTED callback of a blocked lock. The lock is
+// released if the client can't be reached or doesn't take it: a client
+// still waiting for it gets it by sending the call again.
+func (m *lockManager) granted(b *blockedLock) {
+	if err := m.callGranted(b); err != nil {
+		log.Warnf("nlm: granted(%s): %v. lock released.", b.addr, err)
+		m.locks.Unlock(b.lock)
+	}
+}
+
+func (m *lockManager) callGranted(b *blockedLock) error {
+	c := &rpcClient{host: b.addr}
+
+	port, err := c.getPort(nfs.PROG_NLM, 4)
+	if err != nil {
+		return fmt.Errorf("getPort: %w", err)
+	}
+
+	args := &nfs.Nlm4TestArgs{
+		Cookie:    b.args.Cookie,
+		Exclusive: b.args.Exclusive,
+		Lock:      b.args.Lock,
+	}
+	r, err := c.call(port, nfs.PROG_NLM, 4, nfs.NLMPROC4_GRANTED, args)
+	if err != nil {
+		return err
+	}
+	res := &nfs.Nlm4Res{}
+	if _, err := r.ReadAs(res); err != nil {
+		return err
+	}
+	log.Debugf("nlm: granted(%s): %d", b.addr, res.Stat)
+	if res.Stat != nfs.NLM4_GRANTED {
+		return fmt.Errorf("status %d", res.Stat)
+	}
+	return nil
+}
+
+// isPeer reports whether a host locked files from an address.
+func (m *lockManager) isPeer(host, addr string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peer, found := m.peers[host]
+	return found && peer == addr
+}
+
+// freeAll releases the locks of a host, e.g. once it rebooted.
+func (m *lockManager) freeAll(host string) {
+	m.mu.Lock()
+	delete(m.peers, host)
+	blocked := m.blocked[:0]
+	for _, b := range m.blocked {
+		if b.lock.Owner.Host != host {
+			blocked = append(blocked, b)
+		}
+	}
+	m.blocked = blocked
+	m.mu.Unlock()
+
+	n := m.locks.Release(func(l locks.Lock) bool {
+		return l.Owner.Host == host
+	})
+	log.Infof("nlm: %d locks of %s released.", n, host)
+}
+
+// nlmMux answers the calls of the Network Lock Manager, version 4.
+// Only the synchronous procedures are served: the asynchronous
+// ones (*_MSG, *_RES) and the DOS file sharing are not.
+type nlmMux struct {
+	reader *xdr.Reader
+	writer *xdr.Writer
+	auth   nfs.AuthenticationHandler
+	fs     fs.FS
+
+	addr string // address of the client.
+	mgr  *lockManager
+}
+
+func (x *nlmMux) HandleProc(h *nfs.RPCMsgCall) (int, error) {
+	switch h.Proc {
+	case nfs.NLMPROC4_NULL:
+		_, err := authenticate(x.writer, x.auth, x.fs, h)
+		return 0, err
+	case nfs.NLMPROC4_TEST:
+		return x.test(h)
+	case nfs.NLMPROC4_LOCK, nfs.NLMPROC4_NM_LOCK:
+		return x.lock(h)
+	case nfs.NLMPROC4_CANCEL:
+		return x.cancel(h)
+	case nfs.NLMPROC4_UNLOCK:
+		return x.unlock(h)
+	case nfs.NLMPROC4_GRANTED:
+		return x.granted(h)
+	case nfs.NLMPROC4_FREE_ALL:
+		return x.freeAll(h)
+	}
+	return 0, fmt.Errorf("%w: nlm %s", nfs.ErrProcUnavail, nfs.NlmProcName(h.Proc))
+}
+
+// checkFh reports whether a file handle is known.
+func (x *nlmMux) checkFh(fh []byte) bool {
+	if _, err := x.fs.ResolveHandle(fh); err != nil {
+		log.Warnf("nlm: fs.ResolveHandle(%x): %v", fh, err)
+		return false
+	}
+	return true
+}
+
+func (x *nlmMux) reply(cookie []byte, stat uint32) error {
+	if cookie == nil {
+		cookie = []byte{}
+	}
+	_, err := x.writer.WriteAny(&nfs.Nlm4Res{Cookie: cookie, Stat: stat})
+	return err
+}
+
+func (x *nlmMux) test(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.Nlm4TestArgs{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := authenticate(x.writer, x.auth, x.fs, h); !ok || err != nil {
+		return size, err
+	}
+
+	stat := nfs.NLM4_STALE_FH
+	holder := (*nfs.Nlm4Holder)(nil)
+	if x.checkFh(args.Lock.Fh) {
+		stat = nfs.NLM4_GRANTED
+		if !x.mgr.locks.Recall(string(args.Lock.Fh), args.Exclusive) {
+			stat = nfs.NLM4_DENIED_GRACE_PERIOD
+		} else if c, found := x.mgr.locks.Test(nlmLock(&args.Lock, args.Exclusive)); found {
+			stat = nfs.NLM4_DENIED
+			holder = nlmHolder(c)
+		}
+	}
+
+	log.Debugf("nlm: test(%s, %x): %d", args.Lock.CallerName, args.Lock.Fh, stat)
+
+	// nlm4_testres: the holder follows a NLM4_DENIED.
+	if err := x.reply(args.Cookie, stat); err != nil {
+		return size, err
+	}
+	if holder != nil {
+		_, err = x.writer.WriteAny(holder)
+	}
+	return size, err
+}
+
+func (x *nlmMux) lock(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.Nlm4LockArgs{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := authenticate(x.writer, x.auth, x.fs, h); !ok || err != nil {
+		return size, err
+	}
+
+	stat := nfs.NLM4_STALE_FH
+	if x.checkFh(args.Lock.Fh) {
+		// NM_LOCK is a LOCK of a host not monitored: never blocking.
+		if h.Proc == nfs.NLMPROC4_NM_LOCK {
+			args.Block = false
+		}
+		stat = x.mgr.lock(args, x.addr)
+	}
+
+	log.Debugf(
+		"nlm: lock(%s, %x, offset=%d, len=%d, exclusive=%v): %d",
+		args.Lock.CallerName, args.Lock.Fh, args.Lock.Offset, args.Lock.Length, args.Exclusive, stat,
+	)
+
+	return size, x.reply(args.Cookie, stat)
+}
+
+func (x *nlmMux) cancel(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.Nlm4CancArgs{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := authenticate(x.writer, x.auth, x.fs, h); !ok || err != nil {
+		return size, err
+	}
+
+	x.mgr.cancel(args)
+	return size, x.reply(args.Cookie, nfs.NLM4_GRANTED)
+}
+
+func (x *nlmMux) unlock(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.Nlm4UnlockArgs{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := authenticate(x.writer, x.auth, x.fs, h); !ok || err != nil {
+		return size, err
+	}
+
+	x.mgr.locks.Unlock(nlmLock(&args.Lock, false))
+
+	log.Debugf(
+		"nlm: unlock(%s, %x, offset=%d, len=%d)",
+		args.Lock.CallerName, args.Lock.Fh, args.Lock.Offset, args.Lock.Length,
+	)
+
+	return size, x.reply(args.Cookie, nfs.NLM4_GRANTED)
+}
+
+// granted answers a GRANTED callback, which is meant for a client:
+// the server never waits for a lock.
+func (x *nlmMux) granted(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.Nlm4TestArgs{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := authenticate(x.writer, x.auth, x.fs, h); !ok || err != nil {
+		return size, err
+	}
+	return size, x.reply(args.Cookie, nfs.NLM4_DENIED)
+}
+
+// freeAll releases the locks of a host that rebooted. As for the
+// NOTIFY of NSM, another host than the local one may only free its own
+// locks, from the address it locked files from: the call is ignored
+// otherwise.
+func (x *nlmMux) freeAll(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.Nlm4Notify{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if ok, err := authenticate(x.writer, x.auth, x.fs, h); !ok || err != nil {
+		return size, err
+	}
+
+	if !isLoopback(x.addr) && !x.mgr.isPeer(args.Name, x.addr) {
+		log.Warnf("nlm: free_all(%s) from %s ignored.", args.Name, x.addr)
+		return size, nil
+	}
+	x.mgr.freeAll(args.Name)
+	return size, nil
+}
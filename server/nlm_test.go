@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallfz/libnfs-go/auth"
+	"github.com/smallfz/libnfs-go/locks"
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// serveNlmClient answers the port mapper and the NLM GRANTED callbacks
+// as the client would, sending the locks granted to ch.
+func serveNlmClient(t *testing.T, l net.Listener, ch chan *nfs.Nlm4TestArgs) {
+	port := uint32(listenerPort(l))
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		r := xdr.NewReader(conn)
+		frag, _ := r.ReadUint32()
+		dat, _ := r.ReadBytes(int(frag &^ lastFragment))
+		br := xdr.NewReader(bytes.NewReader(dat))
+		h := &nfs.RPCMsgCall{}
+		br.ReadAs(h)
+
+		var res interface{}
+		switch h.Prog {
+		case nfs.PROG_PMAP:
+			res = port
+		case nfs.PROG_NLM:
+			args := &nfs.Nlm4TestArgs{}
+			br.ReadAs(args)
+			ch <- args
+			res = &nfs.Nlm4Res{Cookie: args.Cookie, Stat: nfs.NLM4_GRANTED}
+		}
+
+		body := bytes.NewBuffer([]byte{})
+		w := xdr.NewWriter(body)
+		w.WriteAny(&nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_ACCEPTED,
+		})
+		w.WriteAny(nfs.NewEmptyAuth())
+		w.WriteAny(nfs.ACCEPT_SUCCESS)
+		w.WriteAny(res)
+
+		xdr.NewWriter(conn).WriteUint32(uint32(body.Len()) | lastFragment)
+		conn.Write(body.Bytes())
+		conn.Close()
+	}
+}
+
+func TestNlm(t *testing.T) {
+	cl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer cl.Close()
+	granted := make(chan *nfs.Nlm4TestArgs, 1)
+	go serveNlmClient(t, cl, granted)
+
+	defer func(port int) { pmapPort = port }(pmapPort)
+	pmapPort = listenerPort(cl)
+
+	mfs := memfs.NewMemFS()
+	fh := mfs.GetRootHandle()
+
+	svr := newTestServerFS(t, mfs)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	lockOf := func(host string) nfs.Nlm4Lock {
+		return nfs.Nlm4Lock{
+			CallerName: host,
+			Fh:         fh,
+			Oh:         []byte(host),
+			Svid:       1,
+			Offset:     0,
+			Length:     100,
+		}
+	}
+
+	xid := uint32(0)
+	call := func(proc uint32, args interface{}) *xdr.Reader {
+		xid++
+		conn.Write(callRecord(xid, nfs.PROG_NLM, 4, proc, args))
+		_, stat, r := readReplyBody(t, conn)
+		if stat != nfs.ACCEPT_SUCCESS {
+			t.Fatalf("%s: stat=%d", nfs.NlmProcName(proc), stat)
+		}
+		return r
+	}
+	expects := func(r *xdr.Reader, stat uint32) {
+		res := &nfs.Nlm4Res{}
+		if _, err := r.ReadAs(res); err != nil {
+			t.Fatalf("read nlm4_res: %v", err)
+		}
+		if res.Stat != stat {
+			t.Fatalf("expects nlm4_stat %d but get %d", stat, res.Stat)
+		}
+	}
+
+	r := call(nfs.NLMPROC4_LOCK, &nfs.Nlm4LockArgs{Cookie: []byte{}, Exclusive: true, Lock: lockOf("a")})
+	expects(r, nfs.NLM4_GRANTED)
+
+	r = call(nfs.NLMPROC4_TEST, &nfs.Nlm4TestArgs{Cookie: []byte{}, Exclusive: true, Lock: lockOf("b")})
+	expects(r, nfs.NLM4_DENIED)
+	holder := &nfs.Nlm4Holder{}
+	r.ReadAs(holder)
+	if !holder.Exclusive || holder.Svid != 1 || string(holder.Oh) != "a" {
+		t.Fatalf("unexpected holder: %+v", holder)
+	}
+
+	r = call(nfs.NLMPROC4_LOCK, &nfs.Nlm4LockArgs{Cookie: []byte{}, Exclusive: true, Lock: lockOf("b")})
+	expects(r, nfs.NLM4_DENIED)
+
+	r = call(nfs.NLMPROC4_LOCK, &nfs.Nlm4LockArgs{Cookie: []byte{}, Block: true, Exclusive: true, Lock: lockOf("b")})
+	expects(r, nfs.NLM4_BLOCKED)
+
+	r = call(nfs.NLMPROC4_UNLOCK, &nfs.Nlm4UnlockArgs{Cookie: []byte{}, Lock: lockOf("a")})
+	expects(r, nfs.NLM4_GRANTED)
+
+	select {
+	case args := <-granted:
+		if args.Lock.CallerName != "b" || !args.Exclusive {
+			t.Fatalf("unexpected lock granted: %+v", args)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("expects a GRANTED callback.")
+	}
+
+	if locks := svr.Locks(); len(locks) != 1 || locks[0].Owner.Host != "b" {
+		t.Fatalf("unexpected locks: %+v", locks)
+	}
+
+	r = call(nfs.NLMPROC4_LOCK, &nfs.Nlm4LockArgs{Cookie: []byte{}, Lock: nfs.Nlm4Lock{CallerName: "a", Fh: []byte("bad"), Oh: []byte{}}})
+	expects(r, nfs.NLM4_STALE_FH)
+
+	// b reboots.
+	xid++
+	conn.Write(callRecord(xid, nfs.PROG_NSM, 1, nfs.SMPROC_NOTIFY, &nfs.SmStatChge{MonName: "b", State: 3}))
+	if _, stat := readReply(t, conn); stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("notify: stat=%d", stat)
+	}
+	if locks := svr.Locks(); len(locks) != 0 {
+		t.Fatalf("expects the locks of b to be released: %+v", locks)
+	}
+}
+
+func TestNsmRemoteCalls(t *testing.T) {
+	mgr := newLockManager(locks.NewTable())
+	lock := nfs.Nlm4Lock{CallerName: "b", Fh: []byte("f"), Oh: []byte("b"), Svid: 1, Length: 100}
+	if stat := mgr.lock(&nfs.Nlm4LockArgs{Cookie: []byte{}, Exclusive: true, Lock: lock}, "192.0.2.1"); stat != nfs.NLM4_GRANTED {
+		t.Fatalf("lock: %d", stat)
+	}
+
+	call := func(addr string, proc uint32, args ...interface{}) uint32 {
+		body := bytes.NewBuffer([]byte{})
+		for _, arg := range args {
+			xdr.NewWriter(body).WriteAny(arg)
+		}
+		res := bytes.NewBuffer([]byte{})
+		x := &nsmMux{
+			reader: xdr.NewReader(body),
+			writer: xdr.NewWriter(res),
+			addr:   addr,
+			mgr:    mgr,
+		}
+		if _, err := x.HandleProc(&nfs.RPCMsgCall{Xid: 1, Proc: proc}); err != nil {
+			t.Fatalf("HandleProc(%d): %v", proc, err)
+		}
+		rh := &nfs.RPCMsgReply{}
+		xdr.NewReader(res).ReadAs(rh)
+		return rh.ReplyStat
+	}
+
+	// Only the local host crashes the server or stops the monitoring.
+	state := mgr.nsmState()
+	if stat := call("192.0.2.1", nfs.SMPROC_SIMU_CRASH); stat != nfs.MSG_DENIED || mgr.nsmState() != state {
+		t.Fatalf("simu_crash from a remote host: %d", stat)
+	}
+	if stat := call("192.0.2.1", nfs.SMPROC_UNMON_ALL, &nfs.SmMyId{MyName: "a"}); stat != nfs.MSG_DENIED {
+		t.Fatalf("unmon_all from a remote host: %d", stat)
+	}
+	if stat := call("127.0.0.1", nfs.SMPROC_SIMU_CRASH); stat != nfs.MSG_ACCEPTED || mgr.nsmState() == state {
+		t.Fatalf("simu_crash from the local host: %d", stat)
+	}
+
+	// A host only notifies its own reboot.
+	notify := &nfs.SmStatChge{MonName: "b", State: 3}
+	if stat := call("192.0.2.2", nfs.SMPROC_NOTIFY, notify); stat != nfs.MSG_DENIED || len(mgr.locks.List()) != 1 {
+		t.Fatalf("notify from another host: %d", stat)
+	}
+	if stat := call("192.0.2.1", nfs.SMPROC_NOTIFY, notify); stat != nfs.MSG_ACCEPTED || len(mgr.locks.List()) != 0 {
+		t.Fatalf("notify from the host: %d", stat)
+	}
+
+	// Nor does FREE_ALL of NLM free the locks of another host.
+	if stat := mgr.lock(&nfs.Nlm4LockArgs{Cookie: []byte{}, Exclusive: true, Lock: lock}, "192.0.2.1"); stat != nfs.NLM4_GRANTED {
+		t.Fatalf("lock: %d", stat)
+	}
+	freeAll := func(addr string) {
+		body := bytes.NewBuffer([]byte{})
+		xdr.NewWriter(body).WriteAny(&nfs.Nlm4Notify{Name: "b", State: 5})
+		x := &nlmMux{
+			reader: xdr.NewReader(body),
+			writer: xdr.NewWriter(bytes.NewBuffer([]byte{})),
+			auth:   auth.Null,
+			fs:     memfs.NewMemFS(),
+			addr:   addr,
+			mgr:    mgr,
+		}
+		if _, err := x.HandleProc(&nfs.RPCMsgCall{Xid: 1, Proc: nfs.NLMPROC4_FREE_ALL}); err != nil {
+			t.Fatalf("HandleProc(free_all): %v", err)
+		}
+	}
+	if freeAll("192.0.2.2"); len(mgr.locks.List()) != 1 {
+		t.Fatalf("free_all from another host: %+v", mgr.locks.List())
+	}
+	if freeAll("192.0.2.1"); len(mgr.locks.List()) != 0 {
+		t.Fatalf("free_all from the host: %+v", mgr.locks.List())
+	}
+}
+
+func TestNlmGrantedUnreachable(t *testing.T) {
+	// No port mapper answers at the client.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer func(port int) { pmapPort = port }(pmapPort)
+	pmapPort = listenerPort(l)
+	l.Close()
+
+	table := locks.NewTable()
+	released := make(chan string, 8)
+	table.Watch(func(file string) { released <- file })
+	mgr := newLockManager(table)
+	lockOf := func(host string) nfs.Nlm4Lock {
+		return nfs.Nlm4Lock{CallerName: host, Fh: []byte("f"), Oh: []byte(host), Svid: 1, Length: 100}
+	}
+	if stat := mgr.lock(&nfs.Nlm4LockArgs{Cookie: []byte{}, Exclusive: true, Lock: lockOf("a")}, "127.0.0.1"); stat != nfs.NLM4_GRANTED {
+		t.Fatalf("lock: %d", stat)
+	}
+	if stat := mgr.lock(&nfs.Nlm4LockArgs{Cookie: []byte{}, Block: true, Exclusive: true, Lock: lockOf("b")}, "127.0.0.1"); stat != nfs.NLM4_BLOCKED {
+		t.Fatalf("lock: %d", stat)
+	}
+	a := lockOf("a")
+	mgr.locks.Unlock(nlmLock(&a, false))
+
+	// The lock granted to b is released once the callback failed.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-released:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("the lock is not released: %+v", mgr.locks.List())
+		}
+	}
+	if locks := mgr.locks.List(); len(locks) != 0 {
+		t.Fatalf("unexpected locks: %+v", locks)
+	}
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// monitor records the hosts monitored on behalf of the lock manager.
+func (m *lockManager) monitor(id nfs.SmMonId) int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, v := range m.monitors[id.MonName] {
+		if v == id {
+			return m.state
+		}
+	}
+	m.monitors[id.MonName] = append(m.monitors[id.MonName], id)
+	return m.state
+}
+
+// unmonitor stops monitoring a host: all of them if id is nil.
+func (m *lockManager) unmonitor(id *nfs.SmMonId) int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id == nil {
+		m.monitors = map[string][]nfs.SmMonId{}
+		return m.state
+	}
+
+	ids := []nfs.SmMonId{}
+	for _, v := range m.monitors[id.MonName] {
+		if v != *id {
+			ids = append(ids, v)
+		}
+	}
+	if len(ids) == 0 {
+		delete(m.monitors, id.MonName)
+	} else {
+		m.monitors[id.MonName] = ids
+	}
+	return m.state
+}
+
+func (m *lockManager) nsmState() int32 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.state
+}
+
+// crash simulates a crash of the server: its state number changes.
+func (m *lockManager) crash() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.state += 2
+}
+
+// nsmMux answers the calls of the Network Status Monitor, version 1. It
+// is a minimal one, serving the lock manager of the server only: a host
+// notifying its reboot loses its locks. The monitored hosts are not
+// persisted, hence not notified of the reboots of the server.
+// As rpc.statd does, only the local host is served but for NOTIFY, which
+// is accepted from the host notifying its own reboot.
+type nsmMux struct {
+	reader *xdr.Reader
+	writer *xdr.Writer
+
+	addr string // address of the caller.
+	mgr  *lockManager
+}
+
+func (x *nsmMux) HandleProc(h *nfs.RPCMsgCall) (int, error) {
+	switch h.Proc {
+	case nfs.SMPROC_NULL:
+		return 0, x.accept(h)
+	case nfs.SMPROC_STAT:
+		return x.stat(h)
+	case nfs.SMPROC_MON:
+		return x.mon(h)
+	case nfs.SMPROC_UNMON:
+		return x.unmon(h)
+	case nfs.SMPROC_UNMON_ALL:
+		return x.unmonAll(h)
+	case nfs.SMPROC_SIMU_CRASH:
+		return x.simuCrash(h)
+	case nfs.SMPROC_NOTIFY:
+		return x.notify(h)
+	}
+	return 0, fmt.Errorf("%w: nsm %s", nfs.ErrProcUnavail, nfs.NsmProcName(h.Proc))
+}
+
+// local reports whether the caller is the local host.
+func (x *nsmMux) local() bool {
+	return isLoopback(x.addr)
+}
+
+// isLoopback reports whether an address is the one of the local host.
+func isLoopback(addr string) bool {
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// deny writes the reply to a call refused to the caller.
+func (x *nsmMux) deny(h *nfs.RPCMsgCall) error {
+	log.Warnf("nsm: %s refused to %s.", nfs.NsmProcName(h.Proc), x.addr)
+
+	seq := []interface{}{
+		&nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_DENIED,
+		},
+		nfs.REJECT_AUTH_ERROR,
+		nfs.AUTH_TOOWEAK,
+	}
+	for _, v := range seq {
+		if _, err := x.writer.WriteAny(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accept writes the header of a successful reply.
+func (x *nsmMux) accept(h *nfs.RPCMsgCall) error {
+	seq := []interface{}{
+		&nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_ACCEPTED,
+		},
+		nfs.NewEmptyAuth(),
+		nfs.ACCEPT_SUCCESS,
+	}
+	for _, v := range seq {
+		if _, err := x.writer.WriteAny(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *nsmMux) stat(h *nfs.RPCMsgCall) (int, error) {
+	monName := ""
+	size, err := x.reader.ReadAs(&monName)
+	if err != nil {
+		return size, err
+	}
+
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(&nfs.SmStatRes{
+		Res:   nfs.STAT_SUCC,
+		State: x.mgr.nsmState(),
+	})
+	return size, err
+}
+
+func (x *nsmMux) mon(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.SmMon{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	log.Infof("nsm: mon(%s) for %s", args.MonId.MonName, args.MonId.MyId.MyName)
+
+	if !x.local() {
+		return size, x.deny(h)
+	}
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(&nfs.SmStatRes{
+		Res:   nfs.STAT_SUCC,
+		State: x.mgr.monitor(args.MonId),
+	})
+	return size, err
+}
+
+func (x *nsmMux) unmon(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.SmMonId{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	log.Infof("nsm: unmon(%s)", args.MonName)
+
+	if !x.local() {
+		return size, x.deny(h)
+	}
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(x.mgr.unmonitor(args))
+	return size, err
+}
+
+func (x *nsmMux) unmonAll(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.SmMyId{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	if !x.local() {
+		return size, x.deny(h)
+	}
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(x.mgr.unmonitor(nil))
+	return size, err
+}
+
+func (x *nsmMux) simuCrash(h *nfs.RPCMsgCall) (int, error) {
+	if !x.local() {
+		return 0, x.deny(h)
+	}
+	x.mgr.crash()
+	return 0, x.accept(h)
+}
+
+// notify handles the reboot of a host: its locks are released. Another
+// host than the local one may only notify its own reboot, from the
+// address it locked files from.
+func (x *nsmMux) notify(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.SmStatChge{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	log.Infof("nsm: notify(%s, state=%d)", args.MonName, args.State)
+
+	if !x.local() && !x.mgr.isPeer(args.MonName, x.addr) {
+		return size, x.deny(h)
+	}
+	x.mgr.freeAll(args.MonName)
+
+	return size, x.accept(h)
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/smallfz/libnfs-go/log"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// portmapOwner is the owner reported for the programs advertised.
+const portmapOwner = "superuser"
+
+// portmapMux answers the port mapper(v2) and rpcbind(v3, v4) calls
+// (rfc1833). It advertises the programs hosted by the server. Requests
+// to register other services are declined.
+type portmapMux struct {
+	reader *xdr.Reader
+	writer *xdr.Writer
+
+	addr     net.Addr // local address of the connection.
+	programs []program
+}
+
+func (x *portmapMux) HandleProc(h *nfs.RPCMsgCall) (int, error) {
+	if h.Vers == 2 {
+		switch h.Proc {
+		case nfs.PMAPPROC_NULL:
+			return x.null(h)
+		case nfs.PMAPPROC_SET, nfs.PMAPPROC_UNSET:
+			return x.set(h, &nfs.PmapMapping{})
+		case nfs.PMAPPROC_GETPORT:
+			return x.getPort(h)
+		case nfs.PMAPPROC_DUMP:
+			return x.dump(h)
+		}
+		return 0, fmt.Errorf("%w: pmap %s", nfs.ErrProcUnavail, nfs.PmapProcName(h.Vers, h.Proc))
+	}
+
+	switch h.Proc {
+	case nfs.RPCBPROC_NULL:
+		return x.null(h)
+	case nfs.RPCBPROC_SET, nfs.RPCBPROC_UNSET:
+		return x.set(h, &nfs.Rpcb{})
+	case nfs.RPCBPROC_GETADDR:
+		// v4 returns the address of any version of the program.
+		return x.getAddr(h, h.Vers == 3)
+	case nfs.RPCBPROC_DUMP:
+		return x.dumpRpcb(h)
+	case nfs.RPCBPROC_GETTIME:
+		return x.getTime(h)
+	case nfs.RPCBPROC_GETVERSADDR:
+		if h.Vers == 4 {
+			return x.getAddr(h, true)
+		}
+	}
+	return 0, fmt.Errorf("%w: rpcb %s", nfs.ErrProcUnavail, nfs.PmapProcName(h.Vers, h.Proc))
+}
+
+// accept writes the header of a successful reply.
+func (x *portmapMux) accept(h *nfs.RPCMsgCall) error {
+	seq := []interface{}{
+		&nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_ACCEPTED,
+		},
+		nfs.NewEmptyAuth(),
+		nfs.ACCEPT_SUCCESS,
+	}
+	for _, v := range seq {
+		if _, err := x.writer.WriteAny(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *portmapMux) null(h *nfs.RPCMsgCall) (int, error) {
+	return 0, x.accept(h)
+}
+
+// set declines the (un)registration of a service: only the programs
+// hosted by the server are advertised.
+func (x *portmapMux) set(h *nfs.RPCMsgCall, args interface{}) (int, error) {
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	log.Infof("pmap %s(%+v): declined.", nfs.PmapProcName(h.Vers, h.Proc), args)
+
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(false)
+	return size, err
+}
+
+func (x *portmapMux) getPort(h *nfs.RPCMsgCall) (int, error) {
+	args := &nfs.PmapMapping{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	port := uint32(0)
+	if args.Prot == nfs.IPPROTO_TCP {
+		if p := x.findProgram(args.Prog, args.Vers, true); p != nil {
+			port = uint32(p.port)
+		}
+	}
+
+	log.Debugf("pmap getport(prog=%d, vers=%d): %d", args.Prog, args.Vers, port)
+
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteUint32(port)
+	return size, err
+}
+
+func (x *portmapMux) dump(h *nfs.RPCMsgCall) (int, error) {
+	if err := x.accept(h); err != nil {
+		return 0, err
+	}
+
+	// pmaplist: an optional-data linked list.
+	for _, p := range x.programs {
+		for vers := p.low; vers <= p.high; vers++ {
+			seq := []interface{}{
+				true,
+				&nfs.PmapMapping{
+					Prog: p.prog,
+					Vers: vers,
+					Prot: nfs.IPPROTO_TCP,
+					Port: uint32(p.port),
+				},
+			}
+			for _, v := range seq {
+				if _, err := x.writer.WriteAny(v); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	_, err := x.writer.WriteAny(false)
+	return 0, err
+}
+
+// getAddr replies the universal address of a program. If exact is false,
+// the address of any version of the program will do.
+func (x *portmapMux) getAddr(h *nfs.RPCMsgCall, exact bool) (int, error) {
+	args := &nfs.Rpcb{}
+	size, err := x.reader.ReadAs(args)
+	if err != nil {
+		return size, err
+	}
+
+	addr := ""
+	if p := x.findProgram(args.Prog, args.Vers, exact); p != nil {
+		netid, uaddr := x.uaddr(p.port)
+		if args.Netid == "" || args.Netid == netid {
+			addr = uaddr
+		}
+	}
+
+	log.Debugf(
+		"rpcb %s(prog=%d, vers=%d, netid=%s): %s",
+		nfs.PmapProcName(h.Vers, h.Proc), args.Prog, args.Vers, args.Netid, addr,
+	)
+
+	if err := x.accept(h); err != nil {
+		return size, err
+	}
+	_, err = x.writer.WriteAny(addr)
+	return size, err
+}
+
+func (x *portmapMux) dumpRpcb(h *nfs.RPCMsgCall) (int, error) {
+	if err := x.accept(h); err != nil {
+		return 0, err
+	}
+
+	// rpcblist: an optional-data linked list.
+	for _, p := range x.programs {
+		netid, uaddr := x.uaddr(p.port)
+		for vers := p.low; vers <= p.high; vers++ {
+			seq := []interface{}{
+				true,
+				&nfs.Rpcb{
+					Prog:  p.prog,
+					Vers:  vers,
+					Netid: netid,
+					Addr:  uaddr,
+					Owner: portmapOwner,
+				},
+			}
+			for _, v := range seq {
+				if _, err := x.writer.WriteAny(v); err != nil {
+					return 0, err
+				}
+			}
+		}
+	}
+	_, err := x.writer.WriteAny(false)
+	return 0, err
+}
+
+func (x *portmapMux) getTime(h *nfs.RPCMsgCall) (int, error) {
+	if err := x.accept(h); err != nil {
+		return 0, err
+	}
+	_, err := x.writer.WriteUint32(uint32(time.Now().Unix()))
+	return 0, err
+}
+
+func (x *portmapMux) findProgram(prog, vers uint32, exact bool) *program {
+	for i, p := range x.programs {
+		if p.prog != prog {
+			continue
+		}
+		if !exact || (vers >= p.low && vers <= p.high) {
+			return &x.programs[i]
+		}
+	}
+	return nil
+}
+
+// uaddr returns the netid and the universal address(rfc5665, section 5.2.3)
+// a program is reachable at: the address the client connected to, along
+// with the port of the program.
+func (x *portmapMux) uaddr(port int) (string, string) {
+	ip := net.IPv4zero
+	if addr, ok := x.addr.(*net.TCPAddr); ok {
+		ip = addr.IP
+	}
+
+	p := fmt.Sprintf("%d.%d", (port>>8)&0xff, port&0xff)
+	if ip4 := ip.To4(); ip4 != nil {
+		return "tcp", fmt.Sprintf(
+			"%d.%d.%d.%d.%s", ip4[0], ip4[1], ip4[2], ip4[3], p,
+		)
+	}
+	return "tcp6", fmt.Sprintf("%s.%s", ip.String(), p)
+}
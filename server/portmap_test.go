@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+func TestPortmap(t *testing.T) {
+	svr := newTestServer(t)
+	svr.Portmap = true
+	serveTest(svr)
+	defer svr.Close()
+
+	pl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	go svr.ServePortmap(pl)
+
+	nfsPort := svr.listener.Addr().(*net.TCPAddr).Port
+	nfsAddr := fmt.Sprintf("127.0.0.1.%d.%d", nfsPort>>8, nfsPort&0xff)
+
+	for _, addr := range []net.Addr{svr.listener.Addr(), pl.Addr()} {
+		conn, err := net.Dial("tcp", addr.String())
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+		defer conn.Close()
+
+		// v2 getport
+		conn.Write(callRecord(1, nfs.PROG_PMAP, 2, nfs.PMAPPROC_GETPORT, &nfs.PmapMapping{
+			Prog: nfs.PROG_NFS,
+			Vers: 3,
+			Prot: nfs.IPPROTO_TCP,
+		}))
+		_, stat, r := readReplyBody(t, conn)
+		if stat != nfs.ACCEPT_SUCCESS {
+			t.Fatalf("getport: stat=%d", stat)
+		}
+		if port, _ := r.ReadUint32(); port != uint32(nfsPort) {
+			t.Fatalf("getport: expects %d but get %d", nfsPort, port)
+		}
+
+		// v2 getport of a version not hosted
+		conn.Write(callRecord(2, nfs.PROG_PMAP, 2, nfs.PMAPPROC_GETPORT, &nfs.PmapMapping{
+			Prog: nfs.PROG_NFS,
+			Vers: 2,
+			Prot: nfs.IPPROTO_TCP,
+		}))
+		_, _, r = readReplyBody(t, conn)
+		if port, _ := r.ReadUint32(); port != 0 {
+			t.Fatalf("getport(v2): expects 0 but get %d", port)
+		}
+
+		// v3 getaddr
+		conn.Write(callRecord(3, nfs.PROG_PMAP, 3, nfs.RPCBPROC_GETADDR, &nfs.Rpcb{
+			Prog:  nfs.PROG_NFS,
+			Vers:  4,
+			Netid: "tcp",
+		}))
+		_, _, r = readReplyBody(t, conn)
+		uaddr := ""
+		if _, err := r.ReadAs(&uaddr); err != nil || uaddr != nfsAddr {
+			t.Fatalf("getaddr: expects %s but get %s(%v)", nfsAddr, uaddr, err)
+		}
+
+		// v4 dump
+		conn.Write(callRecord(4, nfs.PROG_PMAP, 4, nfs.RPCBPROC_DUMP))
+		_, _, r = readReplyBody(t, conn)
+		found := map[uint32]bool{}
+		for {
+			follows := false
+			if _, err := r.ReadAs(&follows); err != nil {
+				t.Fatalf("dump: %v", err)
+			}
+			if !follows {
+				break
+			}
+			rb := &nfs.Rpcb{}
+			if _, err := r.ReadAs(rb); err != nil {
+				t.Fatalf("dump: %v", err)
+			}
+			found[rb.Prog] = true
+		}
+		if !found[nfs.PROG_NFS] || !found[nfs.PROG_PMAP] {
+			t.Fatalf("dump: programs missing: %v", found)
+		}
+	}
+
+	// the portmap listener answers the port mapper only.
+	conn, err := net.Dial("tcp", pl.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(callRecord(1, nfs.PROG_NFS, 4, nfs.PROC4_VOID))
+	if _, stat := readReply(t, conn); stat != nfs.ACCEPT_PROG_UNAVAIL {
+		t.Fatalf("expects PROG_UNAVAIL but get %d", stat)
+	}
+}
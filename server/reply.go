@@ -0,0 +1,51 @@
+package server
+
+import (
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+// authenticate authenticates a call and writes the header of its reply.
+// It reports false if the credential has been rejected: the reply is
+// then complete.
+func authenticate(w *xdr.Writer, auth nfs.AuthenticationHandler, vfs fs.FS, h *nfs.RPCMsgCall) (bool, error) {
+	resp, creds, err := auth(h.Cred, h.Verf)
+	if authErr, ok := err.(*nfs.AuthError); ok {
+		seq := []interface{}{
+			&nfs.RPCMsgReply{
+				Xid:       h.Xid,
+				MsgType:   nfs.RPC_REPLY,
+				ReplyStat: nfs.MSG_DENIED,
+			},
+			nfs.REJECT_AUTH_ERROR,
+			authErr.Code,
+		}
+		for _, v := range seq {
+			if _, err := w.WriteAny(v); err != nil {
+				return false, err
+			}
+		}
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	vfs.SetCreds(creds)
+
+	seq := []interface{}{
+		&nfs.RPCMsgReply{
+			Xid:       h.Xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_ACCEPTED,
+		},
+		resp,
+		nfs.ACCEPT_SUCCESS,
+	}
+	for _, v := range seq {
+		if _, err := w.WriteAny(v); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -38,16 +38,97 @@ package server
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
+	"sync"
+	"time"
 
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/locks"
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
+	v4 "github.com/smallfz/libnfs-go/nfs/implv4"
 )
 
+// ErrServerClosed is returned by Serve after a call to Shutdown or Close.
+var ErrServerClosed = errors.New("server: Server closed")
+
+// DefaultMaxRecordSize is the default limit of Server.MaxRecordSize.
+const DefaultMaxRecordSize = 16 * 1024 * 1024
+
+// DefaultMaxInFlight is the default limit of Server.MaxInFlight: the
+// calls of a connection are handled one at a time, since they share the
+// fs.FS given the credentials of each call by SetCreds.
+const DefaultMaxInFlight = 1
+
+// shutdownPollInterval is how often Shutdown checks for sessions to drain.
+const shutdownPollInterval = time.Millisecond * 50
+
 type Server struct {
+	// MaxRecordSize limits the size in bytes of a reassembled rpc record.
+	// Calls exceeding it are rejected with GARBAGE_ARGS.
+	// If zero, DefaultMaxRecordSize is used.
+	MaxRecordSize int
+
+	// MaxInFlight bounds the number of calls handled concurrently within
+	// one connection. Replies are sent as soon as they are ready, in any
+	// order; clients match them by xid.
+	// Raise it only if the fs.FS doesn't depend on SetCreds (as memfs),
+	// since the calls of a connection share the same fs.FS instance.
+	// If zero, DefaultMaxInFlight is used.
+	MaxInFlight int
+
+	// Portmap makes the listener of the server answer the port mapper
+	// and rpcbind calls as well (program 100000, versions 2 to 4),
+	// advertising the programs hosted by the server.
+	// See ServePortmap to run it on a listener of its own.
+	Portmap bool
+
+	// Exports lists the directories the clients can mount with the MOUNT
+	// protocol (program 100005, version 3), along with their subdirectories.
+	// If empty, the root "/" is exported.
+	Exports []string
+
+	// LeaseTime is the time a NFSv4 client keeps its state without
+	// renewing it. Once it expired, the files opened and the locks of the
+	// client are released.
+	// If zero, v4.DefaultLeaseTime is used.
+	LeaseTime time.Duration
+
+	// ClientStore records the NFSv4 clients on stable storage, e.g. with
+	// v4.NewFileClientStore. After a restart, the clients recorded reclaim
+	// their opens and locks in a grace period, in which no new state is
+	// given.
+	// If nil, the clients lose their state once the server restarts.
+	ClientStore v4.ClientStore
+
+	// GracePeriod is the time the clients have to reclaim their state
+	// after a restart, with a ClientStore.
+	// If zero, the lease time is used.
+	GracePeriod time.Duration
+
 	listener net.Listener
 	backend  nfs.Backend
+
+	mounts *mountTable
+	locks  *lockManager
+	state  *v4.State
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu               sync.Mutex
+	sessions         map[*Session]struct{}
+	portmapListeners []net.Listener
+	inShutdown       bool
+}
+
+// program is a rpc program hosted by a server.
+type program struct {
+	prog      uint32
+	low, high uint32 // versions supported.
+	port      int    // port the program is served at.
 }
 
 func NewServerTCP(address string, backend nfs.Backend) (*Server, error) {
@@ -60,27 +141,270 @@ func NewServerTCP(address string, backend nfs.Backend) (*Server, error) {
 
 // NewServer returns a new server with the given listener (e.g. net.Listen, tls.Listen, etc.)
 func NewServer(l net.Listener, backend nfs.Backend) (*Server, error) {
-	return &Server{listener: l, backend: backend}, nil
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// NLM and NFSv4 share the locks.
+	table := locks.NewTable()
+	state := v4.NewState()
+	state.SetLockTable(table)
+	state.SetCaller(callUaddr)
+
+	return &Server{
+		listener: l,
+		backend:  backend,
+		mounts:   &mountTable{},
+		locks:    newLockManager(table),
+		state:    state,
+		ctx:      ctx,
+		cancel:   cancel,
+		sessions: map[*Session]struct{}{},
+	}, nil
 }
 
+// Serve accepts incoming connections and serves them until the listener fails.
+// After Shutdown or Close, the returned error is ErrServerClosed.
 func (s *Server) Serve() error {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	hosted := s.hosted()
+	if s.Portmap {
+		hosted = append(hosted, portmapProgram(s.listener))
+	}
+
+	s.state.SetLeaseTime(s.leaseTime())
+	if s.ClientStore != nil {
+		s.state.SetClientStore(s.ClientStore)
+		if err := s.state.StartGrace(s.gracePeriod()); err != nil {
+			log.Warnf("StartGrace: %v", err)
+		}
+	}
+	go s.state.Reap(s.ctx)
 
-	defer s.listener.Close()
+	return s.serve(s.listener, hosted, hosted)
+}
+
+// ServePortmap runs the port mapper and rpcbind service (program 100000,
+// versions 2 to 4) on a listener of its own, typically bound to port 111.
+// It advertises the programs served by Serve, and only answers the
+// port mapper calls.
+//
+// ServePortmap returns like Serve. Shutdown and Close stop it as well.
+func (s *Server) ServePortmap(l net.Listener) error {
+	s.mu.Lock()
+	if s.inShutdown {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.portmapListeners = append(s.portmapListeners, l)
+	s.mu.Unlock()
+
+	pmap := portmapProgram(l)
+	return s.serve(l, []program{pmap}, append(s.hosted(), pmap))
+}
 
-	log.Infof("Serving at %s ...", s.listener.Addr())
+// serve accepts connections on l, answering the calls for programs and
+// advertising the programs advertised by the port mapper.
+func (s *Server) serve(l net.Listener, programs, advertised []program) error {
+	defer l.Close()
+
+	if s.shuttingDown() {
+		return ErrServerClosed
+	}
+
+	log.Infof("Serving at %s ...", l.Addr())
 
 	for {
-		if conn, err := s.listener.Accept(); err != nil {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.shuttingDown() {
+				return ErrServerClosed
+			}
 			return fmt.Errorf("listener.Accept: %w", err)
-		} else {
-			go func() {
-				defer conn.Close()
-				if err := handleSession(ctx, s.backend, conn); err != nil {
-					log.Errorf("handleSession: %v", err)
-				}
-			}()
+		}
+
+		sess := &Session{
+			conn:          conn,
+			backend:       s.backend,
+			maxRecordSize: s.maxRecordSize(),
+			maxInFlight:   s.maxInFlight(),
+			programs:      programs,
+			advertised:    advertised,
+			exports:       s.exports(),
+			mounts:        s.mounts,
+			locks:         s.locks,
+			state:         s.state,
+		}
+		if !s.trackSession(sess, true) {
+			conn.Close()
+			return ErrServerClosed
+		}
+
+		go func() {
+			defer s.trackSession(sess, false)
+			if err := sess.Start(s.ctx); err != nil {
+				log.Errorf("sess.Start: %v", err)
+			}
+		}()
+	}
+}
+
+// hosted returns the programs served by Serve.
+func (s *Server) hosted() []program {
+	port := listenerPort(s.listener)
+	return []program{
+		{prog: nfs.PROG_NFS, low: 3, high: 4, port: port},
+		{prog: nfs.PROG_MOUNT, low: 3, high: 3, port: port},
+		{prog: nfs.PROG_NLM, low: 4, high: 4, port: port},
+		{prog: nfs.PROG_NSM, low: 1, high: 1, port: port},
+	}
+}
+
+func (s *Server) exports() []string {
+	if len(s.Exports) == 0 {
+		return []string{fs.ROOT}
+	}
+	rs := make([]string, len(s.Exports))
+	for i, e := range s.Exports {
+		rs[i] = fs.Abs(e)
+	}
+	return rs
+}
+
+// Mounts returns the mount table: the directories mounted by the
+// clients with the MOUNT protocol and not unmounted since.
+func (s *Server) Mounts() []MountEntry {
+	return s.mounts.list()
+}
+
+// Locks returns the byte-range locks held by the clients, through the
+// Network Lock Manager (program 100021, version 4) or NFSv4.
+func (s *Server) Locks() []locks.Lock {
+	return s.locks.locks.List()
+}
+
+func portmapProgram(l net.Listener) program {
+	return program{prog: nfs.PROG_PMAP, low: 2, high: 4, port: listenerPort(l)}
+}
+
+func listenerPort(l net.Listener) int {
+	if addr, ok := l.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// Shutdown gracefully shuts down the server: it stops accepting new
+// connections, lets the calls in progress finish and send their replies,
+// then closes every connection along with its backend session.
+//
+// Shutdown returns once all the sessions are drained, or the context's
+// error if ctx expires first.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown = true
+	err := s.closeListener()
+	for sess := range s.sessions {
+		sess.shutdown()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if s.numSessions() == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close immediately closes the listener and all the client connections,
+// without waiting for the calls in progress.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.inShutdown = true
+	s.cancel()
+
+	err := s.closeListener()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	return err
+}
+
+func (s *Server) maxRecordSize() int {
+	if s.MaxRecordSize > 0 {
+		return s.MaxRecordSize
+	}
+	return DefaultMaxRecordSize
+}
+
+func (s *Server) maxInFlight() int {
+	if s.MaxInFlight > 0 {
+		return s.MaxInFlight
+	}
+	return DefaultMaxInFlight
+}
+
+func (s *Server) leaseTime() time.Duration {
+	if s.LeaseTime > 0 {
+		return s.LeaseTime
+	}
+	return v4.DefaultLeaseTime
+}
+
+func (s *Server) gracePeriod() time.Duration {
+	if s.GracePeriod > 0 {
+		return s.GracePeriod
+	}
+	return s.leaseTime()
+}
+
+func (s *Server) closeListener() error {
+	for _, l := range s.portmapListeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Warnf("l.Close: %v", err)
 		}
 	}
+	if err := s.listener.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (s *Server) shuttingDown() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.inShutdown
+}
+
+// trackSession adds or removes a live session. Adding reports false
+// once the server is shutting down.
+func (s *Server) trackSession(sess *Session, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		if s.inShutdown {
+			return false
+		}
+		s.sessions[sess] = struct{}{}
+	} else {
+		delete(s.sessions, sess)
+	}
+	return true
+}
+
+func (s *Server) numSessions() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.sessions)
 }
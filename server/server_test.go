@@ -0,0 +1,290 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/smallfz/libnfs-go/auth"
+	"github.com/smallfz/libnfs-go/backend"
+	"github.com/smallfz/libnfs-go/fs"
+	"github.com/smallfz/libnfs-go/memfs"
+	"github.com/smallfz/libnfs-go/nfs"
+	"github.com/smallfz/libnfs-go/xdr"
+)
+
+func newTestServer(t *testing.T) *Server {
+	return newTestServerFS(t, memfs.NewMemFS())
+}
+
+func newTestServerFS(t *testing.T, mfs fs.FS) *Server {
+	b := backend.New(func() fs.FS { return mfs }, auth.Null)
+
+	svr, err := NewServerTCP("127.0.0.1:0", b)
+	if err != nil {
+		t.Fatalf("NewServerTCP: %v", err)
+	}
+	return svr
+}
+
+func serveTest(svr *Server) chan error {
+	served := make(chan error, 1)
+	go func() {
+		served <- svr.Serve()
+	}()
+	return served
+}
+
+// callRecord encodes a rpc call as a single-fragment record.
+func callRecord(xid, prog, vers, proc uint32, args ...interface{}) []byte {
+	body := callBody(xid, prog, vers, proc, args...)
+
+	rec := bytes.NewBuffer([]byte{})
+	xdr.NewWriter(rec).WriteUint32(uint32(len(body)) | lastFragment)
+	rec.Write(body)
+	return rec.Bytes()
+}
+
+func callBody(xid, prog, vers, proc uint32, args ...interface{}) []byte {
+	body := bytes.NewBuffer([]byte{})
+	w := xdr.NewWriter(body)
+	w.WriteAny(&nfs.RPCMsgCall{
+		Xid:     xid,
+		MsgType: nfs.RPC_CALL,
+		RPCVer:  2,
+		Prog:    prog,
+		Vers:    vers,
+		Proc:    proc,
+		Cred:    nfs.NewEmptyAuth(),
+		Verf:    nfs.NewEmptyAuth(),
+	})
+	for _, arg := range args {
+		w.WriteAny(arg)
+	}
+	return body.Bytes()
+}
+
+// fragments splits a record body into fragments of at most size bytes.
+func fragments(body []byte, size int) []byte {
+	rec := bytes.NewBuffer([]byte{})
+	w := xdr.NewWriter(rec)
+	for len(body) > 0 {
+		n := size
+		if n >= len(body) {
+			n = len(body)
+			w.WriteUint32(uint32(n) | lastFragment)
+		} else {
+			w.WriteUint32(uint32(n))
+		}
+		rec.Write(body[:n])
+		body = body[n:]
+	}
+	return rec.Bytes()
+}
+
+// readReply reads a single-fragment reply record and returns its xid and accept_stat.
+func readReply(t *testing.T, conn net.Conn) (uint32, uint32) {
+	xid, stat, _ := readReplyBody(t, conn)
+	return xid, stat
+}
+
+// readReplyBody is like readReply, along with a reader of the results.
+func readReplyBody(t *testing.T, conn net.Conn) (uint32, uint32, *xdr.Reader) {
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	r := xdr.NewReader(conn)
+	frag, err := r.ReadUint32()
+	if err != nil {
+		t.Fatalf("read fragment header: %v", err)
+	}
+	dat, err := r.ReadBytes(int(frag &^ uint32(1<<31)))
+	if err != nil {
+		t.Fatalf("read reply: %v", err)
+	}
+
+	br := xdr.NewReader(bytes.NewBuffer(dat))
+	rh := &nfs.RPCMsgReply{}
+	if _, err := br.ReadAs(rh); err != nil {
+		t.Fatalf("ReadAs(reply): %v", err)
+	}
+	if rh.ReplyStat != nfs.MSG_ACCEPTED {
+		t.Fatalf("expects MSG_ACCEPTED but get %d", rh.ReplyStat)
+	}
+	verf := &nfs.Auth{}
+	if _, err := br.ReadAs(verf); err != nil {
+		t.Fatalf("ReadAs(verf): %v", err)
+	}
+	stat, err := br.ReadUint32()
+	if err != nil {
+		t.Fatalf("read accept_stat: %v", err)
+	}
+	return rh.Xid, stat, br
+}
+
+func TestServerShutdown(t *testing.T) {
+	svr := newTestServer(t)
+	served := serveTest(svr)
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(callRecord(1, 100003, 4, nfs.PROC4_VOID))
+	if xid, stat := readReply(t, conn); xid != 1 || stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("unexpected reply: xid=%d, stat=%d", xid, stat)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := svr.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if err := <-served; err != ErrServerClosed {
+		t.Fatalf("expects ErrServerClosed but get %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatalf("expects the connection to be closed.")
+	}
+
+	if _, err := net.Dial("tcp", svr.listener.Addr().String()); err == nil {
+		t.Fatalf("expects the listener to be closed.")
+	}
+}
+
+func TestSessionFragmentedRecord(t *testing.T) {
+	svr := newTestServer(t)
+	svr.MaxRecordSize = 64
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	// a call split into several fragments.
+	conn.Write(fragments(callBody(1, 100003, 4, nfs.PROC4_VOID), 7))
+	if xid, stat := readReply(t, conn); xid != 1 || stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("unexpected reply: xid=%d, stat=%d", xid, stat)
+	}
+
+	// a record over the limit.
+	body := append(callBody(2, 100003, 4, nfs.PROC4_VOID), make([]byte, 128)...)
+	conn.Write(fragments(body, 16))
+	if xid, stat := readReply(t, conn); xid != 2 || stat != nfs.ACCEPT_GRABAGE_ARGS {
+		t.Fatalf("unexpected reply: xid=%d, stat=%d", xid, stat)
+	}
+
+	// the connection is still usable.
+	conn.Write(callRecord(3, 100003, 4, nfs.PROC4_VOID))
+	if xid, stat := readReply(t, conn); xid != 3 || stat != nfs.ACCEPT_SUCCESS {
+		t.Fatalf("unexpected reply: xid=%d, stat=%d", xid, stat)
+	}
+}
+
+func TestSessionPipelining(t *testing.T) {
+	svr := newTestServer(t)
+	svr.MaxInFlight = 4
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	calls := bytes.NewBuffer([]byte{})
+	pending := map[uint32]bool{}
+	for xid := uint32(1); xid <= 16; xid++ {
+		calls.Write(callRecord(xid, 100003, 4, nfs.PROC4_VOID))
+		pending[xid] = true
+	}
+	conn.Write(calls.Bytes())
+
+	for len(pending) > 0 {
+		xid, stat := readReply(t, conn)
+		if !pending[xid] || stat != nfs.ACCEPT_SUCCESS {
+			t.Fatalf("unexpected reply: xid=%d, stat=%d", xid, stat)
+		}
+		delete(pending, xid)
+	}
+}
+
+func TestSessionRejectedCalls(t *testing.T) {
+	svr := newTestServer(t)
+	serveTest(svr)
+	defer svr.Close()
+
+	conn, err := net.Dial("tcp", svr.listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer conn.Close()
+
+	calls := []struct {
+		prog, vers, proc uint32
+		stat             uint32
+	}{
+		{100099, 4, nfs.PROC4_VOID, nfs.ACCEPT_PROG_UNAVAIL},
+		{100003, 2, nfs.PROC4_VOID, nfs.ACCEPT_PROG_MISMATCH},
+		{100003, 3, 99, nfs.ACCEPT_PROC_UNAVAIL},
+		{100003, 4, 99, nfs.ACCEPT_PROC_UNAVAIL},
+		{100003, 4, nfs.PROC4_COMPOUND, nfs.ACCEPT_GRABAGE_ARGS}, // no args
+		{100003, 3, nfs.ProcGetAttr, nfs.ACCEPT_GRABAGE_ARGS},    // no args
+		{100003, 4, nfs.PROC4_VOID, nfs.ACCEPT_SUCCESS},
+	}
+	for i, c := range calls {
+		xid := uint32(i + 1)
+		conn.Write(callRecord(xid, c.prog, c.vers, c.proc))
+		if rxid, stat := readReply(t, conn); rxid != xid || stat != c.stat {
+			t.Fatalf("call %d: expects xid=%d, stat=%d but get xid=%d, stat=%d",
+				i, xid, c.stat, rxid, stat)
+		}
+	}
+}
+
+func TestCallReplyTooLarge(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer l.Close()
+
+	// A client announcing a huge reply, sending it by small fragments.
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := xdr.NewReader(conn)
+		frag, _ := r.ReadUint32()
+		r.ReadBytes(int(frag &^ lastFragment))
+
+		w := xdr.NewWriter(conn)
+		chunk := make([]byte, 4096)
+		for i := 0; i < 1024; i++ {
+			if _, err := w.WriteUint32(uint32(len(chunk))); err != nil {
+				return
+			}
+			if _, err := conn.Write(chunk); err != nil {
+				return
+			}
+		}
+	}()
+
+	c := &rpcClient{host: "127.0.0.1"}
+	if _, err := c.call(listenerPort(l), nfs.PROG_NLM, 4, nfs.NLMPROC4_GRANTED); err == nil {
+		t.Fatalf("expects the reply to be refused")
+	}
+}
@@ -3,13 +3,17 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"io"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/smallfz/libnfs-go/log"
 	"github.com/smallfz/libnfs-go/nfs"
+	v4 "github.com/smallfz/libnfs-go/nfs/implv4"
 	"github.com/smallfz/libnfs-go/xdr"
 )
 
@@ -22,13 +26,80 @@ type rpcHeader struct {
 	Type uint32
 }
 
+// lastFragment is the bit set in the header of a record's last fragment.
+const lastFragment = uint32(1 << 31)
+
+// recordTooLargeError reports a record exceeding the session's limit.
+type recordTooLargeError struct {
+	size      int64
+	limit     int
+	xid       uint32
+	replyable bool // the record starts with a call header
+}
+
+func (err *recordTooLargeError) Error() string {
+	return fmt.Sprintf(
+		"record too large: %d bytes(limit: %d)", err.size, err.limit,
+	)
+}
+
 type Session struct {
 	conn    net.Conn
 	backend nfs.Backend
+
+	maxRecordSize int
+	maxInFlight   int
+
+	programs   []program // the programs answered.
+	advertised []program // the programs advertised by the port mapper.
+
+	exports []string
+	mounts  *mountTable
+	locks   *lockManager
+	state   *v4.State
+
+	// wmu serializes the replies of concurrent calls.
+	wmu sync.Mutex
+
+	mu      sync.Mutex
+	idle    bool // waiting for the next request
+	closing bool // asked to stop by Server.Shutdown
+}
+
+// setIdle marks whether the session is waiting for the next request.
+// It reports false once the session has been asked to stop.
+func (sess *Session) setIdle(idle bool) bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.idle = idle
+	return !sess.closing
+}
+
+// shutdown asks the session to stop once the call in progress (if any)
+// has been replied. An idle session is woken up from its pending read.
+func (sess *Session) shutdown() {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	sess.closing = true
+	if sess.idle {
+		sess.conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (sess *Session) isClosing() bool {
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+
+	return sess.closing
 }
 
 func (sess *Session) sendResponse(dat []byte) error {
-	frag := uint32(len(dat)) | uint32(1<<31)
+	sess.wmu.Lock()
+	defer sess.wmu.Unlock()
+
+	frag := uint32(len(dat)) | lastFragment
 	writer := xdr.NewWriter(sess.conn)
 	if _, err := writer.WriteUint32(frag); err != nil {
 		return err
@@ -39,6 +110,149 @@ func (sess *Session) sendResponse(dat []byte) error {
 	return nil
 }
 
+// sendReply encodes the parts of a reply message and sends it.
+func (sess *Session) sendReply(seq ...interface{}) error {
+	buff := bytes.NewBuffer([]byte{})
+	writer := xdr.NewWriter(buff)
+
+	for _, v := range seq {
+		if _, err := writer.WriteAny(v); err != nil {
+			return err
+		}
+	}
+	return sess.sendResponse(buff.Bytes())
+}
+
+// recordReader reads the record of a call: reading past its end fails
+// with nfs.ErrGarbageArgs, the arguments of the call being shorter than
+// their type.
+type recordReader struct {
+	r io.Reader
+}
+
+func (r *recordReader) Read(buf []byte) (int, error) {
+	n, err := r.r.Read(buf)
+	if err == io.EOF {
+		err = nfs.ErrGarbageArgs
+	}
+	return n, err
+}
+
+// sendAcceptStat replies an accepted message carrying no result but the
+// accept_stat and its optional details (e.g. the versions of a PROG_MISMATCH).
+func (sess *Session) sendAcceptStat(xid uint32, acceptStat uint32, details ...interface{}) error {
+	seq := []interface{}{
+		&nfs.RPCMsgReply{
+			Xid:       xid,
+			MsgType:   nfs.RPC_REPLY,
+			ReplyStat: nfs.MSG_ACCEPTED,
+		},
+		nfs.NewEmptyAuth(),
+		acceptStat,
+	}
+	return sess.sendReply(append(seq, details...)...)
+}
+
+// callXid returns the xid of a record if it starts like a rpc call.
+func callXid(rec []byte) (uint32, bool) {
+	if len(rec) >= 8 && binary.BigEndian.Uint32(rec[4:8]) == nfs.RPC_CALL {
+		return binary.BigEndian.Uint32(rec[0:4]), true
+	}
+	return 0, false
+}
+
+// readRecord reads a whole rpc record whose first fragment header is frag,
+// reassembling all of its fragments into one (RFC 5531, section 11).
+//
+// A record longer than maxRecordSize is never buffered: it is drained
+// from the connection and reported as a *recordTooLargeError.
+func (sess *Session) readRecord(r *xdr.Reader, frag uint32) ([]byte, error) {
+	rec := []byte{}
+	for {
+		size := int(frag &^ lastFragment)
+		if len(rec)+size > sess.maxRecordSize {
+			return nil, sess.skipRecord(r, frag, rec)
+		}
+
+		dat, err := r.ReadBytes(size)
+		if err != nil {
+			return nil, fmt.Errorf("ReadBytes(%d): %v", size, err)
+		}
+		rec = append(rec, dat...)
+
+		if frag&lastFragment != 0 {
+			return rec, nil
+		}
+
+		frag, err = r.ReadUint32()
+		if err != nil {
+			return nil, fmt.Errorf("ReadUint32: %v", err)
+		}
+	}
+}
+
+// skipRecord discards the rest of an oversized record. It keeps the
+// leading bytes needed to identify the call so that it can be rejected.
+func (sess *Session) skipRecord(r *xdr.Reader, frag uint32, rec []byte) error {
+	rs := &recordTooLargeError{
+		size:  int64(len(rec)),
+		limit: sess.maxRecordSize,
+	}
+
+	for {
+		size := int64(frag &^ lastFragment)
+		rs.size += size
+
+		if need := int64(8 - len(rec)); need > 0 {
+			if need > size {
+				need = size
+			}
+			dat, err := r.ReadBytes(int(need))
+			if err != nil {
+				return fmt.Errorf("ReadBytes(%d): %v", need, err)
+			}
+			rec = append(rec, dat...)
+			size -= need
+		}
+
+		if _, err := io.CopyN(io.Discard, r, size); err != nil {
+			return fmt.Errorf("discard(%d): %v", size, err)
+		}
+
+		if frag&lastFragment != 0 {
+			break
+		}
+
+		v, err := r.ReadUint32()
+		if err != nil {
+			return fmt.Errorf("ReadUint32: %v", err)
+		}
+		frag = v
+	}
+
+	rs.xid, rs.replyable = callXid(rec)
+	return rs
+}
+
+// program returns the program answered by the session, if any.
+func (sess *Session) program(prog uint32) (program, bool) {
+	for _, p := range sess.programs {
+		if p.prog == prog {
+			return p, true
+		}
+	}
+	return program{}, false
+}
+
+// remoteHost returns the address of the client, without the port.
+func remoteHost(conn net.Conn) string {
+	addr := conn.RemoteAddr().String()
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
+
 func (sess *Session) Conn() net.Conn {
 	return sess.conn
 }
@@ -50,115 +264,222 @@ func (sess *Session) Start(ctx context.Context) error {
 		log.Debugf("Disconnected from %v.", conn.RemoteAddr())
 	}()
 
-	backendSession := sess.backend.CreateSession(sess)
-	defer backendSession.Close()
+	// A session that only answers the port mapper needs no backend.
+	backendSession := (nfs.BackendSession)(nil)
+	if _, ok := sess.program(nfs.PROG_NFS); ok {
+		backendSession = sess.backend.CreateSession(sess)
+		defer backendSession.Close()
+	}
 
-	auth := backendSession.Authentication()
-	vfs := backendSession.GetFS()
-	stat := backendSession.GetStatService()
+	// Server.Close cancels ctx: drop the connection right away.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
 
-	reader := xdr.NewReader(conn)
+	// Calls still in progress are replied before the connection
+	// and the backend session get closed.
+	calls := &sync.WaitGroup{}
+	defer calls.Wait()
+
+	slots := make(chan struct{}, sess.maxInFlight)
+
+	connReader := xdr.NewReader(conn)
 
 	for {
-		frag, err := reader.ReadUint32()
+		if !sess.setIdle(true) {
+			return nil
+		}
+
+		frag, err := connReader.ReadUint32()
 		if err != nil {
-			if err == io.EOF {
+			if err == io.EOF || sess.isClosing() || ctx.Err() != nil {
 				return nil
 			}
+			return fmt.Errorf("ReadUint32: %v", err)
 		}
 
-		if frag&(1<<31) == 0 {
-			return errors.New("(!)ignored: fragmented request")
+		sess.setIdle(false)
+
+		rec, err := sess.readRecord(connReader, frag)
+		if err != nil {
+			var tooLarge *recordTooLargeError
+			if errors.As(err, &tooLarge) && tooLarge.replyable {
+				log.Warnf("%v: rejecting call(xid=%d).", err, tooLarge.xid)
+				if err := sess.sendAcceptStat(tooLarge.xid, nfs.ACCEPT_GRABAGE_ARGS); err != nil {
+					return fmt.Errorf("sendResponse: %v", err)
+				}
+				continue
+			}
+			return err
 		}
 
-		headerSize := (frag << 1) >> 1
-		restSize := int(headerSize)
+		// Wait for a free slot: this is where a busy connection
+		// stops reading and lets the client back off.
+		slots <- struct{}{}
+		calls.Add(1)
 
-		header := &nfs.RPCMsgCall{}
-		if size, err := reader.ReadAs(header); err != nil {
-			return fmt.Errorf("ReadAs(%T): %v", header, err)
-		} else {
-			restSize -= size
-		}
+		go func() {
+			defer func() {
+				<-slots
+				calls.Done()
+			}()
+
+			if err := sess.handleCall(rec, backendSession); err != nil {
+				log.Errorf("handleCall: %v", err)
+				conn.Close()
+			}
+		}()
+	}
+}
+
+// handleCall decodes a call record, dispatches it to the mux of its
+// program and version and sends the reply.
+//
+// A call that can't be served is replied with the matching rpc error
+// (PROG_UNAVAIL, PROG_MISMATCH, PROC_UNAVAIL, GARBAGE_ARGS or
+// SYSTEM_ERR) and the connection is kept. Only a failure to send the
+// reply is returned.
+func (sess *Session) handleCall(rec []byte, backendSession nfs.BackendSession) error {
+	restSize := len(rec)
+	reader := xdr.NewReader(&recordReader{r: bytes.NewReader(rec)})
 
-		if header.MsgType != nfs.RPC_CALL {
-			return errors.New("expecting a rpc call message")
+	header := &nfs.RPCMsgCall{}
+	if size, err := reader.ReadAs(header); err != nil {
+		xid, ok := callXid(rec)
+		if !ok {
+			log.Warnf("ReadAs(%T): %v: message dropped.", header, err)
+			return nil
 		}
+		log.Warnf("ReadAs(%T): %v: rejecting call(xid=%d).", header, err, xid)
+		return sess.sendAcceptStat(xid, nfs.ACCEPT_GRABAGE_ARGS)
+	} else {
+		restSize -= size
+	}
 
-		// log.Infof("header: %v", header)
+	if header.MsgType != nfs.RPC_CALL {
+		log.Warnf("expecting a rpc call message: message dropped.")
+		return nil
+	}
 
-		mux := (SessionMux)(nil)
+	if header.RPCVer != 2 {
+		return sess.sendReply(
+			&nfs.RPCMsgReply{
+				Xid:       header.Xid,
+				MsgType:   nfs.RPC_REPLY,
+				ReplyStat: nfs.MSG_DENIED,
+			},
+			&nfs.RejectReply{
+				RejectStat: nfs.REJECT_RPC_MISMATCH,
+				Lo:         2,
+				Hi:         2,
+			},
+		)
+	}
 
-		buff := bytes.NewBuffer([]byte{})
-		writer := xdr.NewWriter(buff)
+	// log.Infof("header: %v", header)
 
-		switch header.Vers {
-		case 4:
-			mux = &Muxv4{
-				reader: reader,
-				writer: writer,
-				auth:   auth,
-				fs:     vfs,
-				stat:   stat,
-			}
+	prog, ok := sess.program(header.Prog)
+	if !ok {
+		log.Warnf("%v: program unavailable.", header)
+		return sess.sendAcceptStat(header.Xid, nfs.ACCEPT_PROG_UNAVAIL)
+	}
+	if header.Vers < prog.low || header.Vers > prog.high {
+		log.Warnf("%v: version mismatch.", header)
+		return sess.sendAcceptStat(
+			header.Xid, nfs.ACCEPT_PROG_MISMATCH, prog.low, prog.high,
+		)
+	}
 
-		case 3:
-			mux = &Mux{
-				reader: reader,
-				writer: writer,
-				auth:   auth,
-				fs:     vfs,
-				stat:   stat,
-			}
+	mux := (SessionMux)(nil)
 
-		default:
-			seq := []interface{}{
-				&nfs.RPCMsgReply{
-					Xid:       header.Xid,
-					MsgType:   nfs.RPC_REPLY,
-					ReplyStat: nfs.MSG_ACCEPTED,
-				},
-				nfs.NewEmptyAuth(),
-				nfs.ACCEPT_PROG_MISMATCH,
-				uint32(3), // low:  v3
-				uint32(4), // high: v4
-			}
-			for _, v := range seq {
-				if _, err := writer.WriteAny(v); err != nil {
-					return err
-				}
-			}
+	buff := bytes.NewBuffer([]byte{})
+	writer := xdr.NewWriter(buff)
+
+	switch {
+	case header.Prog == nfs.PROG_PMAP:
+		mux = &portmapMux{
+			reader:   reader,
+			writer:   writer,
+			addr:     sess.conn.LocalAddr(),
+			programs: sess.advertised,
 		}
 
-		if mux != nil {
-			if size, err := mux.HandleProc(header); err != nil {
-				return fmt.Errorf("mux.HandlerProc(%d): %v", header.Proc, err)
-			} else {
-				restSize -= size
-			}
-		} else {
-			return errors.New("invalid rpc message: no suitable mux")
+	case header.Prog == nfs.PROG_MOUNT:
+		mux = &mountMux{
+			reader:  reader,
+			writer:  writer,
+			auth:    backendSession.Authentication(),
+			fs:      backendSession.GetFS(),
+			host:    remoteHost(sess.conn),
+			exports: sess.exports,
+			mounts:  sess.mounts,
 		}
 
-		if err := sess.sendResponse(buff.Bytes()); err != nil {
-			return fmt.Errorf("sendResponse: %v", err)
+	case header.Prog == nfs.PROG_NLM:
+		mux = &nlmMux{
+			reader: reader,
+			writer: writer,
+			auth:   backendSession.Authentication(),
+			fs:     backendSession.GetFS(),
+			addr:   remoteHost(sess.conn),
+			mgr:    sess.locks,
 		}
 
-		if restSize > 0 {
-			log.Warnf("%d bytes unread.", restSize)
-			if _, err := reader.ReadBytes(restSize); err != nil {
-				if err == io.EOF {
-					return nil
-				}
-			}
+	case header.Prog == nfs.PROG_NSM:
+		mux = &nsmMux{
+			reader: reader,
+			writer: writer,
+			addr:   remoteHost(sess.conn),
+			mgr:    sess.locks,
+		}
+
+	case header.Vers == 4:
+		mux = &Muxv4{
+			reader: reader,
+			writer: writer,
+			auth:   backendSession.Authentication(),
+			fs:     backendSession.GetFS(),
+			stat:   newCallStat(backendSession.GetStatService()),
+			state:  sess.state,
+		}
+
+	default:
+		mux = &Mux{
+			reader: reader,
+			writer: writer,
+			auth:   backendSession.Authentication(),
+			fs:     backendSession.GetFS(),
+			stat:   newCallStat(backendSession.GetStatService()),
+			locks:  sess.locks.locks,
 		}
 	}
-}
 
-func handleSession(ctx context.Context, backend nfs.Backend, conn net.Conn) error {
-	sess := &Session{
-		conn:    conn,
-		backend: backend,
+	if size, err := mux.HandleProc(header); err != nil {
+		acceptStat := nfs.ACCEPT_SYSTEM_ERR
+		switch {
+		case errors.Is(err, nfs.ErrProcUnavail):
+			acceptStat = nfs.ACCEPT_PROC_UNAVAIL
+		case errors.Is(err, nfs.ErrGarbageArgs):
+			acceptStat = nfs.ACCEPT_GRABAGE_ARGS
+		}
+		log.Warnf("mux.HandleProc(%v): %v", header, err)
+		return sess.sendAcceptStat(header.Xid, acceptStat)
+	} else {
+		restSize -= size
+	}
+
+	if err := sess.sendResponse(buff.Bytes()); err != nil {
+		return fmt.Errorf("sendResponse: %v", err)
 	}
-	return sess.Start(ctx)
+
+	if restSize > 0 {
+		log.Warnf("%d bytes unread.", restSize)
+	}
+	return nil
 }
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"github.com/smallfz/libnfs-go/nfs"
+)
+
+// callStat is the StatService seen by a single call. The opened files
+// and the client id are shared with the connection, while the current
+// and saved filehandles belong to the call: concurrent COMPOUNDs of a
+// connection must not see each other's filehandles (RFC 7530, section 16.2.3).
+type callStat struct {
+	nfs.StatService
+
+	current     nfs.FileHandle4
+	handleStack []nfs.FileHandle4
+}
+
+var _ nfs.StatService = (*callStat)(nil)
+
+func newCallStat(stat nfs.StatService) *callStat {
+	return &callStat{StatService: stat}
+}
+
+func (t *callStat) SetCurrentHandle(fh nfs.FileHandle4) {
+	t.current = fh
+}
+
+func (t *callStat) CurrentHandle() nfs.FileHandle4 {
+	if t.current == nil {
+		t.current = []byte{}
+	}
+	return t.current
+}
+
+func (t *callStat) PushHandle(item nfs.FileHandle4) {
+	t.handleStack = append(t.handleStack, item)
+}
+
+func (t *callStat) PeekHandle() (nfs.FileHandle4, bool) {
+	if len(t.handleStack) == 0 {
+		return nil, false
+	}
+	return t.handleStack[len(t.handleStack)-1], true
+}
+
+func (t *callStat) PopHandle() (nfs.FileHandle4, bool) {
+	if len(t.handleStack) == 0 {
+		return nil, false
+	}
+
+	size := len(t.handleStack)
+	last := t.handleStack[size-1]
+	t.handleStack = t.handleStack[:size-1]
+	return last, true
+}
@@ -12,3 +12,11 @@ func RandUint32() uint32 {
 	}
 	return binary.BigEndian.Uint32(b)
 }
+
+func RandUint64() uint64 {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(b)
+}
@@ -312,7 +312,7 @@ func (r *Reader) ReadValue(v reflect.Value) (int, error) {
 
 			if size, err := r.ReadValue(pToFv); err != nil {
 				return sizeConsumed, fmt.Errorf(
-					"ReadValue(field:%s): %v", field.Name, err,
+					"ReadValue(field:%s): %w", field.Name, err,
 				)
 			} else {
 				fv.Set(pToFv.Elem())